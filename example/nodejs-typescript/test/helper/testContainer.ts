@@ -17,13 +17,7 @@ export class TestContainer {
 
   async setup(): Promise<void> {
     try {
-      await this.kubectl.applyManifest('manifests/mongodb.yml');
-      await this.kubectl.waitForPodReady('app=mongodb');
-      
-      await this.kubectl.applyManifest('manifests/example-http-server.yml');
-      await this.kubectl.waitForPodReady('app=example-http-server');
-      
-      await this.kubectl.waitForServiceReady('mongodb');
+      // manifests/ is applied as ket fixtures and is ready before the tests start
       await this.mongo.connect();
       
       await this.mockService.startMockService('app=example-http-server');
@@ -36,9 +30,6 @@ export class TestContainer {
     try {
       await this.mockService.stopMockService();
       await this.mongo.disconnect();
-      
-      await this.kubectl.deleteManifest('manifests/example-http-server.yml');
-      await this.kubectl.deleteManifest('manifests/mongodb.yml');
     } catch (error) {
       console.warn('Warning: Failed to teardown test environment:', error);
     }
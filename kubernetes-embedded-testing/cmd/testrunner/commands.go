@@ -41,6 +41,12 @@ WORKFLOW:
 	envCmd := createEnvCommand()
 	rootCmd.AddCommand(envCmd)
 
+	gcCmd := createGCCommand(ctx)
+	rootCmd.AddCommand(gcCmd)
+
+	doctorCmd := createDoctorCommand(ctx)
+	rootCmd.AddCommand(doctorCmd)
+
 	return rootCmd
 }
 
@@ -98,7 +104,7 @@ func executeLaunch(ctx context.Context, cmd *cobra.Command) error {
 	if err := launcher.RunLaunch(*cfg); err != nil {
 		if testErr, ok := err.(*launcher.TestExecutionError); ok {
 			testExitCode = testErr.ExitCode
-			return fmt.Errorf("test execution failed with exit code %d: %s", testErr.ExitCode, testErr.Message)
+			return fmt.Errorf("test execution failed (%s) with exit code %d: %s", testErr.Category, testErr.ExitCode, testErr.Message)
 		}
 		return fmt.Errorf("launch failed: %w", err)
 	}
@@ -121,6 +127,7 @@ OUTPUT:
   - Namespace for test isolation
   - ServiceAccount with appropriate RBAC permissions
   - Role and RoleBinding for test runner access
+  - Fixtures listed in the config, placed in the test namespace
   - Job specification for test execution
 
 EXAMPLES:
@@ -162,6 +169,110 @@ func executeManifest(ctx context.Context, cmd *cobra.Command) error {
 	return nil
 }
 
+// createGCCommand creates the gc command
+func createGCCommand(ctx context.Context) *cobra.Command {
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete expired or orphaned ket namespaces, jobs and RBAC",
+		Long: `Delete ket resources left behind by crashed or killed runs.
+
+Every namespace, job and cluster RBAC object ket creates is labelled 
+app.kubernetes.io/managed-by=ket and annotated with its run ID, creation 
+time, TTL and owner. This command lists those resources and deletes the ones 
+whose TTL has expired, that are older than --older-than, or cluster RBAC 
+whose test namespace no longer exists.
+
+EXAMPLES:
+  # Show what would be deleted
+  ket gc --dry-run
+  
+  # Delete everything ket created more than two hours ago
+  ket gc --older-than 2h
+  
+  # Only consider the resources of one test namespace
+  ket gc --selector ket.io/test-namespace=kubernetes-embedded-test-a1b2c3d4`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeGC(ctx, cmd)
+		},
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	addGCFlags(gcCmd)
+
+	return gcCmd
+}
+
+// executeGC handles the gc command execution
+func executeGC(ctx context.Context, cmd *cobra.Command) error {
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("operation cancelled")
+	default:
+	}
+
+	cfg := buildConfig(cmd)
+	cfg.Ctx = ctx
+
+	if err := launcher.RunGC(*cfg); err != nil {
+		return fmt.Errorf("gc failed: %w", err)
+	}
+	return nil
+}
+
+// createDoctorCommand creates the doctor command
+func createDoctorCommand(ctx context.Context) *cobra.Command {
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that you are allowed to launch tests in the cluster",
+		Long: `Check that you are allowed to do everything launch does, without creating anything.
+
+This command asks the API server, with SelfSubjectAccessReviews, whether the 
+current user may create the test namespace, ServiceAccount, Roles, bindings, 
+secrets, fixtures and jobs, read the test output and clean everything up, and 
+with a SelfSubjectRulesReview whether they hold the rules granted to the test 
+runner, or may escalate and bind roles instead. It prints a table of every permission and fails if any is missing. launch runs 
+the same check before creating anything, unless --skip-preflight is set.
+
+EXAMPLES:
+  # Check the permissions of the current kubeconfig context
+  ket doctor
+  
+  # Check the permissions a run with extra cluster-wide rules needs
+  ket doctor --rbac-profile crd-author
+  
+  # Check the permissions of another user
+  ket doctor --as ci-bot`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeDoctor(ctx, cmd)
+		},
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	// Takes the launch flags, since the permissions needed depend on the run
+	addLaunchFlags(doctorCmd)
+
+	return doctorCmd
+}
+
+// executeDoctor handles the doctor command execution
+func executeDoctor(ctx context.Context, cmd *cobra.Command) error {
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("operation cancelled")
+	default:
+	}
+
+	cfg := buildConfig(cmd)
+	cfg.Ctx = ctx
+
+	if err := launcher.RunDoctor(*cfg); err != nil {
+		return fmt.Errorf("doctor failed: %w", err)
+	}
+	return nil
+}
+
 // createEnvCommand creates the environment variables documentation command
 func createEnvCommand() *cobra.Command {
 	envCmd := &cobra.Command{
@@ -208,17 +319,34 @@ func showEnvironmentDocumentation() {
 	fmt.Println("    Example:     /workspace")
 	fmt.Println("    Usage:       Use this to reference the mounted source code location")
 	fmt.Println()
+	fmt.Println("  KET_SHARD_INDEX")
+	fmt.Println("    Description: The 0-based index of the shard this pod runs")
+	fmt.Println("    Example:     0 (always 0 unless launched with --shards)")
+	fmt.Println("    Usage:       Use this to select the tests this shard runs")
+	fmt.Println()
+	fmt.Println("  KET_SHARD_TOTAL")
+	fmt.Println("    Description: The number of shards the suite is split across")
+	fmt.Println("    Example:     4 (1 unless launched with --shards)")
+	fmt.Println("    Usage:       Use this with KET_SHARD_INDEX to split the suite")
+	fmt.Println()
+	fmt.Println("  Custom variables")
+	fmt.Println("    Description: Variables from --env-file, --forward-env and --env (env, envFrom and")
+	fmt.Println("                 forwardEnv in the config file), later sources overriding earlier ones")
+	fmt.Println("    Usage:       Pass API tokens, feature flags or CI settings to your tests;")
+	fmt.Println("                 use secrets in the config file for values that must not appear in manifests")
+	fmt.Println()
 	fmt.Println("VOLUME MOUNTS")
 	fmt.Println()
 	fmt.Println("  /workspace")
-	fmt.Println("    Description: Your source code mounted from the host")
-	fmt.Println("    Type:        HostPath volume")
+	fmt.Println("    Description: Your source code, delivered according to --source-mode")
+	fmt.Println("    Type:        HostPath (hostPath), EmptyDir (upload, git) or PVC (pvc) volume")
 	fmt.Println("    Usage:       Contains your project files for testing")
 	fmt.Println()
 	fmt.Println("  /reports")
 	fmt.Println("    Description: Empty directory for test reports and artifacts")
 	fmt.Println("    Type:        EmptyDir volume")
 	fmt.Println("    Usage:       Write test results, coverage reports, etc. here")
+	fmt.Println("                 Copied back to the host when launched with --reports-dir")
 	fmt.Println()
 	fmt.Println("EXAMPLE USAGE IN TEST SCRIPTS")
 	fmt.Println()
@@ -229,6 +357,9 @@ func showEnvironmentDocumentation() {
 	fmt.Println("  # Set working directory")
 	fmt.Println("  cd ${KET_WORKSPACE_PATH}/${KET_PROJECT_ROOT}")
 	fmt.Println()
+	fmt.Println("  # Run this shard's share of the tests")
+	fmt.Println("  npx jest --shard=$((KET_SHARD_INDEX + 1))/${KET_SHARD_TOTAL}")
+	fmt.Println()
 	fmt.Println("  # Write test reports")
 	fmt.Println("  echo 'Test completed' > /reports/test-results.txt")
 	fmt.Println("  cp coverage.xml /reports/")
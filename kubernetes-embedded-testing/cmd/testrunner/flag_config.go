@@ -10,12 +10,15 @@ type FlagConfig struct {
 	ViperKey    string
 	Description string
 	Default     interface{}
+	// NoSplit keeps commas in the values of a []string flag instead of splitting on them
+	NoSplit bool
 }
 
 // FlagMapping contains all flag configurations organized by command type
 var FlagMapping = struct {
 	RootFlags   map[string]FlagConfig
 	LaunchFlags map[string]FlagConfig
+	GCFlags     map[string]FlagConfig
 }{
 	RootFlags: map[string]FlagConfig{
 		"project-root": {
@@ -55,6 +58,36 @@ var FlagMapping = struct {
 			Description: "Show timestamps in logs",
 			Default:     false,
 		},
+		"kubeconfig": {
+			ViperKey:    "cluster.kubeconfig",
+			Description: "Path to the kubeconfig file to use instead of the default loading rules ($KUBECONFIG, ~/.kube/config)",
+			Default:     "",
+		},
+		"context": {
+			ViperKey:    "cluster.context",
+			Description: "Name of the kubeconfig context to use instead of the current context",
+			Default:     "",
+		},
+		"as": {
+			ViperKey:    "cluster.as",
+			Description: "Username to impersonate for all Kubernetes API requests",
+			Default:     "",
+		},
+		"as-group": {
+			ViperKey:    "cluster.asGroups",
+			Description: "Group to impersonate for all Kubernetes API requests, can be repeated",
+			Default:     []string{},
+		},
+		"qps": {
+			ViperKey:    "cluster.qps",
+			Description: "Maximum queries per second to the Kubernetes API (0 uses the client-go default)",
+			Default:     float32(0),
+		},
+		"burst": {
+			ViperKey:    "cluster.burst",
+			Description: "Maximum burst of requests to the Kubernetes API (0 uses the client-go default)",
+			Default:     0,
+		},
 	},
 	LaunchFlags: map[string]FlagConfig{
 		"image": {
@@ -64,10 +97,38 @@ var FlagMapping = struct {
 			Default: "atidyshirt/kubernetes-embedded-test-runner-base:latest",
 		},
 		"rbac": {
-			ViperKey:    "rbac",
-			Description: "Extra Yaml configuration to load into the rbac options",
+			ViperKey: "rbac",
+			Description: "RBAC file or glob of extra rules for the test runner: a list of rules, a file with rules and clusterRules,\n" +
+				"or Role and ClusterRole documents. Can be repeated.",
+			Default: []string{},
+		},
+		"rbac-profile": {
+			ViperKey: "rbacProfile",
+			Description: "Base rules of the test runner, extended by the RBAC files: none, read-only, default,\n" +
+				"namespace-admin (everything in the test namespace) or crd-author (default plus managing CRDs).",
+			Default: "",
+		},
+		"rbac-replace-defaults": {
+			ViperKey:    "rbacReplaceDefaults",
+			Description: "Grant the test runner only the rules of the RBAC files, instead of extending the profile's rules.",
+			Default:     false,
+		},
+		"rbac-audit": {
+			ViperKey: "rbacAudit.enabled",
+			Description: "Record the API requests the test runner makes through a proxy sidecar, then print the rules they needed\n" +
+				"as a suggested RBAC file along with the granted rules that went unused.",
+			Default: false,
+		},
+		"rbac-audit-output": {
+			ViperKey:    "rbacAudit.output",
+			Description: "Also write the RBAC file suggested by --rbac-audit to this local file",
 			Default:     "",
 		},
+		"skip-preflight": {
+			ViperKey:    "skipPreflight",
+			Description: "Skip checking that you may create and clean up the test resources before creating any of them.",
+			Default:     false,
+		},
 		"test-command": {
 			ViperKey:    "testCommand",
 			Description: "Command to execute inside the test runner pod (e.g., 'mocha **/*.spec.ts').",
@@ -83,12 +144,128 @@ var FlagMapping = struct {
 			Description: "Maximum number of retry attempts for a failed Kubernetes job.",
 			Default:     int32(1),
 		},
+		"shards": {
+			ViperKey: "shards",
+			Description: "Number of test jobs to split the suite across, run in parallel in the test namespace.\n" +
+				"Each job receives KET_SHARD_INDEX (0-based) and KET_SHARD_TOTAL to select its share of the tests.",
+			Default: int32(1),
+		},
+		"env": {
+			ViperKey:    "env",
+			Description: "Environment variable KEY=VALUE set in the test container, can be repeated.",
+			Default:     []string{},
+			NoSplit:     true,
+		},
+		"env-file": {
+			ViperKey:    "envFrom",
+			Description: "Local .env file whose variables are set in the test container, can be repeated.",
+			Default:     []string{},
+		},
+		"forward-env": {
+			ViperKey:    "forwardEnv",
+			Description: "Host environment variable forwarded to the test container when set, can be repeated.",
+			Default:     []string{},
+		},
+		"reports-dir": {
+			ViperKey: "reportsDir",
+			Description: "Local directory to copy the contents of /reports into after the test container exits.\n" +
+				"Reports are not collected when empty.",
+			Default: "",
+		},
+		"source-mode": {
+			ViperKey: "source.mode",
+			Description: "How the project source reaches the test runner pod: hostPath, upload, git or pvc.\n" +
+				"hostPath requires the source to be mounted into the cluster nodes (e.g. Kind extraMounts).",
+			Default: "hostPath",
+		},
+		"source-git-repository": {
+			ViperKey:    "source.git.repository",
+			Description: "Git repository to clone when --source-mode=git",
+			Default:     "",
+		},
+		"source-git-ref": {
+			ViperKey:    "source.git.ref",
+			Description: "Branch, tag or commit to check out when --source-mode=git",
+			Default:     "",
+		},
+		"source-pvc": {
+			ViperKey: "source.pvc.claimName",
+			Description: "Existing PersistentVolumeClaim holding the source when --source-mode=pvc.\n" +
+				"The claim must live in the test namespace, so use together with --namespace. A namespace that already\n" +
+				"exists is not deleted after the run, so the claim is kept.",
+			Default: "",
+		},
+		"results-format": {
+			ViperKey:    "results.format",
+			Description: "Format of the results file written to /reports by the test command: junit or tap",
+			Default:     "",
+		},
+		"results-file": {
+			ViperKey:    "results.file",
+			Description: "Path of the results file, relative to /reports",
+			Default:     "",
+		},
+		"summary-file": {
+			ViperKey:    "summaryFile",
+			Description: "Write a JSON summary of the run to this local file",
+			Default:     "",
+		},
+		"diagnostics-dir": {
+			ViperKey: "diagnosticsDir",
+			Description: "On failure, write a diagnostics bundle (events, pod status, logs and YAML of the test namespace)\n" +
+				"into this directory, or into a tarball if the path ends in .tar.gz",
+			Default: "",
+		},
+		"fixture": {
+			ViperKey: "fixtures",
+			Description: "Manifest file, directory or kustomization applied into the test namespace before the test job starts.\n" +
+				"Can be repeated. Deployments, statefulsets, jobs and pods are waited on until ready.",
+			Default: []string{},
+		},
+		"fixtures-timeout-seconds": {
+			ViperKey:    "fixturesTimeoutS",
+			Description: "Maximum time in seconds to wait for fixtures to become ready.",
+			Default:     int64(300),
+		},
+		"startup-timeout-seconds": {
+			ViperKey: "startupTimeoutS",
+			Description: "Maximum time in seconds the test pod may go without progress while starting. Pulling images\n" +
+				"and waiting for the cluster autoscaler count as progress.",
+			Default: int64(120),
+		},
+		"ttl": {
+			ViperKey:    "ttl",
+			Description: "How long the run's namespace, job and RBAC may live before `ket gc` reaps them (e.g. 24h).",
+			Default:     "24h",
+		},
+		"cleanup-timeout-seconds": {
+			ViperKey:    "cleanupTimeoutS",
+			Description: "Maximum time in seconds to wait for the job, RBAC and namespace to be removed after the run or on Ctrl-C.",
+			Default:     int64(60),
+		},
 		"active-deadline-seconds": {
 			ViperKey:    "activeDeadlineS",
 			Description: "Maximum duration in seconds the job is allowed to run before termination.",
 			Default:     int64(1800),
 		},
 	},
+	GCFlags: map[string]FlagConfig{
+		"dry-run": {
+			ViperKey:    "gc.dryRun",
+			Description: "List the resources that would be deleted without deleting them",
+			Default:     false,
+		},
+		"older-than": {
+			ViperKey:    "gc.olderThan",
+			Description: "Also delete ket resources older than this duration (e.g. 2h), regardless of their TTL",
+			Default:     "0s",
+		},
+		"selector": {
+			ViperKey:    "gc.selector",
+			Description: "Label selector narrowing the ket resources considered (e.g. ket.io/test-namespace=my-ns)",
+			Default:     "",
+		},
+	},
 }
 
 // bindFlagsToViper binds flags to Viper using the configuration mapping
@@ -116,4 +293,9 @@ func bindFlagsToViper(v *viper.Viper, cmd *cobra.Command) {
 			v.BindPFlag(config.ViperKey, flag)
 		}
 	}
+	for flagName, config := range FlagMapping.GCFlags {
+		if flag := cmd.Flags().Lookup(flagName); flag != nil {
+			v.BindPFlag(config.ViperKey, flag)
+		}
+	}
 }
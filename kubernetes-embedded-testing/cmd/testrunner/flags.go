@@ -39,12 +39,26 @@ func addRootFlags(cmd *cobra.Command) {
 			cmd.PersistentFlags().Int32P(flagName, getShortFlag(flagName), v, config.Description)
 		case int64:
 			cmd.PersistentFlags().Int64P(flagName, getShortFlag(flagName), v, config.Description)
+		case int:
+			cmd.PersistentFlags().IntP(flagName, getShortFlag(flagName), v, config.Description)
+		case float32:
+			cmd.PersistentFlags().Float32P(flagName, getShortFlag(flagName), v, config.Description)
+		case []string:
+			cmd.PersistentFlags().StringSliceP(flagName, getShortFlag(flagName), v, config.Description)
 		}
 	}
 }
 
 func addLaunchFlags(cmd *cobra.Command) {
-	for flagName, config := range FlagMapping.LaunchFlags {
+	addCommandFlags(cmd, FlagMapping.LaunchFlags)
+}
+
+func addGCFlags(cmd *cobra.Command) {
+	addCommandFlags(cmd, FlagMapping.GCFlags)
+}
+
+func addCommandFlags(cmd *cobra.Command, flags map[string]FlagConfig) {
+	for flagName, config := range flags {
 		switch v := config.Default.(type) {
 		case string:
 			cmd.Flags().StringP(flagName, getShortFlag(flagName), v, config.Description)
@@ -54,6 +68,16 @@ func addLaunchFlags(cmd *cobra.Command) {
 			cmd.Flags().Int32P(flagName, getShortFlag(flagName), v, config.Description)
 		case int64:
 			cmd.Flags().Int64P(flagName, getShortFlag(flagName), v, config.Description)
+		case int:
+			cmd.Flags().IntP(flagName, getShortFlag(flagName), v, config.Description)
+		case float32:
+			cmd.Flags().Float32P(flagName, getShortFlag(flagName), v, config.Description)
+		case []string:
+			if config.NoSplit {
+				cmd.Flags().StringArrayP(flagName, getShortFlag(flagName), v, config.Description)
+			} else {
+				cmd.Flags().StringSliceP(flagName, getShortFlag(flagName), v, config.Description)
+			}
 		}
 	}
 }
@@ -92,6 +116,10 @@ func buildConfig(cmd *cobra.Command) *config.Config {
 		v.SetDefault(config.ViperKey, config.Default)
 	}
 
+	for _, config := range FlagMapping.GCFlags {
+		v.SetDefault(config.ViperKey, config.Default)
+	}
+
 	bindFlagsToViper(v, cmd)
 
 	cfg, err := config.LoadFromViper(v)
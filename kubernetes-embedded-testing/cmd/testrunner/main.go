@@ -36,7 +36,11 @@ func setupSignalHandling(ctx context.Context, cancel context.CancelFunc) {
 
 	go func() {
 		<-sigChan
-		fmt.Println("\nReceived interrupt signal, shutting down...")
+		fmt.Println("\nReceived interrupt signal, cleaning up test resources (interrupt again to force exit)...")
 		cancel()
+
+		<-sigChan
+		fmt.Fprintln(os.Stderr, "\nForced exit, test resources may have been left in the cluster")
+		os.Exit(130)
 	}()
 }
@@ -0,0 +1,98 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildTar(t *testing.T, entries map[string]string) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range entries {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0o644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return &buf
+}
+
+func TestExtract_WritesFiles(t *testing.T) {
+	dest := t.TempDir()
+	archive := buildTar(t, map[string]string{
+		"./junit.xml":            "<testsuites/>",
+		"./coverage/lcov.info":   "TN:",
+		"nested/dir/results.txt": "ok",
+	})
+
+	files, err := Extract(archive, dest)
+	require.NoError(t, err)
+	assert.Equal(t, 3, files)
+
+	content, err := os.ReadFile(filepath.Join(dest, "junit.xml"))
+	require.NoError(t, err)
+	assert.Equal(t, "<testsuites/>", string(content))
+
+	content, err = os.ReadFile(filepath.Join(dest, "coverage", "lcov.info"))
+	require.NoError(t, err)
+	assert.Equal(t, "TN:", string(content))
+
+	assert.FileExists(t, filepath.Join(dest, "nested", "dir", "results.txt"))
+}
+
+func TestExtract_RejectsPathTraversal(t *testing.T) {
+	dest := t.TempDir()
+	archive := buildTar(t, map[string]string{
+		"../escape.txt": "nope",
+	})
+
+	_, err := Extract(archive, dest)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "escapes destination directory")
+	assert.NoFileExists(t, filepath.Join(filepath.Dir(dest), "escape.txt"))
+}
+
+func TestExtract_CreatesDestination(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "reports", "run")
+	archive := buildTar(t, map[string]string{"report.txt": "done"})
+
+	_, err := Extract(archive, dest)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dest, "report.txt"))
+}
+
+func TestCreate_RoundTripsWithExcludes(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "src", "lib"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "node_modules", "dep"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "package.json"), []byte("{}"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "src", "lib", "index.ts"), []byte("export {}"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "node_modules", "dep", "index.js"), []byte(""), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "debug.log"), []byte(""), 0o644))
+
+	var buf bytes.Buffer
+	require.NoError(t, Create(&buf, src, []string{"node_modules", "*.log"}))
+
+	dest := t.TempDir()
+	files, err := Extract(&buf, dest)
+	require.NoError(t, err)
+	assert.Equal(t, 2, files)
+
+	assert.FileExists(t, filepath.Join(dest, "package.json"))
+	assert.FileExists(t, filepath.Join(dest, "src", "lib", "index.ts"))
+	assert.NoDirExists(t, filepath.Join(dest, "node_modules"))
+	assert.NoFileExists(t, filepath.Join(dest, "debug.log"))
+}
@@ -3,8 +3,10 @@ package config
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
 )
 
 type LoggingConfig struct {
@@ -12,6 +14,162 @@ type LoggingConfig struct {
 	Timestamp bool `mapstructure:"timestamp" yaml:"timestamp" json:"timestamp"`
 }
 
+// Source delivery modes for getting the project source into the test runner pod
+const (
+	SourceModeHostPath = "hostPath"
+	SourceModeUpload   = "upload"
+	SourceModeGit      = "git"
+	SourceModePVC      = "pvc"
+)
+
+// RBAC profiles selecting the base rules granted to the test runner
+const (
+	RBACProfileNone           = "none"
+	RBACProfileReadOnly       = "read-only"
+	RBACProfileDefault        = "default"
+	RBACProfileNamespaceAdmin = "namespace-admin"
+	RBACProfileCRDAuthor      = "crd-author"
+)
+
+type GitSourceConfig struct {
+	Repository string `mapstructure:"repository" yaml:"repository" json:"repository"`
+	Ref        string `mapstructure:"ref" yaml:"ref" json:"ref"`
+	Image      string `mapstructure:"image" yaml:"image" json:"image"`
+}
+
+type PVCSourceConfig struct {
+	ClaimName string `mapstructure:"claimName" yaml:"claimName" json:"claimName"`
+	SubPath   string `mapstructure:"subPath" yaml:"subPath" json:"subPath"`
+}
+
+type SourceConfig struct {
+	Mode      string          `mapstructure:"mode" yaml:"mode" json:"mode"`
+	LocalPath string          `mapstructure:"localPath" yaml:"localPath" json:"localPath"`
+	Exclude   []string        `mapstructure:"exclude" yaml:"exclude" json:"exclude"`
+	Git       GitSourceConfig `mapstructure:"git" yaml:"git" json:"git"`
+	PVC       PVCSourceConfig `mapstructure:"pvc" yaml:"pvc" json:"pvc"`
+}
+
+// HTTPGetProbeConfig probes a service with an HTTP GET request
+type HTTPGetProbeConfig struct {
+	Path string `mapstructure:"path" yaml:"path" json:"path"`
+	Port int32  `mapstructure:"port" yaml:"port" json:"port"`
+}
+
+// ProbeConfig checks whether a service is ready, by running a command in it, opening a TCP
+// connection or sending an HTTP request
+type ProbeConfig struct {
+	Exec             []string            `mapstructure:"exec" yaml:"exec" json:"exec"`
+	TCPPort          int32               `mapstructure:"tcpPort" yaml:"tcpPort" json:"tcpPort"`
+	HTTPGet          *HTTPGetProbeConfig `mapstructure:"httpGet" yaml:"httpGet" json:"httpGet"`
+	InitialDelayS    int32               `mapstructure:"initialDelayS" yaml:"initialDelayS" json:"initialDelayS"`
+	PeriodS          int32               `mapstructure:"periodS" yaml:"periodS" json:"periodS"`
+	TimeoutS         int32               `mapstructure:"timeoutS" yaml:"timeoutS" json:"timeoutS"`
+	FailureThreshold int32               `mapstructure:"failureThreshold" yaml:"failureThreshold" json:"failureThreshold"`
+}
+
+// ServiceConfig runs a service such as a database or mock server next to the test container,
+// reachable on localhost
+type ServiceConfig struct {
+	Name           string       `mapstructure:"name" yaml:"name" json:"name"`
+	Image          string       `mapstructure:"image" yaml:"image" json:"image"`
+	Command        []string     `mapstructure:"command" yaml:"command" json:"command"`
+	Args           []string     `mapstructure:"args" yaml:"args" json:"args"`
+	Ports          []int32      `mapstructure:"ports" yaml:"ports" json:"ports"`
+	Env            []string     `mapstructure:"env" yaml:"env" json:"env"`
+	ReadinessProbe *ProbeConfig `mapstructure:"readinessProbe" yaml:"readinessProbe" json:"readinessProbe"`
+}
+
+// Patch types for overlays on the generated objects
+const (
+	PatchTypeStrategic = "strategic"
+	PatchTypeJSON      = "json"
+)
+
+// PatchTarget selects the generated objects a patch applies to. Name is a glob pattern, and empty
+// fields match every object.
+type PatchTarget struct {
+	Kind string `mapstructure:"kind" yaml:"kind" json:"kind"`
+	Name string `mapstructure:"name" yaml:"name" json:"name"`
+}
+
+// PatchConfig is a strategic-merge or RFC 6902 JSON patch applied to the generated objects, given
+// inline as YAML or JSON in Patch, or read from the file at Path
+type PatchConfig struct {
+	Target PatchTarget `mapstructure:"target" yaml:"target" json:"target"`
+	Type   string      `mapstructure:"type" yaml:"type" json:"type"`
+	Patch  string      `mapstructure:"patch" yaml:"patch" json:"patch"`
+	Path   string      `mapstructure:"path" yaml:"path" json:"path"`
+}
+
+type ResultsConfig struct {
+	Format string `mapstructure:"format" yaml:"format" json:"format"`
+	File   string `mapstructure:"file" yaml:"file" json:"file"`
+}
+
+// SecretConfig creates a Secret in the test namespace from local values, files and host environment
+// variables, mounted into the test container at MountPath or injected as environment variables
+type SecretConfig struct {
+	Name      string   `mapstructure:"name" yaml:"name" json:"name"`
+	Literals  []string `mapstructure:"fromLiteral" yaml:"fromLiteral" json:"fromLiteral"`
+	Files     []string `mapstructure:"fromFile" yaml:"fromFile" json:"fromFile"`
+	Env       []string `mapstructure:"fromEnv" yaml:"fromEnv" json:"fromEnv"`
+	MountPath string   `mapstructure:"mountPath" yaml:"mountPath" json:"mountPath"`
+	Inject    bool     `mapstructure:"inject" yaml:"inject" json:"inject"`
+}
+
+// ResourceListConfig sets resource quantities, e.g. "500m" CPU or "1Gi" memory
+type ResourceListConfig struct {
+	CPU              string `mapstructure:"cpu" yaml:"cpu" json:"cpu"`
+	Memory           string `mapstructure:"memory" yaml:"memory" json:"memory"`
+	EphemeralStorage string `mapstructure:"ephemeralStorage" yaml:"ephemeralStorage" json:"ephemeralStorage"`
+}
+
+// ResourcesConfig sets the resource requests and limits of the test container
+type ResourcesConfig struct {
+	Requests ResourceListConfig `mapstructure:"requests" yaml:"requests" json:"requests"`
+	Limits   ResourceListConfig `mapstructure:"limits" yaml:"limits" json:"limits"`
+}
+
+// PodConfig customizes the scheduling, resources and security of the test pod. Affinity, tolerations
+// and security contexts use the Kubernetes API field names.
+type PodConfig struct {
+	Resources         ResourcesConfig     `mapstructure:"resources" yaml:"resources" json:"resources"`
+	NodeSelector      []string            `mapstructure:"nodeSelector" yaml:"nodeSelector" json:"nodeSelector"`
+	Affinity          *corev1.Affinity    `mapstructure:"affinity" yaml:"affinity" json:"affinity"`
+	Tolerations       []corev1.Toleration `mapstructure:"tolerations" yaml:"tolerations" json:"tolerations"`
+	PriorityClassName string              `mapstructure:"priorityClassName" yaml:"priorityClassName" json:"priorityClassName"`
+	ImagePullSecrets  []string            `mapstructure:"imagePullSecrets" yaml:"imagePullSecrets" json:"imagePullSecrets"`
+	// SecurityContext applies to the pod, ContainerSecurityContext to every container ket runs in it
+	SecurityContext          *corev1.PodSecurityContext `mapstructure:"securityContext" yaml:"securityContext" json:"securityContext"`
+	ContainerSecurityContext *corev1.SecurityContext    `mapstructure:"containerSecurityContext" yaml:"containerSecurityContext" json:"containerSecurityContext"`
+}
+
+// RBACAuditConfig routes the test runner's API requests through an auditing proxy, so ket can report
+// the RBAC rules the run needed
+type RBACAuditConfig struct {
+	Enabled bool   `mapstructure:"enabled" yaml:"enabled" json:"enabled"`
+	Image   string `mapstructure:"image" yaml:"image" json:"image"`
+	Output  string `mapstructure:"output" yaml:"output" json:"output"`
+}
+
+// ClusterConfig selects the cluster and identity used to talk to the Kubernetes API
+type ClusterConfig struct {
+	Kubeconfig string   `mapstructure:"kubeconfig" yaml:"kubeconfig" json:"kubeconfig"`
+	Context    string   `mapstructure:"context" yaml:"context" json:"context"`
+	As         string   `mapstructure:"as" yaml:"as" json:"as"`
+	AsGroups   []string `mapstructure:"asGroups" yaml:"asGroups" json:"asGroups"`
+	QPS        float32  `mapstructure:"qps" yaml:"qps" json:"qps"`
+	Burst      int      `mapstructure:"burst" yaml:"burst" json:"burst"`
+}
+
+// GCConfig selects the ket resources `ket gc` reaps
+type GCConfig struct {
+	DryRun    bool          `mapstructure:"dryRun" yaml:"dryRun" json:"dryRun"`
+	OlderThan time.Duration `mapstructure:"olderThan" yaml:"olderThan" json:"olderThan"`
+	Selector  string        `mapstructure:"selector" yaml:"selector" json:"selector"`
+}
+
 type Config struct {
 	Mode            string          `mapstructure:"mode" yaml:"mode" json:"mode"`
 	NamespacePrefix string          `mapstructure:"namespacePrefix" yaml:"namespacePrefix" json:"namespacePrefix"`
@@ -20,13 +178,40 @@ type Config struct {
 	Image           string          `mapstructure:"image" yaml:"image" json:"image"`
 	Debug           bool            `mapstructure:"debug" yaml:"debug" json:"debug"`
 	TestCommand     string          `mapstructure:"testCommand" yaml:"testCommand" json:"testCommand"`
-	KeepNamespace  bool            `mapstructure:"keepNamespace" yaml:"keepNamespace" json:"keepNamespace"`
+	KeepNamespace   bool            `mapstructure:"keepNamespace" yaml:"keepNamespace" json:"keepNamespace"`
 	BackoffLimit    int32           `mapstructure:"backoffLimit" yaml:"backoffLimit" json:"backoffLimit"`
 	ActiveDeadlineS int64           `mapstructure:"activeDeadlineS" yaml:"activeDeadlineS" json:"activeDeadlineS"`
+	Shards          int32           `mapstructure:"shards" yaml:"shards" json:"shards"`
+	Matrix          MatrixConfig    `mapstructure:"matrix" yaml:"matrix" json:"matrix"`
+	Env             []string        `mapstructure:"env" yaml:"env" json:"env"`
+	EnvFrom         []string        `mapstructure:"envFrom" yaml:"envFrom" json:"envFrom"`
+	ForwardEnv      []string        `mapstructure:"forwardEnv" yaml:"forwardEnv" json:"forwardEnv"`
+	Secrets         []SecretConfig  `mapstructure:"secrets" yaml:"secrets" json:"secrets"`
+	Services        []ServiceConfig `mapstructure:"services" yaml:"services" json:"services"`
+	Pod             PodConfig       `mapstructure:"pod" yaml:"pod" json:"pod"`
+	Patches         []PatchConfig   `mapstructure:"patches" yaml:"patches" json:"patches"`
 	WorkspacePath   string          `mapstructure:"clusterWorkspacePath" yaml:"clusterWorkspacePath" json:"clusterWorkspacePath"`
-	RbacFile        string          `mapstructure:"rbac" yaml:"rbac" json:"rbac"`
+	RbacFiles       []string        `mapstructure:"rbac" yaml:"rbac" json:"rbac"`
+	RbacProfile     string          `mapstructure:"rbacProfile" yaml:"rbacProfile" json:"rbacProfile"`
+	RbacReplace     bool            `mapstructure:"rbacReplaceDefaults" yaml:"rbacReplaceDefaults" json:"rbacReplaceDefaults"`
+	RbacAudit       RBACAuditConfig `mapstructure:"rbacAudit" yaml:"rbacAudit" json:"rbacAudit"`
+	SkipPreflight   bool            `mapstructure:"skipPreflight" yaml:"skipPreflight" json:"skipPreflight"`
+	ReportsDir      string          `mapstructure:"reportsDir" yaml:"reportsDir" json:"reportsDir"`
+	Source          SourceConfig    `mapstructure:"source" yaml:"source" json:"source"`
+	Results         ResultsConfig   `mapstructure:"results" yaml:"results" json:"results"`
+	SummaryFile     string          `mapstructure:"summaryFile" yaml:"summaryFile" json:"summaryFile"`
+	DiagnosticsDir  string          `mapstructure:"diagnosticsDir" yaml:"diagnosticsDir" json:"diagnosticsDir"`
+	Fixtures        []string        `mapstructure:"fixtures" yaml:"fixtures" json:"fixtures"`
+	FixturesTimeout int64           `mapstructure:"fixturesTimeoutS" yaml:"fixturesTimeoutS" json:"fixturesTimeoutS"`
+	StartupTimeout  int64           `mapstructure:"startupTimeoutS" yaml:"startupTimeoutS" json:"startupTimeoutS"`
+	TTL             time.Duration   `mapstructure:"ttl" yaml:"ttl" json:"ttl"`
+	CleanupTimeout  int64           `mapstructure:"cleanupTimeoutS" yaml:"cleanupTimeoutS" json:"cleanupTimeoutS"`
+	GC              GCConfig        `mapstructure:"gc" yaml:"gc" json:"gc"`
+	Cluster         ClusterConfig   `mapstructure:"cluster" yaml:"cluster" json:"cluster"`
 	Logging         LoggingConfig   `mapstructure:"logging" yaml:"logging" json:"logging"`
 	Ctx             context.Context `mapstructure:"-" yaml:"-" json:"-"`
+	// Cell is the matrix cell this config runs, set by WithCell
+	Cell *MatrixCell `mapstructure:"-" yaml:"-" json:"-"`
 }
 
 func LoadFromFile(path string) (*Config, error) {
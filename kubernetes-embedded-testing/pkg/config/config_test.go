@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -103,6 +105,92 @@ clusterWorkspacePath: /custom/workspace`
 	}
 }
 
+func TestLoadFromFile_RBACFiles(t *testing.T) {
+	for content, expected := range map[string][]string{
+		"rbac: rbac/rules.yaml":                          {"rbac/rules.yaml"},
+		"rbac: [rbac/rules.yaml, \"rbac/extra/*.yaml\"]": {"rbac/rules.yaml", "rbac/extra/*.yaml"},
+	} {
+		path := filepath.Join(t.TempDir(), "ket-config.yaml")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write config content: %v", err)
+		}
+
+		cfg, err := LoadFromFile(path)
+		if err != nil {
+			t.Fatalf("Failed to load config file: %v", err)
+		}
+		if !reflect.DeepEqual(cfg.RbacFiles, expected) {
+			t.Errorf("Expected RbacFiles to be %v for %q, got %v", expected, content, cfg.RbacFiles)
+		}
+	}
+}
+
+func TestLoadFromFile_Pod(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "ket-config-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	configContent := `pod:
+  resources:
+    requests:
+      cpu: 500m
+    limits:
+      memory: 1Gi
+      ephemeralStorage: 2Gi
+  nodeSelector:
+    - kubernetes.io/arch=amd64
+  tolerations:
+    - key: dedicated
+      operator: Equal
+      value: ci
+      effect: NoSchedule
+  priorityClassName: ci-low
+  imagePullSecrets: [registry-creds]
+  securityContext:
+    runAsNonRoot: true
+    seccompProfile:
+      type: RuntimeDefault
+  containerSecurityContext:
+    allowPrivilegeEscalation: false
+    capabilities:
+      drop: [ALL]`
+
+	if _, err := tempFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tempFile.Close()
+
+	cfg, err := LoadFromFile(tempFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config file: %v", err)
+	}
+
+	pod := cfg.Pod
+	if pod.Resources.Requests.CPU != "500m" || pod.Resources.Limits.EphemeralStorage != "2Gi" {
+		t.Errorf("Unexpected resources: %+v", pod.Resources)
+	}
+	if len(pod.NodeSelector) != 1 || pod.NodeSelector[0] != "kubernetes.io/arch=amd64" {
+		t.Errorf("Expected the node selector to keep its key, got %v", pod.NodeSelector)
+	}
+	if len(pod.Tolerations) != 1 || pod.Tolerations[0].Effect != "NoSchedule" {
+		t.Errorf("Unexpected tolerations: %+v", pod.Tolerations)
+	}
+	if pod.PriorityClassName != "ci-low" || len(pod.ImagePullSecrets) != 1 {
+		t.Errorf("Unexpected priority class or pull secrets: %+v", pod)
+	}
+	if pod.SecurityContext == nil || pod.SecurityContext.RunAsNonRoot == nil || !*pod.SecurityContext.RunAsNonRoot {
+		t.Error("Expected runAsNonRoot to be set")
+	}
+	if pod.SecurityContext.SeccompProfile == nil || pod.SecurityContext.SeccompProfile.Type != "RuntimeDefault" {
+		t.Error("Expected the seccomp profile to be set")
+	}
+	if pod.ContainerSecurityContext == nil || len(pod.ContainerSecurityContext.Capabilities.Drop) != 1 {
+		t.Error("Expected the container security context to drop capabilities")
+	}
+}
+
 func TestLoadFromFileNotFound(t *testing.T) {
 	_, err := LoadFromFile("nonexistent-file.yaml")
 	if err == nil {
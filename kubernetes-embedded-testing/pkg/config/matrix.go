@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MatrixAxis is a set of values an environment variable takes across the matrix
+type MatrixAxis struct {
+	Name   string   `mapstructure:"name" yaml:"name" json:"name"`
+	Values []string `mapstructure:"values" yaml:"values" json:"values"`
+}
+
+// MatrixConfig runs the suite once per combination of runner image and environment values
+type MatrixConfig struct {
+	Images             []string     `mapstructure:"images" yaml:"images" json:"images"`
+	Env                []MatrixAxis `mapstructure:"env" yaml:"env" json:"env"`
+	SeparateNamespaces bool         `mapstructure:"separateNamespaces" yaml:"separateNamespaces" json:"separateNamespaces"`
+}
+
+// MatrixEnvVar is the value an environment variable takes in a matrix cell
+type MatrixEnvVar struct {
+	Name  string
+	Value string
+}
+
+// MatrixCell is one combination of the matrix
+type MatrixCell struct {
+	Index int
+	// Image overrides the runner image, unless empty
+	Image string
+	Env   []MatrixEnvVar
+}
+
+// Name describes the cell by its image and environment values
+func (c MatrixCell) Name() string {
+	var parts []string
+	if c.Image != "" {
+		parts = append(parts, c.Image)
+	}
+	for _, env := range c.Env {
+		parts = append(parts, fmt.Sprintf("%s=%s", env.Name, env.Value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Cells returns the cartesian product of the matrix images and environment axes, or nil when the
+// matrix is empty. Axes without values are ignored.
+func (m MatrixConfig) Cells() []MatrixCell {
+	var axes []MatrixAxis
+	for _, axis := range m.Env {
+		if len(axis.Values) > 0 {
+			axes = append(axes, axis)
+		}
+	}
+	if len(m.Images) == 0 && len(axes) == 0 {
+		return nil
+	}
+
+	images := m.Images
+	if len(images) == 0 {
+		images = []string{""}
+	}
+
+	var cells []MatrixCell
+	for _, image := range images {
+		combinations := [][]MatrixEnvVar{nil}
+		for _, axis := range axes {
+			var expanded [][]MatrixEnvVar
+			for _, combination := range combinations {
+				for _, value := range axis.Values {
+					env := append(append([]MatrixEnvVar{}, combination...), MatrixEnvVar{Name: axis.Name, Value: value})
+					expanded = append(expanded, env)
+				}
+			}
+			combinations = expanded
+		}
+
+		for _, env := range combinations {
+			cells = append(cells, MatrixCell{Index: len(cells), Image: image, Env: env})
+		}
+	}
+	return cells
+}
+
+// Validate checks that the matrix environment axes are named
+func (m MatrixConfig) Validate() error {
+	for i, axis := range m.Env {
+		if axis.Name == "" {
+			return fmt.Errorf("matrix env axis %d has no name", i)
+		}
+	}
+	return nil
+}
+
+// WithCell returns the config for running a single matrix cell
+func (c Config) WithCell(cell MatrixCell) Config {
+	if cell.Image != "" {
+		c.Image = cell.Image
+	}
+	c.Cell = &cell
+	return c
+}
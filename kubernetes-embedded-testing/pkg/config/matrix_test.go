@@ -0,0 +1,95 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestMatrixCells(t *testing.T) {
+	matrix := MatrixConfig{
+		Images: []string{"node:18", "node:20"},
+		Env: []MatrixAxis{
+			{Name: "DB", Values: []string{"mongo", "postgres"}},
+			{Name: "EMPTY"},
+		},
+	}
+
+	var names []string
+	for i, cell := range matrix.Cells() {
+		if cell.Index != i {
+			t.Errorf("Expected cell %d to have index %d, got %d", i, i, cell.Index)
+		}
+		names = append(names, cell.Name())
+	}
+
+	expected := []string{"node:18 DB=mongo", "node:18 DB=postgres", "node:20 DB=mongo", "node:20 DB=postgres"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected cells %v, got %v", expected, names)
+	}
+}
+
+func TestMatrixCells_EnvOnlyAndEmpty(t *testing.T) {
+	cells := MatrixConfig{Env: []MatrixAxis{{Name: "MODE", Values: []string{"a", "b"}}}}.Cells()
+	if len(cells) != 2 || cells[0].Image != "" || cells[1].Name() != "MODE=b" {
+		t.Errorf("Unexpected env-only cells: %+v", cells)
+	}
+
+	if cells := (MatrixConfig{}).Cells(); cells != nil {
+		t.Errorf("Expected no cells for an empty matrix, got %+v", cells)
+	}
+}
+
+func TestConfigWithCell(t *testing.T) {
+	cfg := Config{Image: "node:18-alpine"}
+
+	cellCfg := cfg.WithCell(MatrixCell{Index: 1, Image: "node:22"})
+	if cellCfg.Image != "node:22" || cellCfg.Cell == nil || cellCfg.Cell.Index != 1 {
+		t.Errorf("Expected the cell image and cell to be set, got %+v", cellCfg)
+	}
+	if cfg.Cell != nil {
+		t.Error("Expected the original config to be unchanged")
+	}
+
+	if envCfg := cfg.WithCell(MatrixCell{}); envCfg.Image != "node:18-alpine" {
+		t.Errorf("Expected a cell without image to keep the configured image, got %s", envCfg.Image)
+	}
+}
+
+func TestLoadFromFile_Matrix(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "ket-config-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	configContent := `matrix:
+  images: [node:18, node:20]
+  env:
+    - name: DB_DRIVER
+      values: [mongo, postgres]
+  separateNamespaces: true`
+
+	if _, err := tempFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tempFile.Close()
+
+	cfg, err := LoadFromFile(tempFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config file: %v", err)
+	}
+
+	if !cfg.Matrix.SeparateNamespaces {
+		t.Error("Expected SeparateNamespaces to be true")
+	}
+	if len(cfg.Matrix.Cells()) != 4 {
+		t.Errorf("Expected 4 cells, got %d", len(cfg.Matrix.Cells()))
+	}
+	if cfg.Matrix.Env[0].Name != "DB_DRIVER" {
+		t.Errorf("Expected the env axis name to keep its case, got %s", cfg.Matrix.Env[0].Name)
+	}
+	if err := (MatrixConfig{Env: []MatrixAxis{{Values: []string{"a"}}}}).Validate(); err == nil {
+		t.Error("Expected an unnamed axis to be invalid")
+	}
+}
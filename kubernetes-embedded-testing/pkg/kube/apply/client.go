@@ -1,23 +1,45 @@
 package apply
 
 import (
-	"k8s.io/client-go/kubernetes"
+	"testrunner/pkg/config"
+
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-// NewClient creates a new Kubernetes client, trying in-cluster config first, then falling back to kubeconfig
-func NewClient() (*kubernetes.Clientset, error) {
-	cfg, err := rest.InClusterConfig()
-	if err != nil {
+// NewRestConfig loads the cluster connection config, trying in-cluster config first, then falling back to kubeconfig.
+// An explicit kubeconfig path or context always selects kubeconfig loading.
+func NewRestConfig(cluster config.ClusterConfig) (*rest.Config, error) {
+	var cfg *rest.Config
+	if cluster.Kubeconfig == "" && cluster.Context == "" {
+		cfg, _ = rest.InClusterConfig()
+	}
+	if cfg == nil {
+		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+		loadingRules.ExplicitPath = cluster.Kubeconfig
+
 		kubeconfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-			clientcmd.NewDefaultClientConfigLoadingRules(),
-			&clientcmd.ConfigOverrides{},
+			loadingRules,
+			&clientcmd.ConfigOverrides{CurrentContext: cluster.Context},
 		)
+		var err error
 		cfg, err = kubeconfig.ClientConfig()
 		if err != nil {
 			return nil, err
 		}
 	}
-	return kubernetes.NewForConfig(cfg)
+
+	if cluster.As != "" || len(cluster.AsGroups) > 0 {
+		cfg.Impersonate = rest.ImpersonationConfig{
+			UserName: cluster.As,
+			Groups:   cluster.AsGroups,
+		}
+	}
+	if cluster.QPS > 0 {
+		cfg.QPS = cluster.QPS
+	}
+	if cluster.Burst > 0 {
+		cfg.Burst = cluster.Burst
+	}
+	return cfg, nil
 }
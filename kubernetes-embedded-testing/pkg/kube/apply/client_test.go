@@ -0,0 +1,80 @@
+package apply
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"testrunner/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+- name: ci
+  cluster:
+    server: https://ci.example.com
+users:
+- name: admin
+  user:
+    token: secret
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: admin
+- name: ci
+  context:
+    cluster: ci
+    user: admin
+`
+
+func writeKubeconfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, os.WriteFile(path, []byte(testKubeconfig), 0o600))
+	return path
+}
+
+func TestNewRestConfig_UsesCurrentContextByDefault(t *testing.T) {
+	cfg, err := NewRestConfig(config.ClusterConfig{Kubeconfig: writeKubeconfig(t)})
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://dev.example.com", cfg.Host)
+	assert.Empty(t, cfg.Impersonate.UserName)
+}
+
+func TestNewRestConfig_SelectsContext(t *testing.T) {
+	cfg, err := NewRestConfig(config.ClusterConfig{Kubeconfig: writeKubeconfig(t), Context: "ci"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://ci.example.com", cfg.Host)
+}
+
+func TestNewRestConfig_UnknownContext(t *testing.T) {
+	_, err := NewRestConfig(config.ClusterConfig{Kubeconfig: writeKubeconfig(t), Context: "missing"})
+	assert.Error(t, err)
+}
+
+func TestNewRestConfig_ImpersonationAndRateLimits(t *testing.T) {
+	cfg, err := NewRestConfig(config.ClusterConfig{
+		Kubeconfig: writeKubeconfig(t),
+		As:         "ci-bot",
+		AsGroups:   []string{"testers", "ci"},
+		QPS:        50,
+		Burst:      100,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "ci-bot", cfg.Impersonate.UserName)
+	assert.Equal(t, []string{"testers", "ci"}, cfg.Impersonate.Groups)
+	assert.Equal(t, float32(50), cfg.QPS)
+	assert.Equal(t, 100, cfg.Burst)
+}
@@ -3,9 +3,13 @@ package apply
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	"testrunner/pkg/kube/generate"
 	"testrunner/pkg/logger"
+	"testrunner/pkg/results"
 
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -18,138 +22,334 @@ type TestResult struct {
 	ExitCode int
 	Success  bool
 	Error    error
+	// Category classifies a failure, and is empty on success
+	Category results.FailureCategory
+	// Reason is what Kubernetes reported for a failure, such as ImagePullBackOff or DeadlineExceeded
+	Reason string
+	// Pod is the test pod that produced the result, and Attempt its 1-based number among the job's pods
+	Pod     string
+	Attempt int
 }
 
 // WaitForTestCompletion waits for the injected test runner job to complete and returns the test results
-func WaitForTestCompletion(ctx context.Context, client *kubernetes.Clientset, job *batchv1.Job) (*TestResult, error) {
+func WaitForTestCompletion(ctx context.Context, client kubernetes.Interface, job *batchv1.Job) (*TestResult, error) {
 	logger.KubeLogger.Info("Waiting for Test Runner Job %s to complete", job.Name)
 
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
+	finished, err := watchJob(ctx, client, job, func(current *batchv1.Job) (bool, error) {
+		if jobFinished(current) {
+			return true, nil
+		}
+		logger.KubeLogger.Debug("Test Runner Job %s is still running...", job.Name)
+		return false, nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
 			logger.KubeLogger.Debug("Context cancelled, stopping wait for test completion")
-			return nil, ctx.Err()
-		case <-ticker.C:
-			currentJob, err := client.BatchV1().Jobs(job.Namespace).Get(ctx, job.Name, metav1.GetOptions{})
-			if err != nil {
-				logger.KubeLogger.Warn("Failed to get job status: %v", err)
-				continue
-			}
-
-			if currentJob.Status.Succeeded > 0 {
-				logger.KubeLogger.Info("Test Runner Job %s completed successfully", job.Name)
-				exitCode, err := getPodExitCode(ctx, client, job.Name, job.Namespace)
-				if err != nil {
-					logger.KubeLogger.Warn("Could not determine pod exit code: %v", err)
-					exitCode = 0
-				}
-				return &TestResult{ExitCode: exitCode, Success: true}, nil
-			}
+		}
+		return nil, err
+	}
 
-			if currentJob.Status.Failed > 0 {
-				logger.KubeLogger.Error("Test Runner Job %s failed", job.Name)
-				exitCode, err := getPodExitCode(ctx, client, job.Name, job.Namespace)
-				if err != nil {
-					logger.KubeLogger.Warn("Could not determine pod exit code: %v", err)
-					exitCode = 1
-				}
-				return &TestResult{ExitCode: exitCode, Success: false, Error: fmt.Errorf("Test Runner Job %s failed", job.Name)}, nil
-			}
+	attempts, err := jobPods(ctx, client, finished)
+	if err != nil {
+		logger.KubeLogger.Warn("Could not list the pods of job %s: %v", job.Name, err)
+	}
 
-			logger.KubeLogger.Debug("Test Runner Job %s is still running...", job.Name)
+	if finished.Status.Succeeded > 0 {
+		logger.KubeLogger.Info("Test Runner Job %s completed successfully", job.Name)
+		result := &TestResult{Success: true, Attempt: len(attempts)}
+		if len(attempts) > 0 {
+			result.Pod = attempts[len(attempts)-1].Name
 		}
+		return result, nil
 	}
+
+	// A retry the job controller started just before the job failed, as for a pod failure policy,
+	// never ran the tests, so the failure is classified from the last attempt that did
+	result := classifyFailure(finished, attempts[:lastTestedAttempt(attempts)], nil)
+	logger.KubeLogger.Error("%v", result.Error)
+	return result, nil
 }
 
-// getPodExitCode attempts to get the exit code from the pod's container
-func getPodExitCode(ctx context.Context, client *kubernetes.Clientset, jobName, namespace string) (int, error) {
-	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: "job-name=" + jobName,
-	})
+// ClassifyFailure returns the failed result of a job whose test pod did not run to completion, such
+// as one that could not pull its image or be scheduled. cause is the error that ended the wait for the
+// pod, and is reported when its state explains nothing more specific.
+func ClassifyFailure(ctx context.Context, client kubernetes.Interface, job *batchv1.Job, cause error) (*TestResult, error) {
+	current, err := client.BatchV1().Jobs(job.Namespace).Get(ctx, job.Name, metav1.GetOptions{})
 	if err != nil {
-		return 0, fmt.Errorf("failed to list pods for job: %w", err)
+		return nil, fmt.Errorf("failed to get job %s: %w", job.Name, err)
 	}
+	attempts, err := jobPods(ctx, client, current)
+	if err != nil {
+		return nil, err
+	}
+	return classifyFailure(current, attempts, cause), nil
+}
 
-	if len(pods.Items) == 0 {
-		return 0, fmt.Errorf("no pods found for job %s", jobName)
+// classifyFailure classifies the failure of a job from its last attempt. The more specific causes
+// come first: a pod stuck pulling its image also makes the job exceed its deadline, and the test
+// container of a job that exceeded its deadline is killed with a non-zero exit code.
+func classifyFailure(job *batchv1.Job, attempts []corev1.Pod, cause error) *TestResult {
+	result := &TestResult{Attempt: len(attempts)}
+	var pod *corev1.Pod
+	if len(attempts) > 0 {
+		pod = &attempts[len(attempts)-1]
+		result.Pod = pod.Name
 	}
 
-	pod := pods.Items[0]
-	if len(pod.Status.ContainerStatuses) == 0 {
-		return 0, fmt.Errorf("no container statuses found for pod %s", pod.Name)
+	testExitCode := 0
+	var testStatus *corev1.ContainerStatus
+	if pod != nil {
+		testStatus = testRunnerStatus(*pod)
+	}
+	if testStatus != nil && testStatus.State.Terminated != nil {
+		testExitCode = int(testStatus.State.Terminated.ExitCode)
 	}
 
-	containerStatus := pod.Status.ContainerStatuses[0]
-	if containerStatus.State.Terminated != nil {
-		return int(containerStatus.State.Terminated.ExitCode), nil
+	switch {
+	case pod != nil && waitingReason(*pod, imagePullReasons) != "":
+		result.Category, result.Reason = results.FailureImagePull, waitingReason(*pod, imagePullReasons)
+	case pod != nil && unschedulableReason(*pod) != "":
+		result.Category, result.Reason = results.FailureScheduling, unschedulableReason(*pod)
+	case pod != nil && pod.Status.Reason == "Evicted":
+		result.Category, result.Reason = results.FailureInfraError, fmt.Sprintf("Evicted: %s", pod.Status.Message)
+	case testStatus != nil && testStatus.State.Terminated != nil && testStatus.State.Terminated.Reason == "OOMKilled":
+		result.Category, result.Reason = results.FailureOOM, "OOMKilled"
+	case jobFailureReason(job) == batchv1.JobReasonDeadlineExceeded || (pod != nil && pod.Status.Reason == "DeadlineExceeded"):
+		result.Category, result.Reason = results.FailureTimeout, string(batchv1.JobReasonDeadlineExceeded)
+	case testExitCode != 0:
+		result.Category, result.Reason = results.FailureTestFailed, fmt.Sprintf("exit code %d", testExitCode)
+	case pod != nil && waitingReason(*pod, nil) != "":
+		result.Category, result.Reason = results.FailureInfraError, waitingReason(*pod, nil)
+	case cause != nil:
+		result.Category, result.Reason = results.FailureInfraError, cause.Error()
+	default:
+		result.Category, result.Reason = results.FailureInfraError, jobFailureReason(job)
+		if result.Reason == "" {
+			result.Reason = "job failed without a test exit code"
+		}
+	}
+
+	result.ExitCode = result.Category.ExitCode(testExitCode)
+	if result.Attempt > 0 {
+		result.Error = fmt.Errorf("Test Runner Job %s failed on attempt %d (%s): %s", job.Name, result.Attempt, result.Category, result.Reason)
+	} else {
+		result.Error = fmt.Errorf("Test Runner Job %s failed (%s): %s", job.Name, result.Category, result.Reason)
 	}
+	return result
+}
 
-	return 0, fmt.Errorf("container not terminated yet")
+// lastTestedAttempt returns the number of the last attempt whose test container terminated, or the
+// number of attempts if none did
+func lastTestedAttempt(attempts []corev1.Pod) int {
+	for i := len(attempts) - 1; i >= 0; i-- {
+		if status := testRunnerStatus(attempts[i]); status != nil && status.State.Terminated != nil {
+			return i + 1
+		}
+	}
+	return len(attempts)
 }
 
-// StreamTestOutputToHost streams the test output from the injected test runner pod back to the host machine
-func StreamTestOutputToHost(ctx context.Context, client *kubernetes.Clientset, job *batchv1.Job) error {
-	logger.KubeLogger.Info("Waiting for test runner pod to be ready...")
+// imagePullReasons are the waiting reasons of containers whose image cannot be pulled
+var imagePullReasons = map[string]bool{
+	"ErrImagePull":      true,
+	"ImagePullBackOff":  true,
+	"InvalidImageName":  true,
+	"ErrImageNeverPull": true,
+}
 
-	timeout := time.After(120 * time.Second)  // Increased timeout for image pulling
-	ticker := time.NewTicker(2 * time.Second) // Reduced frequency to avoid spam
-	defer ticker.Stop()
+// waitingReason returns the reason, with its message, of the first container of the pod waiting for
+// one of the given reasons, or any reason other than ContainerCreating and PodInitializing if nil
+func waitingReason(pod corev1.Pod, reasons map[string]bool) string {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		waiting := status.State.Waiting
+		if waiting == nil || waiting.Reason == "" {
+			continue
+		}
+		if reasons != nil && !reasons[waiting.Reason] {
+			continue
+		}
+		if reasons == nil && (waiting.Reason == "ContainerCreating" || waiting.Reason == "PodInitializing") {
+			continue
+		}
+		if waiting.Message == "" {
+			return fmt.Sprintf("%s: %s", status.Name, waiting.Reason)
+		}
+		return fmt.Sprintf("%s: %s: %s", status.Name, waiting.Reason, waiting.Message)
+	}
+	return ""
+}
 
-	lastStatus := ""
-	attempts := 0
+// unschedulableReason returns why the pod could not be scheduled, or "" if it was or may still be
+func unschedulableReason(pod corev1.Pod) string {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse && condition.Reason == corev1.PodReasonUnschedulable {
+			return fmt.Sprintf("%s: %s", condition.Reason, condition.Message)
+		}
+	}
+	return ""
+}
+
+// jobFinished reports whether the job will not start another pod: it has a Complete or Failed
+// condition, one of its pods succeeded, or more of them failed than its backoff limit allows
+func jobFinished(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	var backoffLimit int32
+	if job.Spec.BackoffLimit != nil {
+		backoffLimit = *job.Spec.BackoffLimit
+	}
+	return job.Status.Succeeded > 0 || job.Status.Failed > backoffLimit
+}
 
+// jobFailureReason returns the reason of the job's Failed condition, or "" if it has none
+func jobFailureReason(job *batchv1.Job) string {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return condition.Reason
+		}
+	}
+	return ""
+}
+
+// jobPods lists the pods of the job, one per attempt, oldest first
+func jobPods(ctx context.Context, client kubernetes.Interface, job *batchv1.Job) ([]corev1.Pod, error) {
+	pods, err := client.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: jobPodSelector(job),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods for job: %w", err)
+	}
+
+	attempts := pods.Items
+	sort.SliceStable(attempts, func(i, j int) bool {
+		if !attempts[i].CreationTimestamp.Equal(&attempts[j].CreationTimestamp) {
+			return attempts[i].CreationTimestamp.Before(&attempts[j].CreationTimestamp)
+		}
+		return attempts[i].Name < attempts[j].Name
+	})
+	return attempts, nil
+}
+
+// AttemptHooks are run for every attempt of a job whose test output is streamed
+type AttemptHooks struct {
+	// Before runs before waiting for the test container of an attempt, such as to upload the source.
+	// followed holds the names of the pods of earlier attempts, which are to be ignored.
+	Before func(followed map[string]bool) error
+	// After runs with the pod of an attempt once its test container has exited, before the job can
+	// start the next one
+	After func(*corev1.Pod)
+}
+
+// StreamTestOutputToHost streams the test output of every attempt of the job back to the host machine,
+// until the job finishes. Waits for a pod fail fast if it cannot start, and once it has made no
+// progress for startupTimeout.
+func StreamTestOutputToHost(ctx context.Context, client kubernetes.Interface, job *batchv1.Job, startupTimeout time.Duration, hooks AttemptHooks) error {
+	followed := map[string]bool{}
 	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-timeout:
-			return fmt.Errorf("timeout waiting for Test Runner Job %s to become active", job.Name)
-		case <-ticker.C:
-			attempts++
-			pods, err := client.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{
-				LabelSelector: "job-name=" + job.Name,
-			})
-			if err != nil {
-				logger.KubeLogger.Warn("Failed to list pods for Test Runner Job: %v", err)
-				continue
+		if hooks.Before != nil {
+			if err := hooks.Before(followed); err != nil {
+				return err
+			}
+		}
+
+		logger.KubeLogger.Info("Waiting for test runner pod to be ready...")
+		lastStatus := ""
+		pod, err := waitForPodStart(ctx, client, job, startupTimeout, func(pod *corev1.Pod) (bool, error) {
+			if followed[pod.Name] {
+				return false, nil
 			}
+			currentStatus := getPodStatus(*pod)
 
-			if len(pods.Items) > 0 {
-				pod := pods.Items[0]
-				currentStatus := getPodStatus(pod)
-
-				// Only log status changes to avoid spam
-				if currentStatus != lastStatus {
-					logger.KubeLogger.Info("Pod %s status: %s", pod.Name, currentStatus)
-					lastStatus = currentStatus
-				}
-
-				// Check if pod is ready to stream logs
-				if isPodReadyForLogs(pod) {
-					logger.KubeLogger.Info("Pod %s is ready, starting log stream", pod.Name)
-					if err := streamPodLogs(ctx, client, pod, job.Namespace); err != nil {
-						logger.KubeLogger.Warn("Failed to stream logs from pod %s: %v", pod.Name, err)
-						continue
-					}
-					return nil
-				}
-			} else if attempts%5 == 0 { // Log every 10 seconds (5 * 2 second ticker)
-				logger.KubeLogger.Info("Waiting for pod to be created...")
+			// Only log status changes to avoid spam
+			if currentStatus != lastStatus {
+				logger.KubeLogger.Info("Pod %s status: %s", pod.Name, currentStatus)
+				lastStatus = currentStatus
 			}
+
+			return isPodReadyForLogs(*pod), nil
+		})
+		if err != nil {
+			return err
+		}
+		followed[pod.Name] = true
+
+		prefix := testOutputPrefix(*pod)
+		emit := func(line string) {
+			logger.TestRunnerLogger.WriteLine(prefix, line)
+		}
+		attempt := attemptNumber(ctx, client, job, pod, len(followed))
+		if header := attemptHeader(job, pod, attempt); header != "" {
+			emit(header)
+		}
+
+		logger.KubeLogger.Info("Pod %s is ready, starting log stream", pod.Name)
+		if err := followTestOutput(ctx, client, pod, emit); err != nil {
+			return err
 		}
+		if hooks.After != nil {
+			hooks.After(pod)
+		}
+
+		retrying, err := waitForRetry(ctx, client, job, attempt)
+		if err != nil || !retrying {
+			return err
+		}
+		logger.KubeLogger.Info("Test Runner Job %s is retrying after attempt %d", job.Name, attempt)
 	}
 }
 
+// attemptHeader returns the line marking the start of an attempt's output, or "" for a job that is
+// not retried
+func attemptHeader(job *batchv1.Job, pod *corev1.Pod, attempt int) string {
+	if job.Spec.BackoffLimit == nil || *job.Spec.BackoffLimit == 0 {
+		return ""
+	}
+	return fmt.Sprintf("===== attempt %d of %d: pod %s =====", attempt, *job.Spec.BackoffLimit+1, pod.Name)
+}
+
+// attemptNumber returns the 1-based number of the pod among the job's pods, or fallback if the pods
+// cannot be listed
+func attemptNumber(ctx context.Context, client kubernetes.Interface, job *batchv1.Job, pod *corev1.Pod, fallback int) int {
+	attempts, err := jobPods(ctx, client, job)
+	if err != nil {
+		return fallback
+	}
+	for i := range attempts {
+		if attempts[i].Name == pod.Name {
+			return i + 1
+		}
+	}
+	return fallback
+}
+
+// waitForRetry waits until the job either finishes, returning false, or has started the pod of the
+// attempt after the given one, returning true
+func waitForRetry(ctx context.Context, client kubernetes.Interface, job *batchv1.Job, attempt int) (bool, error) {
+	retrying := false
+	_, err := watchJob(ctx, client, job, func(current *batchv1.Job) (bool, error) {
+		if jobFinished(current) {
+			return true, nil
+		}
+		if current.Status.Active > 0 && int(current.Status.Failed) >= attempt {
+			retrying = true
+			return true, nil
+		}
+		return false, nil
+	})
+	return retrying, err
+}
+
 // getPodStatus returns a human-readable status of the pod
 func getPodStatus(pod corev1.Pod) string {
-	if len(pod.Status.ContainerStatuses) == 0 {
+	containerStatus := testRunnerStatus(pod)
+	if containerStatus == nil {
 		return "ContainerCreating"
 	}
 
-	containerStatus := pod.Status.ContainerStatuses[0]
 	if containerStatus.State.Waiting != nil {
 		if containerStatus.State.Waiting.Reason == "ContainerCreating" {
 			return "ContainerCreating"
@@ -170,13 +370,11 @@ func getPodStatus(pod corev1.Pod) string {
 
 // isPodReadyForLogs checks if the pod is ready to stream logs from
 func isPodReadyForLogs(pod corev1.Pod) bool {
-
-	if len(pod.Status.ContainerStatuses) == 0 {
+	containerStatus := testRunnerStatus(pod)
+	if containerStatus == nil {
 		return false
 	}
 
-	containerStatus := pod.Status.ContainerStatuses[0]
-
 	if containerStatus.State.Terminated != nil {
 		return true
 	}
@@ -184,20 +382,25 @@ func isPodReadyForLogs(pod corev1.Pod) bool {
 	return containerStatus.State.Running != nil && containerStatus.Ready
 }
 
-// streamPodLogs attempts to stream logs from a specific pod
-func streamPodLogs(ctx context.Context, client *kubernetes.Clientset, pod corev1.Pod, namespace string) error {
-	logger.KubeLogger.Info("Streaming test output from pod %s", pod.Name)
-
-	req := client.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
-		Follow: true,
-	})
-
-	stream, err := req.Stream(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to get test output stream: %w", err)
+// testOutputPrefix returns the prefix marking the output of a matrix cell or shard's test pod, or ""
+// when the pod is the run's only test pod
+func testOutputPrefix(pod corev1.Pod) string {
+	var parts []string
+	if cell, ok := pod.Annotations[generate.MatrixCellAnnotation]; ok {
+		parts = append(parts, fmt.Sprintf("[%s]", cell))
+	}
+	if shard, ok := pod.Labels[generate.ShardIndexLabel]; ok {
+		parts = append(parts, fmt.Sprintf("[shard-%s]", shard))
 	}
-	defer stream.Close()
+	return strings.Join(parts, " ")
+}
 
-	logger.TestRunnerLogger.StreamLogs(stream)
+// testRunnerStatus returns the status of the test runner container, or nil if it has not been reported yet
+func testRunnerStatus(pod corev1.Pod) *corev1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == generate.TestRunnerContainerName {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
 	return nil
 }
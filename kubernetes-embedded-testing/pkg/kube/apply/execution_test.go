@@ -0,0 +1,366 @@
+package apply
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"testrunner/pkg/kube/generate"
+	"testrunner/pkg/results"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testJob(status batchv1.JobStatus) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "ket-app", Namespace: "test-namespace"},
+		Status:     status,
+	}
+}
+
+func testPod(name string, state corev1.ContainerState) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "test-namespace",
+			Labels:    map[string]string{"job-name": "ket-app"},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: generate.ReportsCollectorContainerName},
+				{Name: generate.TestRunnerContainerName, State: state},
+			},
+		},
+	}
+}
+
+func terminated(exitCode int32) corev1.ContainerState {
+	return corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode}}
+}
+
+func waiting(reason string) corev1.ContainerState {
+	return corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: "details"}}
+}
+
+func TestWaitForTestCompletion_Succeeded(t *testing.T) {
+	job := testJob(batchv1.JobStatus{Succeeded: 1})
+	client := fake.NewSimpleClientset(job, testPod("ket-app-abc", terminated(0)))
+
+	result, err := WaitForTestCompletion(context.Background(), client, job)
+	require.NoError(t, err)
+
+	assert.True(t, result.Success)
+	assert.Equal(t, 0, result.ExitCode)
+}
+
+func TestWaitForTestCompletion_FailedUsesTestRunnerExitCode(t *testing.T) {
+	job := testJob(batchv1.JobStatus{Failed: 1})
+	client := fake.NewSimpleClientset(job, testPod("ket-app-abc", terminated(3)))
+
+	result, err := WaitForTestCompletion(context.Background(), client, job)
+	require.NoError(t, err)
+
+	assert.False(t, result.Success)
+	assert.Equal(t, 3, result.ExitCode, "exit code should come from the test runner container, not the first status")
+	assert.Error(t, result.Error)
+}
+
+func TestWaitForTestCompletion_ReportsLastAttempt(t *testing.T) {
+	job := testJob(batchv1.JobStatus{Failed: 2})
+	first := testPod("ket-app-aaaaa", terminated(2))
+	first.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Minute))
+	second := testPod("ket-app-bbbbb", terminated(5))
+	second.CreationTimestamp = metav1.NewTime(time.Now())
+	client := fake.NewSimpleClientset(job, second, first)
+
+	result, err := WaitForTestCompletion(context.Background(), client, job)
+	require.NoError(t, err)
+
+	assert.Equal(t, results.FailureTestFailed, result.Category)
+	assert.Equal(t, 5, result.ExitCode)
+	assert.Equal(t, "ket-app-bbbbb", result.Pod)
+	assert.Equal(t, 2, result.Attempt)
+	assert.ErrorContains(t, result.Error, "failed on attempt 2 (test-failed): exit code 5")
+}
+
+func TestWaitForTestCompletion_WaitsForRetry(t *testing.T) {
+	backoffLimit := int32(1)
+	job := testJob(batchv1.JobStatus{Active: 1, Failed: 1})
+	job.Spec.BackoffLimit = &backoffLimit
+	first := testPod("ket-app-aaaaa", terminated(3))
+	first.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Minute))
+	second := testPod("ket-app-bbbbb", corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}})
+	second.CreationTimestamp = metav1.NewTime(time.Now())
+	client := fake.NewSimpleClientset(job, first, second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan *TestResult, 1)
+	go func() {
+		result, err := WaitForTestCompletion(ctx, client, job)
+		assert.NoError(t, err)
+		done <- result
+	}()
+
+	select {
+	case result := <-done:
+		t.Fatalf("the wait ended while the job was retrying: %+v", result)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	second.Status.ContainerStatuses[1].State = terminated(4)
+	_, err := client.CoreV1().Pods(second.Namespace).UpdateStatus(ctx, second, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	job.Status = batchv1.JobStatus{Failed: 2}
+	_, err = client.BatchV1().Jobs(job.Namespace).UpdateStatus(ctx, job, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	result := <-done
+	require.NotNil(t, result)
+	assert.Equal(t, results.FailureTestFailed, result.Category)
+	assert.Equal(t, 4, result.ExitCode)
+	assert.Equal(t, 2, result.Attempt)
+}
+
+func TestWaitForTestCompletion_IgnoresPendingRetry(t *testing.T) {
+	backoffLimit := int32(1)
+	job := testJob(batchv1.JobStatus{Failed: 1, Conditions: []batchv1.JobCondition{
+		{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: batchv1.JobReasonPodFailurePolicy},
+	}})
+	job.Spec.BackoffLimit = &backoffLimit
+	first := testPod("ket-app-aaaaa", terminated(3))
+	first.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Minute))
+	second := testPod("ket-app-bbbbb", corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}})
+	second.CreationTimestamp = metav1.NewTime(time.Now())
+	client := fake.NewSimpleClientset(job, first, second)
+
+	result, err := WaitForTestCompletion(context.Background(), client, job)
+	require.NoError(t, err)
+
+	assert.Equal(t, results.FailureTestFailed, result.Category, "a retry that never ran must not turn failing tests into an infra error")
+	assert.Equal(t, 3, result.ExitCode)
+	assert.Equal(t, "ket-app-aaaaa", result.Pod)
+	assert.Equal(t, 1, result.Attempt)
+}
+
+func TestClassifyFailure(t *testing.T) {
+	deadlineExceeded := batchv1.JobStatus{Failed: 1, Conditions: []batchv1.JobCondition{
+		{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: batchv1.JobReasonDeadlineExceeded},
+	}}
+
+	tests := []struct {
+		name     string
+		status   batchv1.JobStatus
+		pod      func() *corev1.Pod
+		category results.FailureCategory
+		exitCode int
+		reason   string
+	}{
+		{
+			name:     "failing tests",
+			status:   batchv1.JobStatus{Failed: 1},
+			pod:      func() *corev1.Pod { return testPod("p", terminated(4)) },
+			category: results.FailureTestFailed,
+			exitCode: 4,
+		},
+		{
+			name:     "image pull back-off outranks the deadline",
+			status:   deadlineExceeded,
+			pod:      func() *corev1.Pod { return testPod("p", waiting("ImagePullBackOff")) },
+			category: results.FailureImagePull,
+			exitCode: results.ExitCodeImagePull,
+			reason:   "test-runner: ImagePullBackOff: details",
+		},
+		{
+			name:   "service image pull",
+			status: batchv1.JobStatus{Failed: 1},
+			pod: func() *corev1.Pod {
+				pod := testPod("p", corev1.ContainerState{})
+				pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{Name: "postgres", State: waiting("ErrImagePull")}}
+				return pod
+			},
+			category: results.FailureImagePull,
+			exitCode: results.ExitCodeImagePull,
+		},
+		{
+			name:   "unschedulable",
+			status: deadlineExceeded,
+			pod: func() *corev1.Pod {
+				pod := testPod("p", corev1.ContainerState{})
+				pod.Status.Conditions = []corev1.PodCondition{{
+					Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable, Message: "0/3 nodes are available",
+				}}
+				return pod
+			},
+			category: results.FailureScheduling,
+			exitCode: results.ExitCodeScheduling,
+			reason:   "Unschedulable: 0/3 nodes are available",
+		},
+		{
+			name:   "evicted",
+			status: batchv1.JobStatus{Failed: 1},
+			pod: func() *corev1.Pod {
+				pod := testPod("p", terminated(137))
+				pod.Status.Reason, pod.Status.Message = "Evicted", "The node was low on resource: memory."
+				return pod
+			},
+			category: results.FailureInfraError,
+			exitCode: results.ExitCodeInfraError,
+			reason:   "Evicted: The node was low on resource: memory.",
+		},
+		{
+			name:   "out of memory",
+			status: batchv1.JobStatus{Failed: 1},
+			pod: func() *corev1.Pod {
+				return testPod("p", corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"}})
+			},
+			category: results.FailureOOM,
+			exitCode: results.ExitCodeOOM,
+		},
+		{
+			name:     "deadline exceeded while tests ran",
+			status:   deadlineExceeded,
+			pod:      func() *corev1.Pod { return testPod("p", terminated(143)) },
+			category: results.FailureTimeout,
+			exitCode: results.ExitCodeTimeout,
+		},
+		{
+			name:     "deadline exceeded after the pod was removed",
+			status:   deadlineExceeded,
+			pod:      func() *corev1.Pod { return nil },
+			category: results.FailureTimeout,
+			exitCode: results.ExitCodeTimeout,
+		},
+		{
+			name:     "container config error",
+			status:   batchv1.JobStatus{Failed: 1},
+			pod:      func() *corev1.Pod { return testPod("p", waiting("CreateContainerConfigError")) },
+			category: results.FailureInfraError,
+			exitCode: results.ExitCodeInfraError,
+			reason:   "test-runner: CreateContainerConfigError: details",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := testJob(tt.status)
+			var attempts []corev1.Pod
+			if pod := tt.pod(); pod != nil {
+				attempts = append(attempts, *pod)
+			}
+
+			result := classifyFailure(job, attempts, nil)
+			assert.False(t, result.Success)
+			assert.Equal(t, tt.category, result.Category)
+			assert.Equal(t, tt.exitCode, result.ExitCode)
+			if tt.reason != "" {
+				assert.Equal(t, tt.reason, result.Reason)
+			}
+			assert.Equal(t, len(attempts), result.Attempt)
+		})
+	}
+}
+
+func TestClassifyFailure_StuckPod(t *testing.T) {
+	job := testJob(batchv1.JobStatus{Active: 1})
+	pending := testPod("ket-app-abc", corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}})
+	client := fake.NewSimpleClientset(job, pending)
+
+	result, err := ClassifyFailure(context.Background(), client, job, errors.New("Test Runner Job ket-app did not start: no progress for 2m0s"))
+	require.NoError(t, err)
+	assert.Equal(t, results.FailureInfraError, result.Category)
+	assert.Equal(t, "Test Runner Job ket-app did not start: no progress for 2m0s", result.Reason)
+	assert.Equal(t, "ket-app-abc", result.Pod)
+}
+
+func TestWaitForTestCompletion_SeesStatusTransition(t *testing.T) {
+	job := testJob(batchv1.JobStatus{Active: 1})
+	client := fake.NewSimpleClientset(job, testPod("ket-app-abc", terminated(0)))
+
+	go func() {
+		// Keep updating until the watcher is established and observes the change
+		for i := 0; i < 100; i++ {
+			time.Sleep(20 * time.Millisecond)
+			updated := job.DeepCopy()
+			updated.Status = batchv1.JobStatus{Succeeded: 1}
+			updated.Annotations = map[string]string{"tick": time.Now().String()}
+			if _, err := client.BatchV1().Jobs(job.Namespace).UpdateStatus(context.Background(), updated, metav1.UpdateOptions{}); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := WaitForTestCompletion(ctx, client, job)
+	require.NoError(t, err)
+	assert.True(t, result.Success)
+}
+
+func TestWaitForTestCompletion_Cancelled(t *testing.T) {
+	job := testJob(batchv1.JobStatus{Active: 1})
+	client := fake.NewSimpleClientset(job)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := WaitForTestCompletion(ctx, client, job)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestStreamTestOutputToHost_StreamsTerminatedPod(t *testing.T) {
+	job := testJob(batchv1.JobStatus{Succeeded: 1})
+	client := fake.NewSimpleClientset(job, testPod("ket-app-abc", terminated(0)))
+
+	err := StreamTestOutputToHost(context.Background(), client, job, 0, AttemptHooks{})
+	require.NoError(t, err)
+
+	var logRequested bool
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "get" && action.GetSubresource() == "log" {
+			logRequested = true
+		}
+	}
+	assert.True(t, logRequested, "logs should be requested from the test runner pod")
+}
+
+func TestGetPodStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		expected string
+	}{
+		{"no statuses", &corev1.Pod{}, "ContainerCreating"},
+		{"waiting", testPod("p", corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull"}}), "Waiting: ErrImagePull"},
+		{"running", testPod("p", corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}), "Running"},
+		{"terminated", testPod("p", corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed"}}), "Terminated: Completed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, getPodStatus(*tt.pod))
+		})
+	}
+}
+
+func TestDeleteJob_ForegroundAndWaitsForRemoval(t *testing.T) {
+	job := testJob(batchv1.JobStatus{Active: 1})
+	client := fake.NewSimpleClientset(job)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, DeleteJob(ctx, client, job))
+
+	_, err := client.BatchV1().Jobs(job.Namespace).Get(ctx, job.Name, metav1.GetOptions{})
+	assert.Error(t, err)
+
+	// Deleting a job that is already gone is not an error
+	require.NoError(t, DeleteJob(ctx, client, job))
+}
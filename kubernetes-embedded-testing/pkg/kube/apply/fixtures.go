@@ -0,0 +1,228 @@
+package apply
+
+import (
+	"context"
+	"fmt"
+
+	"testrunner/pkg/logger"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// FieldManager is the server-side apply field manager ket applies fixtures with
+const FieldManager = "ket"
+
+// ClusterObject is a cluster-scoped object created by a run, which deleting the test namespace leaves behind
+type ClusterObject struct {
+	Resource schema.GroupVersionResource
+	Kind     string
+	Name     string
+}
+
+// Fixtures server-side applies the fixture objects in order. Namespaced objects are placed in the test
+// namespace, using the cluster's REST mapping to tell namespaced and cluster-scoped kinds apart.
+// It returns the cluster-scoped objects it created, also when it fails part way, so they can be
+// deleted after the run. Cluster-scoped objects that already existed are updated but not returned.
+func Fixtures(ctx context.Context, client dynamic.Interface, mapper meta.RESTMapper, namespace string, objects []*unstructured.Unstructured) ([]ClusterObject, error) {
+	var created []ClusterObject
+	for _, obj := range objects {
+		gvk := obj.GroupVersionKind()
+
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if meta.IsNoMatchError(err) {
+			// Kinds from CRDs applied earlier in the same fixtures only appear after rediscovery
+			if resettable, ok := mapper.(meta.ResettableRESTMapper); ok {
+				resettable.Reset()
+				mapping, err = mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+			}
+		}
+		if err != nil {
+			return created, fmt.Errorf("failed to map fixture %s %s: %w", gvk.Kind, obj.GetName(), err)
+		}
+
+		var resource dynamic.ResourceInterface
+		clusterScoped := mapping.Scope.Name() != meta.RESTScopeNameNamespace
+		if clusterScoped {
+			obj.SetNamespace("")
+			resource = client.Resource(mapping.Resource)
+		} else {
+			obj.SetNamespace(namespace)
+			resource = client.Resource(mapping.Resource).Namespace(namespace)
+		}
+
+		// Only cluster-scoped objects the run creates are its own to delete, not ones shared with others
+		isNew := false
+		if clusterScoped {
+			_, err := resource.Get(ctx, obj.GetName(), metav1.GetOptions{})
+			isNew = apierrors.IsNotFound(err)
+		}
+
+		logger.KubeLogger.Debug("Applying fixture %s %s", gvk.Kind, obj.GetName())
+		_, err = resource.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{FieldManager: FieldManager, Force: true})
+		if err != nil {
+			return created, fmt.Errorf("failed to apply fixture %s %s: %w", gvk.Kind, obj.GetName(), err)
+		}
+		if isNew {
+			created = append(created, ClusterObject{Resource: mapping.Resource, Kind: gvk.Kind, Name: obj.GetName()})
+		}
+	}
+	return created, nil
+}
+
+// DeleteClusterObjects deletes the cluster-scoped objects a run created, in the reverse order they were
+// created in. It returns one error per object that could not be deleted.
+func DeleteClusterObjects(ctx context.Context, client dynamic.Interface, objects []ClusterObject) []error {
+	var failures []error
+	for i := len(objects) - 1; i >= 0; i-- {
+		obj := objects[i]
+		logger.KubeLogger.Info("Deleting %s %s...", obj.Kind, obj.Name)
+		err := client.Resource(obj.Resource).Delete(ctx, obj.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			failures = append(failures, fmt.Errorf("%s %s: %w", obj.Kind, obj.Name, err))
+		}
+	}
+	return failures
+}
+
+// WaitForFixtures waits until the workload fixtures are ready: deployments available, statefulsets ready,
+// jobs complete and bare pods ready. Other kinds are considered ready once applied.
+func WaitForFixtures(ctx context.Context, client kubernetes.Interface, namespace string, objects []*unstructured.Unstructured) error {
+	for _, obj := range objects {
+		gk := obj.GroupVersionKind().GroupKind()
+		name := obj.GetName()
+
+		var err error
+		switch gk {
+		case appsv1.SchemeGroupVersion.WithKind("Deployment").GroupKind():
+			logger.KubeLogger.Info("Waiting for fixture deployment %s to become available...", name)
+			err = untilNamed(ctx, namespacedListWatch(ctx, name,
+				func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+					return client.AppsV1().Deployments(namespace).List(ctx, options)
+				},
+				func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+					return client.AppsV1().Deployments(namespace).Watch(ctx, options)
+				},
+			), &appsv1.Deployment{}, name, func(obj runtime.Object) (bool, error) {
+				return deploymentReady(obj.(*appsv1.Deployment))
+			})
+		case appsv1.SchemeGroupVersion.WithKind("StatefulSet").GroupKind():
+			logger.KubeLogger.Info("Waiting for fixture statefulset %s to become ready...", name)
+			err = untilNamed(ctx, namespacedListWatch(ctx, name,
+				func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+					return client.AppsV1().StatefulSets(namespace).List(ctx, options)
+				},
+				func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+					return client.AppsV1().StatefulSets(namespace).Watch(ctx, options)
+				},
+			), &appsv1.StatefulSet{}, name, func(obj runtime.Object) (bool, error) {
+				return statefulSetReady(obj.(*appsv1.StatefulSet)), nil
+			})
+		case batchv1.SchemeGroupVersion.WithKind("Job").GroupKind():
+			logger.KubeLogger.Info("Waiting for fixture job %s to complete...", name)
+			err = untilNamed(ctx, namespacedListWatch(ctx, name,
+				func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+					return client.BatchV1().Jobs(namespace).List(ctx, options)
+				},
+				func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+					return client.BatchV1().Jobs(namespace).Watch(ctx, options)
+				},
+			), &batchv1.Job{}, name, func(obj runtime.Object) (bool, error) {
+				return jobComplete(obj.(*batchv1.Job))
+			})
+		case corev1.SchemeGroupVersion.WithKind("Pod").GroupKind():
+			logger.KubeLogger.Info("Waiting for fixture pod %s to become ready...", name)
+			err = untilNamed(ctx, namespacedListWatch(ctx, name,
+				func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+					return client.CoreV1().Pods(namespace).List(ctx, options)
+				},
+				func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+					return client.CoreV1().Pods(namespace).Watch(ctx, options)
+				},
+			), &corev1.Pod{}, name, func(obj runtime.Object) (bool, error) {
+				return podReady(obj.(*corev1.Pod))
+			})
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("fixture %s %s did not become ready: %w", gk.Kind, name, err)
+		}
+	}
+	return nil
+}
+
+// deploymentReady reports whether the deployment has rolled out and all replicas are available
+func deploymentReady(deployment *appsv1.Deployment) (bool, error) {
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return false, nil
+	}
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return false, fmt.Errorf("deployment exceeded its progress deadline: %s", condition.Message)
+		}
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return deployment.Status.UpdatedReplicas >= replicas &&
+		deployment.Status.AvailableReplicas >= replicas &&
+		deployment.Status.Replicas == deployment.Status.UpdatedReplicas, nil
+}
+
+// statefulSetReady reports whether all replicas of the statefulset are ready
+func statefulSetReady(statefulSet *appsv1.StatefulSet) bool {
+	if statefulSet.Status.ObservedGeneration < statefulSet.Generation {
+		return false
+	}
+
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+	return statefulSet.Status.ReadyReplicas >= replicas
+}
+
+// jobComplete reports whether the job has completed, failing if it has failed
+func jobComplete(job *batchv1.Job) (bool, error) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return true, nil
+		case batchv1.JobFailed:
+			return false, fmt.Errorf("job failed: %s", condition.Message)
+		}
+	}
+	return false, nil
+}
+
+// podReady reports whether the pod is ready, or has run to completion
+func podReady(pod *corev1.Pod) (bool, error) {
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+		return true, nil
+	case corev1.PodFailed:
+		return false, fmt.Errorf("pod failed: %s", pod.Status.Message)
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,193 @@
+package apply
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta/testrestmapper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+)
+
+func fixture(apiVersion, kind, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetName(name)
+	return obj
+}
+
+func TestFixtures_AppliesWithNamespaceFromMapping(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClient(scheme.Scheme)
+
+	var patches []k8stesting.PatchAction
+	client.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patches = append(patches, action.(k8stesting.PatchAction))
+		return true, &unstructured.Unstructured{Object: map[string]interface{}{}}, nil
+	})
+
+	configMap := fixture("v1", "ConfigMap", "settings")
+	configMap.SetNamespace("elsewhere")
+	role := fixture("rbac.authorization.k8s.io/v1", "ClusterRole", "reader")
+	role.SetNamespace("test-namespace")
+
+	created, err := Fixtures(context.Background(), client, testrestmapper.TestOnlyStaticRESTMapper(scheme.Scheme), "test-namespace",
+		[]*unstructured.Unstructured{configMap, role})
+	require.NoError(t, err)
+	require.Len(t, patches, 2)
+	assert.Equal(t, []ClusterObject{{Resource: rbacv1.SchemeGroupVersion.WithResource("clusterroles"), Kind: "ClusterRole", Name: "reader"}}, created,
+		"only cluster-scoped objects are left for cleanup")
+
+	assert.Equal(t, "configmaps", patches[0].GetResource().Resource)
+	assert.Equal(t, "test-namespace", patches[0].GetNamespace())
+	assert.Equal(t, "test-namespace", configMap.GetNamespace())
+
+	assert.Equal(t, "clusterroles", patches[1].GetResource().Resource)
+	assert.Empty(t, patches[1].GetNamespace())
+	assert.Empty(t, role.GetNamespace())
+}
+
+func TestFixtures_UnknownKind(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClient(scheme.Scheme)
+
+	_, err := Fixtures(context.Background(), client, testrestmapper.TestOnlyStaticRESTMapper(scheme.Scheme), "test-namespace",
+		[]*unstructured.Unstructured{fixture("example.com/v1", "Widget", "w")})
+	assert.ErrorContains(t, err, "failed to map fixture Widget w")
+}
+
+func TestFixtures_ClusterScopedObjectsForCleanup(t *testing.T) {
+	existing := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "shared"}}
+	client := dynamicfake.NewSimpleDynamicClient(scheme.Scheme, existing)
+	client.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &unstructured.Unstructured{Object: map[string]interface{}{}}, nil
+	})
+
+	first := fixture("rbac.authorization.k8s.io/v1", "ClusterRole", "first")
+	shared := fixture("rbac.authorization.k8s.io/v1", "ClusterRole", "shared")
+	second := fixture("rbac.authorization.k8s.io/v1", "ClusterRoleBinding", "second")
+	created, err := Fixtures(context.Background(), client, testrestmapper.TestOnlyStaticRESTMapper(scheme.Scheme), "test-namespace",
+		[]*unstructured.Unstructured{first, shared, second, fixture("example.com/v1", "Widget", "w")})
+	require.Error(t, err)
+
+	var names []string
+	for _, obj := range created {
+		names = append(names, obj.Kind+"/"+obj.Name)
+	}
+	assert.Equal(t, []string{"ClusterRole/first", "ClusterRoleBinding/second"}, names,
+		"objects that existed before the run are not its own, and objects created before a failure are still returned")
+}
+
+func TestDeleteClusterObjects(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClient(scheme.Scheme,
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "first"}},
+		&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "second"}},
+	)
+	roles := rbacv1.SchemeGroupVersion.WithResource("clusterroles")
+	bindings := rbacv1.SchemeGroupVersion.WithResource("clusterrolebindings")
+
+	failures := DeleteClusterObjects(context.Background(), client, []ClusterObject{
+		{Resource: roles, Kind: "ClusterRole", Name: "first"},
+		{Resource: roles, Kind: "ClusterRole", Name: "already-gone"},
+		{Resource: bindings, Kind: "ClusterRoleBinding", Name: "second"},
+	})
+	assert.Empty(t, failures, "objects that are already gone are not failures")
+
+	var deleted []string
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "delete" {
+			deleted = append(deleted, action.(k8stesting.DeleteAction).GetName())
+		}
+	}
+	assert.Equal(t, []string{"second", "already-gone", "first"}, deleted, "objects are deleted in reverse order")
+}
+
+func TestWaitForFixtures_ReadyWorkloads(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "mongodb", Namespace: "test-namespace"},
+			Spec:       appsv1.DeploymentSpec{Replicas: ptr.To(int32(2))},
+			Status:     appsv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "test-namespace"},
+			Status:     appsv1.StatefulSetStatus{ReadyReplicas: 1},
+		},
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "seed", Namespace: "test-namespace"},
+			Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			}},
+		},
+	)
+
+	objects := []*unstructured.Unstructured{
+		fixture("v1", "ConfigMap", "settings"),
+		fixture("apps/v1", "Deployment", "mongodb"),
+		fixture("apps/v1", "StatefulSet", "redis"),
+		fixture("batch/v1", "Job", "seed"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, WaitForFixtures(ctx, client, "test-namespace", objects))
+}
+
+func TestWaitForFixtures_FailedJob(t *testing.T) {
+	client := fake.NewSimpleClientset(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "seed", Namespace: "test-namespace"},
+		Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+			{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"},
+		}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := WaitForFixtures(ctx, client, "test-namespace", []*unstructured.Unstructured{fixture("batch/v1", "Job", "seed")})
+	assert.ErrorContains(t, err, "fixture Job seed did not become ready")
+	assert.ErrorContains(t, err, "BackoffLimitExceeded")
+}
+
+func TestWaitForFixtures_TimesOutWhenNotReady(t *testing.T) {
+	client := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "mongodb", Namespace: "test-namespace"},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err := WaitForFixtures(ctx, client, "test-namespace", []*unstructured.Unstructured{fixture("apps/v1", "Deployment", "mongodb")})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestDeploymentReady(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Generation: 2},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+	}
+	ready, err := deploymentReady(deployment)
+	require.NoError(t, err)
+	assert.False(t, ready, "a deployment whose new generation is not observed yet is not ready")
+
+	deployment.Status.ObservedGeneration = 2
+	ready, err = deploymentReady(deployment)
+	require.NoError(t, err)
+	assert.True(t, ready)
+
+	deployment.Status.Conditions = []appsv1.DeploymentCondition{
+		{Type: appsv1.DeploymentProgressing, Reason: "ProgressDeadlineExceeded"},
+	}
+	_, err = deploymentReady(deployment)
+	assert.Error(t, err)
+}
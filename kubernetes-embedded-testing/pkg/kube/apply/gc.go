@@ -0,0 +1,274 @@
+package apply
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"testrunner/pkg/kube/generate"
+	"testrunner/pkg/logger"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// GCOptions selects which ket resources are garbage collected
+type GCOptions struct {
+	// Selector narrows the ket-managed objects considered, in label selector syntax
+	Selector string
+	// OlderThan reaps objects older than this regardless of their TTL, when positive
+	OlderThan time.Duration
+	// Now is the reference time for expiry, defaulting to the current time
+	Now time.Time
+}
+
+// GCCandidate is a ket resource that is expired or orphaned
+type GCCandidate struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Reason    string
+	// Resource is set for cluster-scoped fixtures, which are deleted through the dynamic client
+	Resource schema.GroupVersionResource
+}
+
+// FindGarbage lists the ket-managed namespaces, jobs, cluster RBAC objects and cluster-scoped fixtures
+// that are expired, older than opts.OlderThan, or cluster-scoped objects left behind by a test namespace
+// that no longer exists
+func FindGarbage(ctx context.Context, client kubernetes.Interface, dynamicClient dynamic.Interface, opts GCOptions) ([]GCCandidate, error) {
+	selector, err := gcSelector(opts.Selector)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	listOpts := metav1.ListOptions{LabelSelector: selector}
+
+	var candidates []GCCandidate
+	reapedNamespaces := map[string]bool{}
+
+	namespaces, err := client.CoreV1().Namespaces().List(ctx, listOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	for _, ns := range namespaces.Items {
+		if ns.DeletionTimestamp != nil {
+			continue
+		}
+		if reason, ok := gcReason(ns.ObjectMeta, opts); ok {
+			reapedNamespaces[ns.Name] = true
+			candidates = append(candidates, GCCandidate{Kind: "Namespace", Name: ns.Name, Reason: reason})
+		}
+	}
+
+	jobs, err := client.BatchV1().Jobs(metav1.NamespaceAll).List(ctx, listOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	for _, job := range jobs.Items {
+		// Jobs in a reaped namespace go with it
+		if job.DeletionTimestamp != nil || reapedNamespaces[job.Namespace] {
+			continue
+		}
+		if reason, ok := gcReason(job.ObjectMeta, opts); ok {
+			candidates = append(candidates, GCCandidate{Kind: "Job", Namespace: job.Namespace, Name: job.Name, Reason: reason})
+		}
+	}
+
+	// Cluster-scoped RBAC outlives its namespace, so it is also reaped once the namespace is gone
+	orphanReason := func(meta metav1.ObjectMeta) (string, bool, error) {
+		namespace := meta.Labels[generate.TestNamespaceLabel]
+		if namespace == "" {
+			return "", false, nil
+		}
+		if reapedNamespaces[namespace] {
+			return fmt.Sprintf("namespace %s is being reaped", namespace), true, nil
+		}
+		_, err := client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return fmt.Sprintf("orphaned, namespace %s no longer exists", namespace), true, nil
+		}
+		return "", false, err
+	}
+
+	bindings, err := client.RbacV1().ClusterRoleBindings().List(ctx, listOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ClusterRoleBindings: %w", err)
+	}
+	for _, binding := range bindings.Items {
+		reason, ok, err := orphanReason(binding.ObjectMeta)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check namespace of ClusterRoleBinding %s: %w", binding.Name, err)
+		}
+		if !ok {
+			reason, ok = gcReason(binding.ObjectMeta, opts)
+		}
+		if ok {
+			candidates = append(candidates, GCCandidate{Kind: "ClusterRoleBinding", Name: binding.Name, Reason: reason})
+		}
+	}
+
+	roles, err := client.RbacV1().ClusterRoles().List(ctx, listOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ClusterRoles: %w", err)
+	}
+	for _, role := range roles.Items {
+		reason, ok, err := orphanReason(role.ObjectMeta)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check namespace of ClusterRole %s: %w", role.Name, err)
+		}
+		if !ok {
+			reason, ok = gcReason(role.ObjectMeta, opts)
+		}
+		if ok {
+			candidates = append(candidates, GCCandidate{Kind: "ClusterRole", Name: role.Name, Reason: reason})
+		}
+	}
+
+	fixtures, err := findClusterFixtureGarbage(ctx, client, dynamicClient, listOpts, func(meta metav1.Object) (string, bool, error) {
+		objectMeta := metav1.ObjectMeta{Labels: meta.GetLabels(), Annotations: meta.GetAnnotations(), CreationTimestamp: meta.GetCreationTimestamp()}
+		reason, ok, err := orphanReason(objectMeta)
+		if err != nil || ok {
+			return reason, ok, err
+		}
+		reason, ok = gcReason(objectMeta, opts)
+		return reason, ok, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(candidates, fixtures...), nil
+}
+
+// gcHandledResources are the cluster-scoped resources FindGarbage lists through the typed client
+var gcHandledResources = map[schema.GroupResource]bool{
+	{Resource: "namespaces"}:                                   true,
+	{Group: rbacv1.GroupName, Resource: "clusterroles"}:        true,
+	{Group: rbacv1.GroupName, Resource: "clusterrolebindings"}: true,
+}
+
+// findClusterFixtureGarbage lists the ket-managed objects of every other cluster-scoped resource the
+// cluster serves, such as CRDs applied as fixtures, returning those reason says to reap. Resources
+// that cannot be discovered or listed are skipped.
+func findClusterFixtureGarbage(ctx context.Context, client kubernetes.Interface, dynamicClient dynamic.Interface, listOpts metav1.ListOptions, reason func(metav1.Object) (string, bool, error)) ([]GCCandidate, error) {
+	if dynamicClient == nil {
+		return nil, nil
+	}
+	_, lists, err := client.Discovery().ServerGroupsAndResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, fmt.Errorf("failed to discover cluster resources: %w", err)
+	}
+	lists = discovery.FilteredBy(discovery.ResourcePredicateFunc(func(_ string, resource *metav1.APIResource) bool {
+		verbs := sets.New(resource.Verbs...)
+		return !resource.Namespaced && !strings.Contains(resource.Name, "/") && verbs.HasAll("list", "delete")
+	}), lists)
+
+	var candidates []GCCandidate
+	// A resource served in several versions is listed once
+	listed := map[schema.GroupResource]bool{}
+	for _, list := range lists {
+		groupVersion, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, resource := range list.APIResources {
+			gvr := groupVersion.WithResource(resource.Name)
+			if gcHandledResources[gvr.GroupResource()] || listed[gvr.GroupResource()] {
+				continue
+			}
+			listed[gvr.GroupResource()] = true
+			objects, err := dynamicClient.Resource(gvr).List(ctx, listOpts)
+			if err != nil {
+				logger.KubeLogger.Debug("Skipping %s: %v", gvr.String(), err)
+				continue
+			}
+			for i := range objects.Items {
+				obj := &objects.Items[i]
+				if obj.GetDeletionTimestamp() != nil {
+					continue
+				}
+				why, ok, err := reason(obj)
+				if err != nil {
+					return nil, fmt.Errorf("failed to check namespace of %s %s: %w", resource.Kind, obj.GetName(), err)
+				}
+				if ok {
+					candidates = append(candidates, GCCandidate{Kind: resource.Kind, Name: obj.GetName(), Reason: why, Resource: gvr})
+				}
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// DeleteGarbage deletes the candidates found by FindGarbage, returning one error per object that could not be deleted
+func DeleteGarbage(ctx context.Context, client kubernetes.Interface, dynamicClient dynamic.Interface, candidates []GCCandidate) []error {
+	var failures []error
+	for _, candidate := range candidates {
+		logger.KubeLogger.Info("Deleting %s %s...", candidate.Kind, candidate.Name)
+
+		var err error
+		switch {
+		case candidate.Resource.Resource != "":
+			err = dynamicClient.Resource(candidate.Resource).Delete(ctx, candidate.Name, metav1.DeleteOptions{})
+		case candidate.Kind == "Namespace":
+			err = client.CoreV1().Namespaces().Delete(ctx, candidate.Name, metav1.DeleteOptions{})
+		case candidate.Kind == "Job":
+			policy := metav1.DeletePropagationBackground
+			err = client.BatchV1().Jobs(candidate.Namespace).Delete(ctx, candidate.Name, metav1.DeleteOptions{
+				PropagationPolicy: &policy,
+			})
+		case candidate.Kind == "ClusterRoleBinding":
+			err = client.RbacV1().ClusterRoleBindings().Delete(ctx, candidate.Name, metav1.DeleteOptions{})
+		case candidate.Kind == "ClusterRole":
+			err = client.RbacV1().ClusterRoles().Delete(ctx, candidate.Name, metav1.DeleteOptions{})
+		default:
+			err = errors.New("unsupported kind")
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			failures = append(failures, fmt.Errorf("%s %s: %w", candidate.Kind, candidate.Name, err))
+		}
+	}
+	return failures
+}
+
+// gcSelector returns the label selector matching ket-managed objects, narrowed by the user selector
+func gcSelector(userSelector string) (string, error) {
+	selector := labels.SelectorFromSet(labels.Set{generate.ManagedByLabel: generate.ManagedByValue})
+	if userSelector == "" {
+		return selector.String(), nil
+	}
+
+	parsed, err := labels.Parse(userSelector)
+	if err != nil {
+		return "", fmt.Errorf("invalid selector %q: %w", userSelector, err)
+	}
+	requirements, _ := parsed.Requirements()
+	return selector.Add(requirements...).String(), nil
+}
+
+// gcReason reports why an object should be reaped, if it should
+func gcReason(meta metav1.ObjectMeta, opts GCOptions) (string, bool) {
+	createdAt := meta.CreationTimestamp.Time
+	if parsed, err := time.Parse(time.RFC3339, meta.Annotations[generate.CreatedAtAnnotation]); err == nil {
+		createdAt = parsed
+	}
+
+	if opts.OlderThan > 0 && opts.Now.Sub(createdAt) >= opts.OlderThan {
+		return fmt.Sprintf("older than %s", opts.OlderThan), true
+	}
+
+	if expiry, ok := generate.RunExpiry(meta.Annotations, meta.CreationTimestamp.Time); ok && !opts.Now.Before(expiry) {
+		return fmt.Sprintf("expired, ttl %s", meta.Annotations[generate.TTLAnnotation]), true
+	}
+	return "", false
+}
@@ -0,0 +1,156 @@
+package apply
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"testrunner/pkg/kube/generate"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var gcNow = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// createRun creates the namespace, job and cluster RBAC of a run started at createdAt
+func createRun(t *testing.T, client *fake.Clientset, namespace string, createdAt time.Time, ttl time.Duration) {
+	t.Helper()
+	run := generate.RunMetadata{Namespace: namespace, RunID: namespace, CreatedAt: createdAt, TTL: ttl}
+
+	_, err := client.CoreV1().Namespaces().Create(context.Background(), generate.Namespace(run), metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = client.RbacV1().ClusterRoles().Create(context.Background(), generate.ClusterRole(run), metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = client.RbacV1().ClusterRoleBindings().Create(context.Background(), generate.ClusterRoleBinding(run), metav1.CreateOptions{})
+	require.NoError(t, err)
+}
+
+func candidateNames(candidates []GCCandidate) []string {
+	var names []string
+	for _, candidate := range candidates {
+		names = append(names, candidate.Kind+"/"+candidate.Name)
+	}
+	return names
+}
+
+func TestFindGarbage_ReapsExpiredRuns(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	createRun(t, client, "expired", gcNow.Add(-2*time.Hour), time.Hour)
+	createRun(t, client, "live", gcNow.Add(-30*time.Minute), time.Hour)
+
+	candidates, err := FindGarbage(context.Background(), client, nil, GCOptions{Now: gcNow})
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{
+		"Namespace/expired",
+		"ClusterRoleBinding/" + generate.TestRunnerRBACName("expired"),
+		"ClusterRole/" + generate.TestRunnerRBACName("expired"),
+	}, candidateNames(candidates))
+}
+
+func TestFindGarbage_ReapsOrphanedRBAC(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	createRun(t, client, "crashed", gcNow, 24*time.Hour)
+	require.NoError(t, client.CoreV1().Namespaces().Delete(context.Background(), "crashed", metav1.DeleteOptions{}))
+
+	candidates, err := FindGarbage(context.Background(), client, nil, GCOptions{Now: gcNow})
+	require.NoError(t, err)
+
+	require.Len(t, candidates, 2)
+	for _, candidate := range candidates {
+		assert.Contains(t, candidate.Reason, "orphaned")
+	}
+}
+
+func TestFindGarbage_OlderThanAndSelector(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	createRun(t, client, "old", gcNow.Add(-3*time.Hour), 0)
+	createRun(t, client, "new", gcNow.Add(-time.Minute), 0)
+
+	candidates, err := FindGarbage(context.Background(), client, nil, GCOptions{Now: gcNow})
+	require.NoError(t, err)
+	assert.Empty(t, candidates, "runs without a TTL are only reaped with --older-than")
+
+	candidates, err = FindGarbage(context.Background(), client, nil, GCOptions{Now: gcNow, OlderThan: time.Hour})
+	require.NoError(t, err)
+	assert.Len(t, candidates, 3)
+	for _, candidate := range candidates {
+		assert.Contains(t, candidate.Name, "old")
+	}
+
+	candidates, err = FindGarbage(context.Background(), client, nil, GCOptions{
+		Now:       gcNow,
+		OlderThan: time.Second,
+		Selector:  generate.TestNamespaceLabel + "=new",
+	})
+	require.NoError(t, err)
+	assert.Len(t, candidates, 3)
+	for _, candidate := range candidates {
+		assert.Contains(t, candidate.Name, "new")
+	}
+
+	_, err = FindGarbage(context.Background(), client, nil, GCOptions{Selector: "!!invalid"})
+	assert.Error(t, err)
+}
+
+func TestDeleteGarbage_RemovesCandidates(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	createRun(t, client, "expired", gcNow.Add(-2*time.Hour), time.Hour)
+
+	candidates, err := FindGarbage(context.Background(), client, nil, GCOptions{Now: gcNow})
+	require.NoError(t, err)
+	require.Empty(t, DeleteGarbage(context.Background(), client, nil, candidates))
+
+	namespaces, err := client.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, namespaces.Items)
+	roles, err := client.RbacV1().ClusterRoles().List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, roles.Items)
+
+	assert.Empty(t, DeleteGarbage(context.Background(), client, nil, candidates), "already deleted objects are not failures")
+}
+
+func TestFindGarbage_ReapsClusterScopedFixtures(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	createRun(t, client, "live", gcNow, 24*time.Hour)
+	client.Resources = []*metav1.APIResourceList{
+		{GroupVersion: "storage.k8s.io/v1", APIResources: []metav1.APIResource{
+			{Name: "storageclasses", Kind: "StorageClass", Verbs: metav1.Verbs{"list", "delete"}},
+		}},
+		{GroupVersion: "rbac.authorization.k8s.io/v1", APIResources: []metav1.APIResource{
+			{Name: "clusterroles", Kind: "ClusterRole", Verbs: metav1.Verbs{"list", "delete"}},
+		}},
+	}
+
+	storageClass := func(name, namespace string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("storage.k8s.io/v1")
+		obj.SetKind("StorageClass")
+		obj.SetName(name)
+		obj.SetLabels(generate.RunLabels(namespace))
+		return obj
+	}
+	storageClasses := schema.GroupVersionResource{Group: "storage.k8s.io", Version: "v1", Resource: "storageclasses"}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{storageClasses: "StorageClassList"},
+		storageClass("orphaned", "crashed"), storageClass("in-use", "live"))
+
+	candidates, err := FindGarbage(context.Background(), client, dynamicClient, GCOptions{Now: gcNow})
+	require.NoError(t, err)
+	require.Equal(t, []string{"StorageClass/orphaned"}, candidateNames(candidates))
+	assert.Equal(t, storageClasses, candidates[0].Resource)
+
+	require.Empty(t, DeleteGarbage(context.Background(), client, dynamicClient, candidates))
+	remaining, err := dynamicClient.Resource(storageClasses).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, remaining.Items, 1)
+	assert.Equal(t, "in-use", remaining.Items[0].GetName())
+}
@@ -4,25 +4,57 @@ import (
 	"context"
 	"fmt"
 
-	"testrunner/pkg/config"
-	"testrunner/pkg/kube/generate"
+	"testrunner/pkg/logger"
 
 	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 )
 
-// Job creates a job in the cluster
-func Job(ctx context.Context, client *kubernetes.Clientset, cfg config.Config, namespace string) (*batchv1.Job, error) {
-	job, err := generate.Job(cfg, namespace)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate job manifest: %w", err)
+// Jobs creates the test runner jobs in the run's test namespace. The jobs created before a failure
+// are returned alongside the error so they can be cleaned up.
+func Jobs(ctx context.Context, client kubernetes.Interface, jobs []*batchv1.Job) ([]*batchv1.Job, error) {
+	var created []*batchv1.Job
+	for _, job := range jobs {
+		result, err := client.BatchV1().Jobs(job.Namespace).Create(ctx, job, metav1.CreateOptions{})
+		if err != nil {
+			return created, fmt.Errorf("failed to create job %s: %w", job.Name, err)
+		}
+		created = append(created, result)
 	}
 
-	created, err := client.BatchV1().Jobs(namespace).Create(ctx, job, metav1.CreateOptions{})
+	return created, nil
+}
+
+// DeleteJob deletes the job with foreground propagation and waits until it is gone.
+// Foreground deletion removes the job's pods before the job itself, so no test pod outlives it.
+func DeleteJob(ctx context.Context, client kubernetes.Interface, job *batchv1.Job) error {
+	logger.KubeLogger.Info("Deleting job %s...", job.Name)
+
+	policy := metav1.DeletePropagationForeground
+	err := client.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{
+		PropagationPolicy: &policy,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create job: %w", err)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete job %s: %w", job.Name, err)
 	}
 
-	return created, nil
+	err = untilDeleted(ctx, namespacedListWatch(ctx, job.Name,
+		func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			return client.BatchV1().Jobs(job.Namespace).List(ctx, options)
+		},
+		func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			return client.BatchV1().Jobs(job.Namespace).Watch(ctx, options)
+		},
+	), &batchv1.Job{}, job.Name)
+	if err != nil {
+		return fmt.Errorf("job %s was not removed: %w", job.Name, err)
+	}
+	return nil
 }
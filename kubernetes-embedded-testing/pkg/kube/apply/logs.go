@@ -0,0 +1,201 @@
+package apply
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"testrunner/pkg/kube/generate"
+	"testrunner/pkg/logger"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// maxLogStreamErrors is how many log streams in a row may fail before following the pod gives up
+const maxLogStreamErrors = 10
+
+// logReconnectDelay is how long to wait for the test container to exit after its log stream ended,
+// before resuming the stream
+var logReconnectDelay = 2 * time.Second
+
+// followTestOutput passes the output of the pod's test runner container to emit line by line until the
+// container exits. A stream the API server drops is resumed from the timestamp of the last line emitted.
+func followTestOutput(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod, emit func(string)) error {
+	logger.KubeLogger.Info("Streaming test output from pod %s", pod.Name)
+
+	cursor := &logCursor{}
+	failures := 0
+	for {
+		streamErr := streamTestOutput(ctx, client, pod, cursor, true, emit)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		exited, err := waitForTestContainerExit(ctx, client, pod, logReconnectDelay)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.KubeLogger.Warn("Failed to get the status of pod %s: %v", pod.Name, err)
+		}
+		if exited {
+			// The stream may have dropped shortly before the container exited, so pick up what it missed
+			if cursor.resumable() {
+				if err := streamTestOutput(ctx, client, pod, cursor, false, emit); err != nil {
+					logger.KubeLogger.Warn("Failed to read the rest of the test output from pod %s: %v", pod.Name, err)
+				}
+			}
+			return nil
+		}
+
+		if streamErr != nil {
+			failures++
+			if failures >= maxLogStreamErrors {
+				return fmt.Errorf("failed to stream test output from pod %s: %w", pod.Name, streamErr)
+			}
+			logger.KubeLogger.Warn("Failed to stream test output from pod %s, reconnecting: %v", pod.Name, streamErr)
+		} else {
+			failures = 0
+			logger.KubeLogger.Info("Test output stream of pod %s ended before its test container exited, resuming", pod.Name)
+		}
+	}
+}
+
+// streamTestOutput prints the output of the pod's test runner container from where the cursor is
+func streamTestOutput(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod, cursor *logCursor, follow bool, emit func(string)) error {
+	options := &corev1.PodLogOptions{
+		Container:  generate.TestRunnerContainerName,
+		Follow:     follow,
+		Timestamps: true,
+	}
+	if !cursor.last.IsZero() {
+		since := metav1.NewTime(cursor.last)
+		options.SinceTime = &since
+	}
+
+	stream, err := client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, options).Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get test output stream: %w", err)
+	}
+	defer stream.Close()
+	return cursor.copy(stream, emit)
+}
+
+// waitForTestContainerExit waits up to timeout for the pod's test runner container to terminate, and
+// reports whether it did. A pod that is gone counts as exited.
+func waitForTestContainerExit(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod, timeout time.Duration) (bool, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	lw := namespacedListWatch(waitCtx, pod.Name,
+		func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().Pods(pod.Namespace).List(ctx, options)
+		},
+		func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			return client.CoreV1().Pods(pod.Namespace).Watch(ctx, options)
+		},
+	)
+	gone := func(store cache.Store) (bool, error) {
+		for _, obj := range store.List() {
+			if current, ok := obj.(*corev1.Pod); ok && current.Name == pod.Name {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	_, err := watchtools.UntilWithSync(waitCtx, lw, &corev1.Pod{}, gone, func(event watch.Event) (bool, error) {
+		current, ok := event.Object.(*corev1.Pod)
+		if !ok || current.Name != pod.Name {
+			return false, nil
+		}
+		if event.Type == watch.Deleted {
+			return true, nil
+		}
+		status := testRunnerStatus(*current)
+		return status != nil && status.State.Terminated != nil, nil
+	})
+	switch {
+	case err == nil:
+		return true, nil
+	case ctx.Err() != nil:
+		return false, ctx.Err()
+	case waitCtx.Err() != nil:
+		return false, nil
+	}
+	return false, err
+}
+
+// logCursor tracks how far the timestamped output of a container has been printed, so a stream
+// resumed with SinceTime skips the lines already shown. SinceTime only has a resolution of a second,
+// so lines stamped with the time of the last line printed are skipped by how many of them were.
+type logCursor struct {
+	// last is the timestamp of the last line printed, and count how many lines carried it
+	last  time.Time
+	count int
+	// seen counts the lines of the current stream stamped with last
+	seen int
+	// lines counts every line printed
+	lines int
+}
+
+// resumable reports whether a stream resumed from the cursor cannot repeat lines: either nothing was
+// printed yet, or the lines printed carried timestamps
+func (c *logCursor) resumable() bool {
+	return c.lines == 0 || !c.last.IsZero()
+}
+
+// copy prints the new lines of a stream of timestamped log lines, without their timestamps
+func (c *logCursor) copy(reader io.Reader, emit func(string)) error {
+	c.seen = 0
+	buffered := bufio.NewReader(reader)
+	for {
+		line, err := buffered.ReadString('\n')
+		if err != nil && err != io.EOF {
+			// A partial line is printed in full once the stream resumes
+			return err
+		}
+		if line != "" {
+			c.write(strings.TrimSuffix(line, "\n"), emit)
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// write prints a timestamped log line unless it was printed before. Lines without a timestamp are
+// always printed.
+func (c *logCursor) write(line string, emit func(string)) {
+	stamp, text, _ := strings.Cut(line, " ")
+	at, err := time.Parse(time.RFC3339Nano, stamp)
+	if err != nil {
+		c.lines++
+		emit(line)
+		return
+	}
+
+	switch {
+	case at.Before(c.last):
+		return
+	case at.Equal(c.last):
+		c.seen++
+		if c.seen <= c.count {
+			return
+		}
+		c.count++
+	default:
+		c.last, c.count, c.seen = at, 1, 1
+	}
+	c.lines++
+	emit(text)
+}
@@ -0,0 +1,246 @@
+package apply
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	fakerest "k8s.io/client-go/rest/fake"
+)
+
+// logsClient is a fake clientset whose pod logs are served by logs, where the fake's own are fixed
+type logsClient struct {
+	*fake.Clientset
+	logs func(pod string, options *corev1.PodLogOptions) io.Reader
+}
+
+func (c *logsClient) CoreV1() corev1client.CoreV1Interface {
+	return &logsCoreV1{CoreV1Interface: c.Clientset.CoreV1(), logs: c.logs}
+}
+
+type logsCoreV1 struct {
+	corev1client.CoreV1Interface
+	logs func(pod string, options *corev1.PodLogOptions) io.Reader
+}
+
+func (c *logsCoreV1) Pods(namespace string) corev1client.PodInterface {
+	return &logsPods{PodInterface: c.CoreV1Interface.Pods(namespace), namespace: namespace, logs: c.logs}
+}
+
+type logsPods struct {
+	corev1client.PodInterface
+	namespace string
+	logs      func(pod string, options *corev1.PodLogOptions) io.Reader
+}
+
+func (p *logsPods) GetLogs(name string, options *corev1.PodLogOptions) *rest.Request {
+	client := &fakerest.RESTClient{
+		Client: fakerest.CreateHTTPClient(func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(p.logs(name, options))}, nil
+		}),
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		GroupVersion:         corev1.SchemeGroupVersion,
+		VersionedAPIPath:     fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/log", p.namespace, name),
+	}
+	return client.Request()
+}
+
+var _ kubernetes.Interface = &logsClient{}
+
+// droppedStream returns the content and then fails like a connection the API server dropped
+func droppedStream(content string) io.Reader {
+	return io.MultiReader(strings.NewReader(content), &failingReader{err: io.ErrUnexpectedEOF})
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// captureStdout returns what fn prints to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	original := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = original }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-output
+}
+
+func TestLogCursor_SkipsLinesPrintedBeforeWithinTheSameSecond(t *testing.T) {
+	var lines []string
+	emit := func(line string) { lines = append(lines, line) }
+	cursor := &logCursor{}
+
+	first := "2026-01-02T10:00:00.100Z one\n2026-01-02T10:00:01.200Z two\n2026-01-02T10:00:01.200Z three\n"
+	require.NoError(t, cursor.copy(strings.NewReader(first), emit))
+
+	// A stream resumed with SinceTime repeats the lines of the last second, and the same timestamp can
+	// carry several lines
+	resumed := "2026-01-02T10:00:01.200Z two\n2026-01-02T10:00:01.200Z three\n2026-01-02T10:00:01.200Z four\n2026-01-02T10:00:02Z five\n"
+	require.NoError(t, cursor.copy(strings.NewReader(resumed), emit))
+
+	assert.Equal(t, []string{"one", "two", "three", "four", "five"}, lines)
+}
+
+func TestLogCursor_PrintsLinesWithoutTimestamps(t *testing.T) {
+	var lines []string
+	cursor := &logCursor{}
+	require.NoError(t, cursor.copy(strings.NewReader("plain output\nno newline"), func(line string) { lines = append(lines, line) }))
+
+	assert.Equal(t, []string{"plain output", "no newline"}, lines)
+	assert.False(t, cursor.resumable(), "a stream without timestamps cannot be resumed without repeating lines")
+}
+
+func TestFollowTestOutput_ReconnectsAfterStreamDrops(t *testing.T) {
+	defer func(delay time.Duration) { logReconnectDelay = delay }(logReconnectDelay)
+	logReconnectDelay = 500 * time.Millisecond
+
+	pod := testPod("ket-app-abc", corev1.ContainerState{Running: &corev1.ContainerStateRunning{}})
+	clientset := fake.NewSimpleClientset(pod)
+
+	var (
+		mu    sync.Mutex
+		since []string
+	)
+	client := &logsClient{Clientset: clientset, logs: func(_ string, options *corev1.PodLogOptions) io.Reader {
+		mu.Lock()
+		defer mu.Unlock()
+		if options.SinceTime == nil {
+			since = append(since, "")
+		} else {
+			since = append(since, options.SinceTime.UTC().Format(time.RFC3339))
+		}
+
+		switch len(since) {
+		case 1:
+			return droppedStream("2026-01-02T10:00:00.1Z one\n2026-01-02T10:00:01.1Z two\n2026-01-02T10:00:01.2Z thr")
+		case 2:
+			// The test container exits while the resumed stream runs
+			exited := pod.DeepCopy()
+			exited.Status.ContainerStatuses[1].State = terminated(0)
+			_, err := clientset.CoreV1().Pods(pod.Namespace).UpdateStatus(context.Background(), exited, metav1.UpdateOptions{})
+			assert.NoError(t, err)
+			return strings.NewReader("2026-01-02T10:00:01.1Z two\n2026-01-02T10:00:01.2Z three\n2026-01-02T10:00:03Z four\n")
+		default:
+			return strings.NewReader("2026-01-02T10:00:03Z four\n2026-01-02T10:00:03.5Z five\n")
+		}
+	}}
+
+	var lines []string
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, followTestOutput(ctx, client, pod, func(line string) { lines = append(lines, line) }))
+
+	assert.Equal(t, []string{"one", "two", "three", "four", "five"}, lines)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"", "2026-01-02T10:00:01Z", "2026-01-02T10:00:03Z"}, since,
+		"streams should resume from the last line printed, and pick up the rest once the container exited")
+}
+
+func TestFollowTestOutput_GivesUpAfterRepeatedFailures(t *testing.T) {
+	defer func(delay time.Duration) { logReconnectDelay = delay }(logReconnectDelay)
+	logReconnectDelay = time.Millisecond
+
+	pod := testPod("ket-app-abc", corev1.ContainerState{Running: &corev1.ContainerStateRunning{}})
+	client := &logsClient{Clientset: fake.NewSimpleClientset(pod), logs: func(string, *corev1.PodLogOptions) io.Reader {
+		return &failingReader{err: errors.New("connection reset")}
+	}}
+
+	err := followTestOutput(context.Background(), client, pod, func(string) {})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "connection reset")
+}
+
+func TestStreamTestOutputToHost_FollowsRetries(t *testing.T) {
+	backoffLimit := int32(1)
+	job := testJob(batchv1.JobStatus{Active: 1, Failed: 1})
+	job.Spec.BackoffLimit = &backoffLimit
+	first := testPod("ket-app-aaaaa", terminated(1))
+	first.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Minute))
+	second := testPod("ket-app-bbbbb", corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}})
+	second.CreationTimestamp = metav1.NewTime(time.Now())
+	clientset := fake.NewSimpleClientset(job, first, second)
+
+	client := &logsClient{Clientset: clientset, logs: func(pod string, _ *corev1.PodLogOptions) io.Reader {
+		return strings.NewReader("2026-01-02T10:00:00Z output of " + pod + "\n")
+	}}
+
+	var before []int
+	hooks := AttemptHooks{
+		Before: func(followed map[string]bool) error {
+			before = append(before, len(followed))
+			if len(followed) == 1 {
+				// The retry pod runs to completion and the job succeeds
+				ctx := context.Background()
+				done := second.DeepCopy()
+				done.Status.ContainerStatuses[1].State = terminated(0)
+				if _, err := clientset.CoreV1().Pods(done.Namespace).UpdateStatus(ctx, done, metav1.UpdateOptions{}); err != nil {
+					return err
+				}
+				succeeded := job.DeepCopy()
+				succeeded.Status = batchv1.JobStatus{Failed: 1, Succeeded: 1}
+				if _, err := clientset.BatchV1().Jobs(job.Namespace).UpdateStatus(ctx, succeeded, metav1.UpdateOptions{}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var err error
+	output := captureStdout(t, func() {
+		err = StreamTestOutputToHost(ctx, client, job, time.Minute, hooks)
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []int{0, 1}, before, "Before should run for each attempt")
+	firstHeader := strings.Index(output, "===== attempt 1 of 2: pod ket-app-aaaaa =====")
+	secondHeader := strings.Index(output, "===== attempt 2 of 2: pod ket-app-bbbbb =====")
+	require.GreaterOrEqual(t, firstHeader, 0, output)
+	require.Greater(t, secondHeader, firstHeader, output)
+	assert.Contains(t, output[firstHeader:secondHeader], "output of ket-app-aaaaa")
+	assert.Contains(t, output[secondHeader:], "output of ket-app-bbbbb")
+}
+
+func TestAttemptHeader(t *testing.T) {
+	pod := testPod("ket-app-abc", corev1.ContainerState{})
+	job := testJob(batchv1.JobStatus{})
+	assert.Empty(t, attemptHeader(job, pod, 1), "jobs without retries have no attempt header")
+
+	backoffLimit := int32(2)
+	job.Spec.BackoffLimit = &backoffLimit
+	assert.Equal(t, "===== attempt 2 of 3: pod ket-app-abc =====", attemptHeader(job, pod, 2))
+}
@@ -5,30 +5,33 @@ import (
 	"fmt"
 	"strings"
 
-	"testrunner/pkg/kube/generate"
 	"testrunner/pkg/logger"
 
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 )
 
-// Namespace creates a namespace in the cluster
-func Namespace(ctx context.Context, client *kubernetes.Clientset, namespace string) (string, error) {
-	ns := generate.Namespace(namespace)
-
-	created, err := client.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
+// Namespace creates the run's test namespace in the cluster. It reports whether the namespace was
+// created, as opposed to already existing, since only a namespace the run created is its own to delete.
+func Namespace(ctx context.Context, client kubernetes.Interface, ns *corev1.Namespace) (bool, error) {
+	_, err := client.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
 	if err != nil {
-		if !strings.Contains(err.Error(), "already exists") {
-			return "", fmt.Errorf("failed to create namespace: %w", err)
+		if !apierrors.IsAlreadyExists(err) {
+			return false, fmt.Errorf("failed to create namespace: %w", err)
 		}
-		return namespace, nil
+		logger.KubeLogger.Info("Namespace %s already exists, it will not be deleted after the run", ns.Name)
+		return false, nil
 	}
 
-	return created.Name, nil
+	return true, nil
 }
 
 // DeleteNamespace deletes a namespace
-func DeleteNamespace(ctx context.Context, client *kubernetes.Clientset, namespace string) error {
+func DeleteNamespace(ctx context.Context, client kubernetes.Interface, namespace string) error {
 	logger.KubeLogger.Info("Deleting namespace: %s", namespace)
 
 	err := client.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{})
@@ -43,3 +46,21 @@ func DeleteNamespace(ctx context.Context, client *kubernetes.Clientset, namespac
 	logger.KubeLogger.Info("Namespace %s deletion requested", namespace)
 	return nil
 }
+
+// WaitForNamespaceDeletion waits until the namespace has finished terminating
+func WaitForNamespaceDeletion(ctx context.Context, client kubernetes.Interface, namespace string) error {
+	err := untilDeleted(ctx, namespacedListWatch(ctx, namespace,
+		func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().Namespaces().List(ctx, options)
+		},
+		func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			return client.CoreV1().Namespaces().Watch(ctx, options)
+		},
+	), &corev1.Namespace{}, namespace)
+	if err != nil {
+		return fmt.Errorf("namespace %s did not finish terminating: %w", namespace, err)
+	}
+
+	logger.KubeLogger.Info("Namespace %s deleted", namespace)
+	return nil
+}
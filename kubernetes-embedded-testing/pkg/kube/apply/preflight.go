@@ -0,0 +1,97 @@
+package apply
+
+import (
+	"context"
+	"fmt"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PermissionCheck is an action on a resource the invoking user has to be allowed to take
+type PermissionCheck struct {
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+	// Namespace is empty for cluster-scoped resources
+	Namespace string
+	// Purpose says what ket needs the permission for
+	Purpose string
+}
+
+// ResourceName describes the checked resource as resource[/subresource][.group]
+func (c PermissionCheck) ResourceName() string {
+	name := c.Resource
+	if c.Subresource != "" {
+		name += "/" + c.Subresource
+	}
+	if c.Group != "" {
+		name += "." + c.Group
+	}
+	return name
+}
+
+// PermissionResult is the answer of the API server to a PermissionCheck
+type PermissionResult struct {
+	PermissionCheck
+	Allowed bool
+	// Reason is the authorizer's explanation, when it gives one
+	Reason string
+}
+
+// CheckPermissions asks the API server whether the invoking user may take each action, with one
+// SelfSubjectAccessReview per check, before any of them is attempted
+func CheckPermissions(ctx context.Context, client kubernetes.Interface, checks []PermissionCheck) ([]PermissionResult, error) {
+	results := make([]PermissionResult, 0, len(checks))
+	for _, check := range checks {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   check.Namespace,
+					Verb:        check.Verb,
+					Group:       check.Group,
+					Resource:    check.Resource,
+					Subresource: check.Subresource,
+				},
+			},
+		}
+		response, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to check permission to %s %s: %w", check.Verb, check.ResourceName(), err)
+		}
+		results = append(results, PermissionResult{
+			PermissionCheck: check,
+			Allowed:         response.Status.Allowed,
+			Reason:          response.Status.Reason,
+		})
+	}
+	return results, nil
+}
+
+// HeldRules asks the API server with a SelfSubjectRulesReview which rules the invoking user holds in
+// the namespace, including those bound cluster-wide. Incomplete is set when an authorizer could not
+// list its rules, so rules missing from the list may still be held.
+func HeldRules(ctx context.Context, client kubernetes.Interface, namespace string) (rules []rbacv1.PolicyRule, incomplete bool, err error) {
+	review := &authorizationv1.SelfSubjectRulesReview{
+		Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: namespace},
+	}
+	response, err := client.AuthorizationV1().SelfSubjectRulesReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to review the rules held in namespace %s: %w", namespace, err)
+	}
+	for _, rule := range response.Status.ResourceRules {
+		rules = append(rules, rbacv1.PolicyRule{
+			Verbs:         rule.Verbs,
+			APIGroups:     rule.APIGroups,
+			Resources:     rule.Resources,
+			ResourceNames: rule.ResourceNames,
+		})
+	}
+	for _, rule := range response.Status.NonResourceRules {
+		rules = append(rules, rbacv1.PolicyRule{Verbs: rule.Verbs, NonResourceURLs: rule.NonResourceURLs})
+	}
+	return rules, response.Status.Incomplete, nil
+}
@@ -0,0 +1,75 @@
+package apply
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCheckPermissions(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	var reviewed []authorizationv1.ResourceAttributes
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview).DeepCopy()
+		reviewed = append(reviewed, *review.Spec.ResourceAttributes)
+		if review.Spec.ResourceAttributes.Subresource == "log" {
+			review.Status.Reason = "no RBAC policy matched"
+		} else {
+			review.Status.Allowed = true
+		}
+		return true, review, nil
+	})
+
+	results, err := CheckPermissions(context.Background(), client, []PermissionCheck{
+		{Verb: "create", Group: "batch", Resource: "jobs", Namespace: "ns", Purpose: "create the test jobs"},
+		{Verb: "get", Resource: "pods", Subresource: "log", Namespace: "ns", Purpose: "stream the test output"},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.True(t, results[0].Allowed)
+	assert.Equal(t, "jobs.batch", results[0].ResourceName())
+	assert.False(t, results[1].Allowed)
+	assert.Equal(t, "no RBAC policy matched", results[1].Reason)
+	assert.Equal(t, "pods/log", results[1].ResourceName())
+	assert.Equal(t, authorizationv1.ResourceAttributes{Namespace: "ns", Verb: "get", Resource: "pods", Subresource: "log"}, reviewed[1])
+}
+
+func TestCheckPermissions_ReviewFails(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+
+	_, err := CheckPermissions(context.Background(), client, []PermissionCheck{{Verb: "create", Resource: "namespaces"}})
+	assert.ErrorContains(t, err, "failed to check permission to create namespaces: connection refused")
+}
+
+func TestHeldRules(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "selfsubjectrulesreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectRulesReview).DeepCopy()
+		assert.Equal(t, "ns", review.Spec.Namespace)
+		review.Status = authorizationv1.SubjectRulesReviewStatus{
+			ResourceRules:    []authorizationv1.ResourceRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+			NonResourceRules: []authorizationv1.NonResourceRule{{Verbs: []string{"get"}, NonResourceURLs: []string{"/version"}}},
+			Incomplete:       true,
+		}
+		return true, review, nil
+	})
+
+	rules, incomplete, err := HeldRules(context.Background(), client, "ns")
+	require.NoError(t, err)
+	assert.True(t, incomplete)
+	assert.Equal(t, []rbacv1.PolicyRule{
+		{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+		{Verbs: []string{"get"}, NonResourceURLs: []string{"/version"}},
+	}, rules)
+}
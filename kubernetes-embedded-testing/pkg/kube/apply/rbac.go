@@ -2,59 +2,120 @@ package apply
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
-	"testrunner/pkg/config"
 	"testrunner/pkg/kube/generate"
+	"testrunner/pkg/logger"
 
-	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
-// RBAC creates the necessary RBAC resources for the test namespace
-func RBAC(ctx context.Context, client *kubernetes.Clientset, namespace string, cfg *config.Config) error {
-	// Load additional RBAC rules from file if specified
-	var additionalRules []rbacv1.PolicyRule
-	if cfg != nil && cfg.RbacFile != "" {
-		rules, err := generate.LoadRBACRulesFromFile(cfg.RbacFile)
-		if err != nil {
-			return fmt.Errorf("failed to load RBAC rules from file: %w", err)
-		}
-		additionalRules = rules
+// RBAC creates the per-run RBAC resources for the run's test namespace: the test runner's
+// ServiceAccount and its namespaced Role and RoleBinding, then its cluster-wide ones if it has any.
+// The namespaced objects are updated if a kept namespace still has them from an earlier run.
+func RBAC(ctx context.Context, client kubernetes.Interface, rbac generate.RunRBAC) error {
+	namespace := rbac.ServiceAccount.Namespace
+	_, err := client.CoreV1().ServiceAccounts(namespace).Create(ctx, rbac.ServiceAccount, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create service account %s: %w", rbac.ServiceAccount.Name, err)
+	}
+
+	roles := client.RbacV1().Roles(namespace)
+	_, err = roles.Create(ctx, rbac.Role, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = roles.Update(ctx, rbac.Role, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create role %s: %w", rbac.Role.Name, err)
+	}
+
+	roleBindings := client.RbacV1().RoleBindings(namespace)
+	_, err = roleBindings.Create(ctx, rbac.RoleBinding, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = roleBindings.Update(ctx, rbac.RoleBinding, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create role binding %s: %w", rbac.RoleBinding.Name, err)
+	}
+
+	if rbac.ClusterRole == nil {
+		return nil
 	}
-	
-	role := generate.ClusterRole(additionalRules...)
-	roleBinding := generate.ClusterRoleBinding(namespace)
 
-	_, err := client.RbacV1().ClusterRoles().Create(ctx, role, metav1.CreateOptions{})
+	_, err = client.RbacV1().ClusterRoles().Create(ctx, rbac.ClusterRole, metav1.CreateOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to create role: %w", err)
+		return fmt.Errorf("failed to create cluster role %s: %w", rbac.ClusterRole.Name, err)
 	}
 
-	_, err = client.RbacV1().ClusterRoleBindings().Create(ctx, roleBinding, metav1.CreateOptions{})
+	_, err = client.RbacV1().ClusterRoleBindings().Create(ctx, rbac.ClusterRoleBinding, metav1.CreateOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to create role binding: %w", err)
+		return fmt.Errorf("failed to create cluster role binding %s: %w", rbac.ClusterRoleBinding.Name, err)
 	}
 
 	return nil
 }
 
-func DeleteRBAC(ctx context.Context, client *kubernetes.Clientset, namespace string) error {
-	// Explicitly delete the ClusterRoleBinding.
-	// This is a cluster-scoped object and is not cleaned up by namespace deletion.
-	fmt.Println("Deleting ClusterRoleBinding ket-test-runner...")
-	if err := client.RbacV1().ClusterRoleBindings().Delete(ctx, "ket-test-runner", metav1.DeleteOptions{}); err != nil {
-		return fmt.Errorf("failed to delete ClusterRoleBinding ket-test-runner: %w", err)
+// DeleteRBAC deletes the cluster-scoped RBAC resources owned by the run in the given test namespace.
+// Only objects labelled for this namespace are removed, so concurrent runs are left untouched.
+func DeleteRBAC(ctx context.Context, client kubernetes.Interface, namespace string) error {
+	listOpts := metav1.ListOptions{LabelSelector: generate.RunSelector(namespace)}
+
+	// ClusterRoleBindings and ClusterRoles are cluster-scoped objects and are not cleaned up by namespace deletion.
+	bindings, err := client.RbacV1().ClusterRoleBindings().List(ctx, listOpts)
+	if err != nil {
+		return fmt.Errorf("failed to list ClusterRoleBindings for namespace %s: %w", namespace, err)
+	}
+	// Keep going after a failure so every object that could not be removed is reported
+	var errs []error
+	for _, binding := range bindings.Items {
+		logger.KubeLogger.Info("Deleting ClusterRoleBinding %s...", binding.Name)
+		err := client.RbacV1().ClusterRoleBindings().Delete(ctx, binding.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to delete ClusterRoleBinding %s: %w", binding.Name, err))
+		}
 	}
 
-	// Explicitly delete the ClusterRole.
-	// This is a cluster-scoped object and is not cleaned up by namespace deletion.
-	fmt.Println("Deleting ClusterRole ket-test-runner...")
-	if err := client.RbacV1().ClusterRoles().Delete(ctx, "ket-test-runner", metav1.DeleteOptions{}); err != nil {
-		return fmt.Errorf("failed to delete ClusterRole ket-test-runner: %w", err)
+	roles, err := client.RbacV1().ClusterRoles().List(ctx, listOpts)
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("failed to list ClusterRoles for namespace %s: %w", namespace, err))...)
+	}
+	for _, role := range roles.Items {
+		logger.KubeLogger.Info("Deleting ClusterRole %s...", role.Name)
+		err := client.RbacV1().ClusterRoles().Delete(ctx, role.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to delete ClusterRole %s: %w", role.Name, err))
+		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
+// DeleteNamespacedRBAC deletes the test runner's ServiceAccount, Role and RoleBinding. Deleting the test
+// namespace removes them too, so this is only needed for a namespace the run did not create.
+func DeleteNamespacedRBAC(ctx context.Context, client kubernetes.Interface, rbac generate.RunRBAC) error {
+	namespace := rbac.ServiceAccount.Namespace
+	var errs []error
+
+	logger.KubeLogger.Info("Deleting RoleBinding %s/%s...", namespace, rbac.RoleBinding.Name)
+	err := client.RbacV1().RoleBindings(namespace).Delete(ctx, rbac.RoleBinding.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		errs = append(errs, fmt.Errorf("failed to delete RoleBinding %s: %w", rbac.RoleBinding.Name, err))
+	}
+
+	logger.KubeLogger.Info("Deleting Role %s/%s...", namespace, rbac.Role.Name)
+	err = client.RbacV1().Roles(namespace).Delete(ctx, rbac.Role.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		errs = append(errs, fmt.Errorf("failed to delete Role %s: %w", rbac.Role.Name, err))
+	}
+
+	logger.KubeLogger.Info("Deleting ServiceAccount %s/%s...", namespace, rbac.ServiceAccount.Name)
+	err = client.CoreV1().ServiceAccounts(namespace).Delete(ctx, rbac.ServiceAccount.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		errs = append(errs, fmt.Errorf("failed to delete ServiceAccount %s: %w", rbac.ServiceAccount.Name, err))
+	}
+
+	return errors.Join(errs...)
+}
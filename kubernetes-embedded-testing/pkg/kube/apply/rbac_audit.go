@@ -0,0 +1,29 @@
+package apply
+
+import (
+	"context"
+	"fmt"
+
+	"testrunner/pkg/kube/generate"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RBACAuditRequests returns the API requests the pod's test container made through the auditing proxy,
+// read from the proxy sidecar's log
+func RBACAuditRequests(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod) ([]generate.AuditedRequest, error) {
+	stream, err := client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: generate.RBACAuditContainerName,
+	}).Stream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RBAC audit log of pod %s: %w", pod.Name, err)
+	}
+	defer stream.Close()
+
+	requests, err := generate.ParseRBACAuditLog(stream)
+	if err != nil {
+		return nil, fmt.Errorf("pod %s: %w", pod.Name, err)
+	}
+	return requests, nil
+}
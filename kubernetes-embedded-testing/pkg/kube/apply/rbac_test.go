@@ -0,0 +1,144 @@
+package apply
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"testrunner/pkg/kube/generate"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// clusterWideRules are additional rules marked as cluster-wide
+var clusterWideRules = generate.RBACRules{ClusterRules: []rbacv1.PolicyRule{
+	{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"get", "list"}},
+}}
+
+// createRunRBAC creates the RBAC of a run with cluster-wide rules in the given test namespace
+func createRunRBAC(client *fake.Clientset, namespace string) error {
+	return RBAC(context.Background(), client, generate.RBAC(generate.RunMetadata{Namespace: namespace}, clusterWideRules))
+}
+
+func TestRBAC_NamespacedByDefault(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+
+	require.NoError(t, RBAC(ctx, client, generate.RBAC(generate.RunMetadata{Namespace: "run-a"}, generate.RBACRules{})))
+
+	_, err := client.CoreV1().ServiceAccounts("run-a").Get(ctx, generate.TestRunnerServiceAccountName, metav1.GetOptions{})
+	require.NoError(t, err)
+	binding, err := client.RbacV1().RoleBindings("run-a").Get(ctx, generate.TestRunnerRoleName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, generate.TestRunnerServiceAccountName, binding.Subjects[0].Name)
+	_, err = client.RbacV1().Roles("run-a").Get(ctx, generate.TestRunnerRoleName, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	roles, err := client.RbacV1().ClusterRoles().List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, roles.Items, "nothing is granted cluster-wide unless asked for")
+	bindings, err := client.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, bindings.Items)
+
+	require.NoError(t, RBAC(ctx, client, generate.RBAC(generate.RunMetadata{Namespace: "run-a"}, generate.RBACRules{})),
+		"a kept namespace may still hold the objects of an earlier run")
+}
+
+func TestRBAC_CreatesPerRunObjects(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	require.NoError(t, createRunRBAC(client, "run-a"))
+	require.NoError(t, createRunRBAC(client, "run-b"),
+		"a second run on the same cluster must not collide with the first")
+
+	roles, err := client.RbacV1().ClusterRoles().List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, roles.Items, 2)
+
+	binding, err := client.RbacV1().ClusterRoleBindings().Get(context.Background(), generate.TestRunnerRBACName("run-a"), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "run-a", binding.Subjects[0].Namespace)
+	assert.Equal(t, generate.TestRunnerServiceAccountName, binding.Subjects[0].Name)
+}
+
+func TestDeleteRBAC_OnlyRemovesOwnRun(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	require.NoError(t, createRunRBAC(client, "run-a"))
+	require.NoError(t, createRunRBAC(client, "run-b"))
+
+	require.NoError(t, DeleteRBAC(context.Background(), client, "run-a"))
+
+	roles, err := client.RbacV1().ClusterRoles().List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, roles.Items, 1)
+	assert.Equal(t, generate.TestRunnerRBACName("run-b"), roles.Items[0].Name)
+
+	bindings, err := client.RbacV1().ClusterRoleBindings().List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, bindings.Items, 1)
+	assert.Equal(t, generate.TestRunnerRBACName("run-b"), bindings.Items[0].Name)
+}
+
+func TestNamespace_CreateAndDelete(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	created, err := Namespace(context.Background(), client, generate.Namespace(generate.RunMetadata{Namespace: "test-namespace"}))
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	ns, err := client.CoreV1().Namespaces().Get(context.Background(), "test-namespace", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, generate.ManagedByValue, ns.Labels[generate.ManagedByLabel])
+
+	created, err = Namespace(context.Background(), client, generate.Namespace(generate.RunMetadata{Namespace: "test-namespace"}))
+	require.NoError(t, err)
+	assert.False(t, created, "an existing namespace is not the run's own")
+
+	require.NoError(t, DeleteNamespace(context.Background(), client, "test-namespace"))
+	require.NoError(t, DeleteNamespace(context.Background(), client, "test-namespace"), "deleting a missing namespace is not an error")
+}
+
+func TestDeleteNamespacedRBAC(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+	rbac := generate.RBAC(generate.RunMetadata{Namespace: "run-a"}, generate.RBACRules{})
+	require.NoError(t, RBAC(ctx, client, rbac))
+
+	require.NoError(t, DeleteNamespacedRBAC(ctx, client, rbac))
+	_, err := client.CoreV1().ServiceAccounts("run-a").Get(ctx, generate.TestRunnerServiceAccountName, metav1.GetOptions{})
+	assert.Error(t, err)
+	_, err = client.RbacV1().Roles("run-a").Get(ctx, generate.TestRunnerRoleName, metav1.GetOptions{})
+	assert.Error(t, err)
+	_, err = client.RbacV1().RoleBindings("run-a").Get(ctx, generate.TestRunnerRoleName, metav1.GetOptions{})
+	assert.Error(t, err)
+
+	require.NoError(t, DeleteNamespacedRBAC(ctx, client, rbac), "deleting missing objects is not an error")
+}
+
+func TestWaitForNamespaceDeletion_WaitsForTermination(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "terminating"}})
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		client.CoreV1().Namespaces().Delete(context.Background(), "terminating", metav1.DeleteOptions{})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, WaitForNamespaceDeletion(ctx, client, "terminating"))
+}
+
+func TestWaitForNamespaceDeletion_TimesOut(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "stuck"}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := WaitForNamespaceDeletion(ctx, client, "stuck")
+	assert.ErrorContains(t, err, "namespace stuck did not finish terminating")
+}
@@ -0,0 +1,59 @@
+package apply
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"testrunner/pkg/archive"
+	"testrunner/pkg/kube/generate"
+	"testrunner/pkg/logger"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// CollectReports copies the contents of /reports out of the pod into destDir and then releases the
+// reports collector container so the pod can complete
+func CollectReports(ctx context.Context, client kubernetes.Interface, restConfig *rest.Config, pod *corev1.Pod, destDir string) error {
+	logger.KubeLogger.Info("Collecting reports from pod %s into %s", pod.Name, destDir)
+
+	reader, writer := io.Pipe()
+	extracted := make(chan error, 1)
+	go func() {
+		files, err := archive.Extract(reader, destDir)
+		if err == nil {
+			logger.KubeLogger.Info("Collected %d report file(s)", files)
+		}
+		// Drain the stream so the exec does not block if extraction stopped early
+		io.Copy(io.Discard, reader)
+		extracted <- err
+	}()
+
+	tarCommand := []string{"tar", "cf", "-", "-C", generate.ReportsMountPath, "."}
+	execErr := ExecInContainer(ctx, client, restConfig, pod, generate.ReportsCollectorContainerName, tarCommand, nil, writer)
+	writer.CloseWithError(execErr)
+	extractErr := <-extracted
+
+	if err := releaseReportsCollector(ctx, client, restConfig, pod); err != nil {
+		logger.KubeLogger.Warn("%v; the collector exits on its own %ds after the test command", err, generate.ReportsCollectionGraceSeconds)
+	}
+
+	if execErr != nil {
+		return fmt.Errorf("failed to read reports from pod %s: %w", pod.Name, execErr)
+	}
+	if extractErr != nil {
+		return fmt.Errorf("failed to extract reports into %s: %w", destDir, extractErr)
+	}
+	return nil
+}
+
+// releaseReportsCollector marks the collection as done so the reports collector container exits
+func releaseReportsCollector(ctx context.Context, client kubernetes.Interface, restConfig *rest.Config, pod *corev1.Pod) error {
+	touchCommand := []string{"touch", generate.ReportsCollectedMarker}
+	if err := ExecInContainer(ctx, client, restConfig, pod, generate.ReportsCollectorContainerName, touchCommand, nil, nil); err != nil {
+		return fmt.Errorf("failed to release reports collector in pod %s: %w", pod.Name, err)
+	}
+	return nil
+}
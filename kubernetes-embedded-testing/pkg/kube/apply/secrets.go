@@ -0,0 +1,49 @@
+package apply
+
+import (
+	"context"
+	"fmt"
+
+	"testrunner/pkg/logger"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Secrets creates the configured Secrets in the run's test namespace, updating those a kept namespace
+// still holds from an earlier run. It returns the Secrets it created, also when it fails part way, so
+// they can be deleted from a namespace the run did not create.
+func Secrets(ctx context.Context, client kubernetes.Interface, secrets []*corev1.Secret) ([]*corev1.Secret, error) {
+	var created []*corev1.Secret
+	for _, secret := range secrets {
+		logger.KubeLogger.Debug("Creating secret %s with %d keys", secret.Name, len(secret.Data))
+		client := client.CoreV1().Secrets(secret.Namespace)
+		_, err := client.Create(ctx, secret, metav1.CreateOptions{})
+		if err == nil {
+			created = append(created, secret)
+			continue
+		}
+		if apierrors.IsAlreadyExists(err) {
+			_, err = client.Update(ctx, secret, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return created, fmt.Errorf("failed to create secret %s: %w", secret.Name, err)
+		}
+	}
+	return created, nil
+}
+
+// DeleteSecrets deletes the given Secrets. It returns one error per Secret that could not be deleted.
+func DeleteSecrets(ctx context.Context, client kubernetes.Interface, secrets []*corev1.Secret) []error {
+	var failures []error
+	for _, secret := range secrets {
+		logger.KubeLogger.Info("Deleting secret %s/%s...", secret.Namespace, secret.Name)
+		err := client.CoreV1().Secrets(secret.Namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			failures = append(failures, fmt.Errorf("secret %s/%s: %w", secret.Namespace, secret.Name, err))
+		}
+	}
+	return failures
+}
@@ -0,0 +1,66 @@
+package apply
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"testrunner/pkg/kube/generate"
+	"testrunner/pkg/logger"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// WaitForServices waits until every service sidecar of the job's pod reports ready, ignoring the pods
+// of earlier attempts in followed. It returns straight away for jobs without services, and fails if the
+// pod finishes before they are ready or cannot start, as for a service image that cannot be pulled.
+func WaitForServices(ctx context.Context, client kubernetes.Interface, job *batchv1.Job, startupTimeout time.Duration, followed map[string]bool) error {
+	services := serviceNames(job)
+	if len(services) == 0 {
+		return nil
+	}
+	logger.KubeLogger.Info("Waiting for services to become ready: %s", strings.Join(services, ", "))
+
+	_, err := waitForPodStart(ctx, client, job, startupTimeout, func(pod *corev1.Pod) (bool, error) {
+		if followed[pod.Name] {
+			return false, nil
+		}
+		if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+			return false, fmt.Errorf("pod %s finished before its services were ready", pod.Name)
+		}
+		ready := map[string]bool{}
+		for _, status := range pod.Status.InitContainerStatuses {
+			ready[status.Name] = status.Ready
+		}
+		for _, service := range services {
+			if !ready[service] {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("services did not become ready: %w", err)
+	}
+
+	logger.KubeLogger.Info("Services ready")
+	return nil
+}
+
+// serviceNames returns the names of the job's service sidecars, the init containers that keep running
+// other than ket's own
+func serviceNames(job *batchv1.Job) []string {
+	var names []string
+	for _, container := range job.Spec.Template.Spec.InitContainers {
+		if container.Name == generate.RBACAuditContainerName {
+			continue
+		}
+		if container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+			names = append(names, container.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,82 @@
+package apply
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"testrunner/pkg/kube/generate"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// serviceJob returns a job running a postgres service sidecar next to a regular init container and
+// ket's RBAC audit sidecar
+func serviceJob() *batchv1.Job {
+	job := testJob(batchv1.JobStatus{Active: 1})
+	restartPolicy := corev1.ContainerRestartPolicyAlways
+	job.Spec.Template.Spec.InitContainers = []corev1.Container{
+		{Name: "ket-source-git"},
+		{Name: "postgres", RestartPolicy: &restartPolicy},
+		{Name: generate.RBACAuditContainerName, RestartPolicy: &restartPolicy},
+	}
+	return job
+}
+
+func TestWaitForServices_WaitsForReadiness(t *testing.T) {
+	job := serviceJob()
+	pod := testPod("ket-app-abc", corev1.ContainerState{})
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{Name: "postgres"}}
+	client := fake.NewSimpleClientset(job, pod)
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			time.Sleep(20 * time.Millisecond)
+			updated := pod.DeepCopy()
+			updated.Status.InitContainerStatuses[0].Ready = true
+			updated.Annotations = map[string]string{"tick": time.Now().String()}
+			if _, err := client.CoreV1().Pods(pod.Namespace).UpdateStatus(context.Background(), updated, metav1.UpdateOptions{}); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, WaitForServices(ctx, client, job, 0, nil))
+}
+
+func TestWaitForServices_PodFinishedFirst(t *testing.T) {
+	job := serviceJob()
+	pod := testPod("ket-app-abc", terminated(1))
+	pod.Status.Phase = corev1.PodFailed
+	client := fake.NewSimpleClientset(job, pod)
+
+	err := WaitForServices(context.Background(), client, job, 0, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "finished before its services were ready")
+}
+
+func TestWaitForServices_IgnoresEarlierAttempts(t *testing.T) {
+	job := serviceJob()
+	first := testPod("ket-app-aaaaa", terminated(1))
+	first.Status.Phase = corev1.PodFailed
+	second := testPod("ket-app-bbbbb", corev1.ContainerState{})
+	second.Status.InitContainerStatuses = []corev1.ContainerStatus{{Name: "postgres", Ready: true}}
+	client := fake.NewSimpleClientset(job, first, second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, WaitForServices(ctx, client, job, 0, map[string]bool{"ket-app-aaaaa": true}))
+}
+
+func TestWaitForServices_NoServices(t *testing.T) {
+	assert.Empty(t, serviceNames(testJob(batchv1.JobStatus{})))
+	assert.Equal(t, []string{"postgres"}, serviceNames(serviceJob()))
+	require.NoError(t, WaitForServices(context.Background(), fake.NewSimpleClientset(), testJob(batchv1.JobStatus{}), 0, nil))
+}
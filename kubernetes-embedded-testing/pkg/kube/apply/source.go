@@ -0,0 +1,60 @@
+package apply
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"testrunner/pkg/archive"
+	"testrunner/pkg/kube/generate"
+	"testrunner/pkg/logger"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// UploadSource copies localPath into the source volume of the job's pod through the upload init container,
+// then signals the init container so the test container can start. The wait for the upload container
+// is bounded by startupTimeout like the wait for the test container.
+func UploadSource(ctx context.Context, client kubernetes.Interface, restConfig *rest.Config, job *batchv1.Job, localPath string, exclude []string, startupTimeout time.Duration) error {
+	logger.KubeLogger.Info("Waiting for source upload container to start...")
+	pod, err := waitForUploadContainer(ctx, client, job, startupTimeout)
+	if err != nil {
+		return err
+	}
+
+	logger.KubeLogger.Info("Uploading %s to pod %s", localPath, pod.Name)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(archive.Create(writer, localPath, exclude))
+	}()
+
+	untarCommand := []string{"tar", "xf", "-", "-C", generate.SourceMountPath}
+	if err := ExecInContainer(ctx, client, restConfig, pod, generate.SourceUploadContainerName, untarCommand, reader, nil); err != nil {
+		reader.CloseWithError(err)
+		return fmt.Errorf("failed to upload source to pod %s: %w", pod.Name, err)
+	}
+
+	touchCommand := []string{"touch", generate.SourceUploadedMarker}
+	if err := ExecInContainer(ctx, client, restConfig, pod, generate.SourceUploadContainerName, touchCommand, nil, nil); err != nil {
+		return fmt.Errorf("failed to signal source upload completion to pod %s: %w", pod.Name, err)
+	}
+
+	logger.KubeLogger.Info("Source upload complete")
+	return nil
+}
+
+// waitForUploadContainer waits until the upload init container of the job's pod is running
+func waitForUploadContainer(ctx context.Context, client kubernetes.Interface, job *batchv1.Job, startupTimeout time.Duration) (*corev1.Pod, error) {
+	return waitForPodStart(ctx, client, job, startupTimeout, func(pod *corev1.Pod) (bool, error) {
+		for _, status := range pod.Status.InitContainerStatuses {
+			if status.Name == generate.SourceUploadContainerName && status.State.Running != nil {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
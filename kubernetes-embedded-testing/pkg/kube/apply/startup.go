@@ -0,0 +1,332 @@
+package apply
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"testrunner/pkg/logger"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// DefaultStartupTimeout bounds the wait for a test pod to start when no startup timeout is configured
+const DefaultStartupTimeout = 120 * time.Second
+
+var (
+	// startupCheckInterval is how often the startup timeout and grace period are checked
+	startupCheckInterval = time.Second
+	// startupGracePeriod is how long a pod may stay unschedulable, or the job fail to create it, before
+	// the wait gives up, which leaves the cluster autoscaler or a finishing run time to free capacity
+	startupGracePeriod = 30 * time.Second
+)
+
+// staleEventMargin is how long before the start of a wait an event may have last occurred and still
+// count, since event timestamps have second precision and come from other components' clocks
+const staleEventMargin = 2 * time.Second
+
+// startupFailureReasons are the waiting reasons of containers that will not start without intervention
+var startupFailureReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"ErrImageNeverPull":          true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// waitForPodStart watches the job's pods until condition returns true for one of them, like
+// watchJobPods, but fails fast when the pod cannot start: a container stuck on its image or
+// configuration, a pod no node can take, or a job that cannot create its pod at all, as when a quota
+// is exceeded. It also gives up once the pod has made no progress for timeout; pulling an image or
+// waiting for the cluster autoscaler to add a node counts as progress.
+func waitForPodStart(ctx context.Context, client kubernetes.Interface, job *batchv1.Job, timeout time.Duration, condition func(*corev1.Pod) (bool, error)) (*corev1.Pod, error) {
+	if timeout <= 0 {
+		timeout = DefaultStartupTimeout
+	}
+
+	monitor := &startupMonitor{
+		job:         job,
+		timeout:     timeout,
+		interval:    startupCheckInterval,
+		gracePeriod: startupGracePeriod,
+		since:       time.Now().Add(-staleEventMargin),
+		images:      map[string]imageEvent{},
+	}
+
+	waitCtx, cancel := context.WithCancelCause(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel(nil)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		monitor.watchEvents(waitCtx, client)
+	}()
+	go func() {
+		defer wg.Done()
+		monitor.run(waitCtx, cancel)
+	}()
+
+	pod, err := watchJobPods(waitCtx, client, job, func(pod *corev1.Pod) (bool, error) {
+		done, err := condition(pod)
+		if done || err != nil {
+			return done, err
+		}
+		return false, monitor.observePod(pod)
+	})
+	if err != nil && ctx.Err() == nil && waitCtx.Err() != nil {
+		return nil, context.Cause(waitCtx)
+	}
+	return pod, err
+}
+
+// imageEvent is the latest image event of a pod for one image
+type imageEvent struct {
+	reason string
+	time   time.Time
+}
+
+// startupMonitor follows the pod and events of a starting job to tell a slow start from a stuck one
+type startupMonitor struct {
+	job         *batchv1.Job
+	timeout     time.Duration
+	interval    time.Duration
+	gracePeriod time.Duration
+	// since is when the wait started; events that last occurred before are about earlier pods
+	since time.Time
+
+	mu sync.Mutex
+	// images holds the latest Pulling or Pulled event of each image, to tell which are being pulled
+	images map[string]imageEvent
+	// scalingUp is set once the cluster autoscaler started adding a node for the unschedulable pod
+	scalingUp bool
+	// unschedulable is why the pod cannot be scheduled, since unschedulableSince, or "" if it can
+	unschedulable      string
+	unschedulableSince time.Time
+	// noScaleUp is why the cluster autoscaler will not add a node for the unschedulable pod, or ""
+	noScaleUp string
+	// createFailure is why the job cannot create its pod, since createFailureSince, or "" if no
+	// pod creation failed since the last pod of the job appeared
+	createFailure      string
+	createFailureSince time.Time
+}
+
+// observePod records the scheduling state of the pod, and returns an error if one of its containers
+// will not start
+func (m *startupMonitor) observePod(pod *corev1.Pod) error {
+	if reason := waitingReason(*pod, startupFailureReasons); reason != "" {
+		return fmt.Errorf("pod %s cannot start: %s", pod.Name, reason)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if pod.CreationTimestamp.IsZero() || !pod.CreationTimestamp.Time.Before(m.since) {
+		m.createFailure = ""
+	}
+	if reason := unschedulableReason(*pod); reason != "" {
+		m.markUnschedulable(pod.Name, reason)
+	} else if pod.Spec.NodeName != "" || podScheduled(*pod) {
+		m.unschedulable = ""
+		m.scalingUp = false
+		m.noScaleUp = ""
+	}
+	return nil
+}
+
+// podScheduled reports whether the pod was bound to a node
+func podScheduled(pod corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// markUnschedulable records why the pod cannot be scheduled. Claims that are still being bound are
+// not a reason to give up on the pod. The caller holds mu.
+func (m *startupMonitor) markUnschedulable(podName, reason string) {
+	if strings.Contains(reason, "unbound immediate PersistentVolumeClaims") {
+		return
+	}
+	if m.unschedulable == "" {
+		m.unschedulableSince = time.Now()
+	}
+	m.unschedulable = fmt.Sprintf("pod %s cannot be scheduled: %s", podName, reason)
+}
+
+// observeEvent records what an event of the job or one of its pods says about the start of the pod.
+// Events that last occurred before the wait started are about earlier pods and ignored.
+func (m *startupMonitor) observeEvent(event *corev1.Event) {
+	object := event.InvolvedObject
+	if at := eventTime(event); !at.IsZero() && at.Before(m.since) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if object.Kind == "Job" && object.Name == m.job.Name {
+		if event.Reason == "FailedCreate" {
+			if m.createFailure == "" {
+				m.createFailureSince = time.Now()
+			}
+			m.createFailure = fmt.Sprintf("job %s cannot create its pod: %s", m.job.Name, event.Message)
+		}
+		return
+	}
+	if object.Kind != "Pod" || !m.ownsPod(object.Name) {
+		return
+	}
+
+	switch event.Reason {
+	case "FailedScheduling":
+		m.markUnschedulable(object.Name, event.Message)
+	case "TriggeredScaleUp":
+		m.scalingUp = true
+		m.noScaleUp = ""
+	case "NotTriggerScaleUp":
+		m.scalingUp = false
+		m.noScaleUp = fmt.Sprintf("pod %s cannot be scheduled and the cluster autoscaler will not add a node for it: %s", object.Name, event.Message)
+	case "Pulling", "Pulled":
+		image := quotedImage(event.Message)
+		if image == "" {
+			return
+		}
+		at := eventTime(event)
+		if latest, ok := m.images[image]; !ok || !at.Before(latest.time) {
+			m.images[image] = imageEvent{reason: event.Reason, time: at}
+		}
+	}
+}
+
+// ownsPod reports whether a pod name is one the job controller generates for the job, so events of
+// pods that are gone by the time the event is seen still count
+func (m *startupMonitor) ownsPod(name string) bool {
+	suffix, ok := strings.CutPrefix(name, m.job.Name+"-")
+	return ok && suffix != "" && !strings.Contains(suffix, "-")
+}
+
+// progressing reports whether the pod is pulling an image or waiting for a node being added. The
+// caller holds mu.
+func (m *startupMonitor) progressing() bool {
+	if m.scalingUp && m.unschedulable != "" {
+		return true
+	}
+	for _, image := range m.images {
+		if image.reason == "Pulling" {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an error once the pod is known not to start, or has made no progress since deadline.
+// A job that cannot create its pod gets the grace period as well, since a quota may free up.
+func (m *startupMonitor) check(now, deadline time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.createFailure != "" && now.Sub(m.createFailureSince) >= m.gracePeriod {
+		return errors.New(m.createFailure)
+	}
+	if m.unschedulable != "" && m.noScaleUp != "" {
+		return errors.New(m.noScaleUp)
+	}
+	if m.unschedulable != "" && !m.scalingUp && now.Sub(m.unschedulableSince) >= m.gracePeriod {
+		return errors.New(m.unschedulable)
+	}
+	if now.After(deadline) {
+		return fmt.Errorf("Test Runner Job %s did not start: no progress for %s", m.job.Name, m.timeout)
+	}
+	return nil
+}
+
+// run cancels the wait with the reason once the pod is known not to start. The deadline moves on for
+// as long as the pod makes progress, but never past the job's own active deadline.
+func (m *startupMonitor) run(ctx context.Context, cancel context.CancelCauseFunc) {
+	start := time.Now()
+	deadline := start.Add(m.timeout)
+	var hardDeadline time.Time
+	if m.job.Spec.ActiveDeadlineSeconds != nil {
+		hardDeadline = start.Add(time.Duration(*m.job.Spec.ActiveDeadlineSeconds) * time.Second)
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			progressing := m.progressing()
+			m.mu.Unlock()
+			if progressing && (hardDeadline.IsZero() || now.Before(hardDeadline)) {
+				deadline = now.Add(m.timeout)
+			}
+			if err := m.check(now, deadline); err != nil {
+				cancel(err)
+				return
+			}
+		}
+	}
+}
+
+// watchEvents feeds the events of the job's namespace to the monitor until ctx is done. Events are
+// only a hint, so a failure to watch them is logged rather than failing the wait.
+func (m *startupMonitor) watchEvents(ctx context.Context, client kubernetes.Interface) {
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().Events(m.job.Namespace).List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.CoreV1().Events(m.job.Namespace).Watch(ctx, options)
+		},
+	}
+
+	_, err := watchtools.UntilWithSync(ctx, lw, &corev1.Event{}, nil, func(event watch.Event) (bool, error) {
+		if current, ok := event.Object.(*corev1.Event); ok && event.Type != watch.Deleted {
+			m.observeEvent(current)
+		}
+		return false, nil
+	})
+	if err != nil && ctx.Err() == nil {
+		logger.KubeLogger.Debug("Stopped watching events of job %s: %v", m.job.Name, err)
+	}
+}
+
+// quotedImage returns the image named in an image event message, such as `Pulling image "golang:1.24"`
+func quotedImage(message string) string {
+	_, rest, ok := strings.Cut(message, `"`)
+	if !ok {
+		return ""
+	}
+	image, _, ok := strings.Cut(rest, `"`)
+	if !ok {
+		return ""
+	}
+	return image
+}
+
+// eventTime returns when the event last occurred
+func eventTime(event *corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
@@ -0,0 +1,182 @@
+package apply
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fastStartupChecks shortens the startup checks for the duration of the test
+func fastStartupChecks(t *testing.T) {
+	interval, grace := startupCheckInterval, startupGracePeriod
+	startupCheckInterval, startupGracePeriod = 10*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() {
+		startupCheckInterval, startupGracePeriod = interval, grace
+	})
+}
+
+func testEvent(name, kind, object, reason, message string) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "test-namespace"},
+		InvolvedObject: corev1.ObjectReference{Kind: kind, Name: object, Namespace: "test-namespace"},
+		Reason:         reason,
+		Message:        message,
+		LastTimestamp:  metav1.Now(),
+	}
+}
+
+func unschedulablePod() *corev1.Pod {
+	pod := testPod("ket-app-abc", corev1.ContainerState{})
+	pod.Status.ContainerStatuses = nil
+	pod.Status.Conditions = []corev1.PodCondition{{
+		Type:    corev1.PodScheduled,
+		Status:  corev1.ConditionFalse,
+		Reason:  corev1.PodReasonUnschedulable,
+		Message: "0/3 nodes are available: 3 Insufficient memory.",
+	}}
+	return pod
+}
+
+func TestStreamTestOutputToHost_FailsFastOnStuckContainer(t *testing.T) {
+	for _, reason := range []string{"ImagePullBackOff", "ErrImagePull", "CreateContainerConfigError"} {
+		t.Run(reason, func(t *testing.T) {
+			job := testJob(batchv1.JobStatus{Active: 1})
+			client := fake.NewSimpleClientset(job, testPod("ket-app-abc", waiting(reason)))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := StreamTestOutputToHost(ctx, client, job, time.Minute, AttemptHooks{})
+			require.Error(t, err)
+			assert.Equal(t, "pod ket-app-abc cannot start: test-runner: "+reason+": details", err.Error())
+		})
+	}
+}
+
+func TestWaitForPodStart_FailedCreate(t *testing.T) {
+	fastStartupChecks(t)
+	job := testJob(batchv1.JobStatus{})
+	event := testEvent("ket-app.1", "Job", "ket-app", "FailedCreate", `Error creating: pods "ket-app-abc" is forbidden: exceeded quota: compute`)
+	client := fake.NewSimpleClientset(job, event)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := waitForPodStart(ctx, client, job, time.Minute, func(*corev1.Pod) (bool, error) { return true, nil })
+	require.Error(t, err)
+	assert.Equal(t, `job ket-app cannot create its pod: Error creating: pods "ket-app-abc" is forbidden: exceeded quota: compute`, err.Error())
+}
+
+func TestWaitForPodStart_IgnoresStaleFailedCreate(t *testing.T) {
+	fastStartupChecks(t)
+	job := testJob(batchv1.JobStatus{Active: 1})
+	event := testEvent("ket-app.1", "Job", "ket-app", "FailedCreate", `Error creating: pods "ket-app-abc" is forbidden: exceeded quota: compute`)
+	event.LastTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	client := fake.NewSimpleClientset(job, event)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err := waitForPodStart(ctx, client, job, time.Minute, func(*corev1.Pod) (bool, error) { return false, nil })
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "an event of an earlier attempt says nothing about this one")
+}
+
+func TestStartupMonitor_PodClearsFailedCreate(t *testing.T) {
+	monitor := &startupMonitor{job: testJob(batchv1.JobStatus{}), gracePeriod: time.Second, images: map[string]imageEvent{}}
+	monitor.observeEvent(testEvent("ket-app.1", "Job", "ket-app", "FailedCreate", "exceeded quota: compute"))
+	assert.Error(t, monitor.check(time.Now().Add(time.Minute), time.Now().Add(time.Hour)))
+
+	require.NoError(t, monitor.observePod(testPod("ket-app-abc", waiting("ContainerCreating"))))
+	assert.NoError(t, monitor.check(time.Now().Add(time.Minute), time.Now().Add(time.Hour)),
+		"the quota freed up once a pod was created")
+}
+
+func TestWaitForPodStart_Unschedulable(t *testing.T) {
+	fastStartupChecks(t)
+	job := testJob(batchv1.JobStatus{Active: 1})
+	client := fake.NewSimpleClientset(job, unschedulablePod())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := waitForPodStart(ctx, client, job, time.Minute, func(*corev1.Pod) (bool, error) { return false, nil })
+	require.Error(t, err)
+	assert.Equal(t, "pod ket-app-abc cannot be scheduled: Unschedulable: 0/3 nodes are available: 3 Insufficient memory.", err.Error())
+
+	result, err := ClassifyFailure(context.Background(), client, job, err)
+	require.NoError(t, err)
+	assert.Equal(t, "scheduling", string(result.Category))
+}
+
+func TestWaitForPodStart_WaitsForScaleUp(t *testing.T) {
+	fastStartupChecks(t)
+	job := testJob(batchv1.JobStatus{Active: 1})
+	event := testEvent("ket-app-abc.1", "Pod", "ket-app-abc", "TriggeredScaleUp", "pod triggered scale-up: [{pool-1 1->2 (max: 5)}]")
+	client := fake.NewSimpleClientset(job, unschedulablePod(), event)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err := waitForPodStart(ctx, client, job, 100*time.Millisecond, func(*corev1.Pod) (bool, error) { return false, nil })
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "a pod waiting for a new node is neither unschedulable nor stuck")
+}
+
+func TestWaitForPodStart_NotTriggerScaleUp(t *testing.T) {
+	fastStartupChecks(t)
+	job := testJob(batchv1.JobStatus{Active: 1})
+	event := testEvent("ket-app-abc.1", "Pod", "ket-app-abc", "NotTriggerScaleUp", "pod didn't trigger scale-up: 1 max node group size reached")
+	client := fake.NewSimpleClientset(job, unschedulablePod(), event)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := waitForPodStart(ctx, client, job, time.Minute, func(*corev1.Pod) (bool, error) { return false, nil })
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "the cluster autoscaler will not add a node for it: pod didn't trigger scale-up")
+}
+
+func TestWaitForPodStart_Timeout(t *testing.T) {
+	fastStartupChecks(t)
+	job := testJob(batchv1.JobStatus{Active: 1})
+	client := fake.NewSimpleClientset(job, testPod("ket-app-abc", waiting("ContainerCreating")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := waitForPodStart(ctx, client, job, 100*time.Millisecond, func(*corev1.Pod) (bool, error) { return false, nil })
+	require.Error(t, err)
+	assert.Equal(t, "Test Runner Job ket-app did not start: no progress for 100ms", err.Error())
+}
+
+func TestWaitForPodStart_ImagePullExtendsTimeout(t *testing.T) {
+	fastStartupChecks(t)
+	job := testJob(batchv1.JobStatus{Active: 1})
+	event := testEvent("ket-app-abc.1", "Pod", "ket-app-abc", "Pulling", `Pulling image "golang:1.24"`)
+	client := fake.NewSimpleClientset(job, testPod("ket-app-abc", waiting("ContainerCreating")), event)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err := waitForPodStart(ctx, client, job, 100*time.Millisecond, func(*corev1.Pod) (bool, error) { return false, nil })
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "pulling an image counts as progress")
+}
+
+func TestStartupMonitor_ObserveEvent(t *testing.T) {
+	monitor := &startupMonitor{job: testJob(batchv1.JobStatus{}), images: map[string]imageEvent{}}
+	pulling := testEvent("a", "Pod", "ket-app-abc", "Pulling", `Pulling image "golang:1.24"`)
+	pulled := testEvent("b", "Pod", "ket-app-abc", "Pulled", `Successfully pulled image "golang:1.24" in 12s`)
+	pulled.LastTimestamp = metav1.NewTime(pulling.LastTimestamp.Add(time.Second))
+
+	// Events listed out of order still leave the image pulled
+	monitor.observeEvent(pulled)
+	monitor.observeEvent(pulling)
+	assert.False(t, monitor.progressing())
+
+	// Events of other jobs' pods are ignored
+	other := testEvent("c", "Pod", "ket-app-shard-1-abcde", "Pulling", `Pulling image "postgres:16"`)
+	monitor.observeEvent(other)
+	assert.False(t, monitor.progressing())
+
+	assert.True(t, monitor.ownsPod("ket-app-x7k2p"))
+	assert.False(t, monitor.ownsPod("ket-app-shard-1-x7k2p"))
+	assert.False(t, monitor.ownsPod("ket-app"))
+}
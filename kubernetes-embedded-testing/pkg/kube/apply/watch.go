@@ -0,0 +1,151 @@
+package apply
+
+import (
+	"context"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// jobPodSelector returns the label selector matching the pods created for a job
+func jobPodSelector(job *batchv1.Job) string {
+	return "job-name=" + job.Name
+}
+
+// watchJob watches the job until condition returns true, returning the job in that state.
+// The underlying informer resumes from the last seen resourceVersion and re-lists after disconnects.
+func watchJob(ctx context.Context, client kubernetes.Interface, job *batchv1.Job, condition func(*batchv1.Job) (bool, error)) (*batchv1.Job, error) {
+	lw := namespacedListWatch(ctx, job.Name,
+		func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			return client.BatchV1().Jobs(job.Namespace).List(ctx, options)
+		},
+		func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			return client.BatchV1().Jobs(job.Namespace).Watch(ctx, options)
+		},
+	)
+
+	var matched *batchv1.Job
+	_, err := watchtools.UntilWithSync(ctx, lw, &batchv1.Job{}, nil, func(event watch.Event) (bool, error) {
+		current, ok := event.Object.(*batchv1.Job)
+		if !ok || event.Type == watch.Deleted || current.Name != job.Name {
+			return false, nil
+		}
+		done, err := condition(current)
+		if done {
+			matched = current
+		}
+		return done, err
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return matched, nil
+}
+
+// watchJobPods watches the pods of the job until condition returns true for one of them, returning that pod.
+func watchJobPods(ctx context.Context, client kubernetes.Interface, job *batchv1.Job, condition func(*corev1.Pod) (bool, error)) (*corev1.Pod, error) {
+	labelSelector := jobPodSelector(job)
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = labelSelector
+			return client.CoreV1().Pods(job.Namespace).List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = labelSelector
+			return client.CoreV1().Pods(job.Namespace).Watch(ctx, options)
+		},
+	}
+
+	var matched *corev1.Pod
+	_, err := watchtools.UntilWithSync(ctx, lw, &corev1.Pod{}, nil, func(event watch.Event) (bool, error) {
+		pod, ok := event.Object.(*corev1.Pod)
+		if !ok || event.Type == watch.Deleted || pod.Labels["job-name"] != job.Name {
+			return false, nil
+		}
+		done, err := condition(pod)
+		if done {
+			matched = pod
+		}
+		return done, err
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return matched, nil
+}
+
+// namespacedListWatch returns a ListWatch restricted to the named object
+func namespacedListWatch(ctx context.Context, name string,
+	list func(context.Context, metav1.ListOptions) (runtime.Object, error),
+	watchFunc func(context.Context, metav1.ListOptions) (watch.Interface, error),
+) *cache.ListWatch {
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", name).String()
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			return list(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			return watchFunc(ctx, options)
+		},
+	}
+}
+
+// untilNamed watches the named object until condition returns true, ignoring other objects the
+// ListWatch may return
+func untilNamed(ctx context.Context, lw cache.ListerWatcher, objType runtime.Object, name string, condition func(runtime.Object) (bool, error)) error {
+	_, err := watchtools.UntilWithSync(ctx, lw, objType, nil, func(event watch.Event) (bool, error) {
+		if event.Type == watch.Deleted {
+			return false, nil
+		}
+		accessor, err := meta.Accessor(event.Object)
+		if err != nil || accessor.GetName() != name {
+			return false, nil
+		}
+		return condition(event.Object)
+	})
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// untilDeleted waits until the named object no longer exists. Objects already gone when the watch
+// starts are detected from the initial list.
+func untilDeleted(ctx context.Context, lw cache.ListerWatcher, objType runtime.Object, name string) error {
+	precondition := func(store cache.Store) (bool, error) {
+		for _, obj := range store.List() {
+			if accessor, err := meta.Accessor(obj); err == nil && accessor.GetName() == name {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	_, err := watchtools.UntilWithSync(ctx, lw, objType, precondition, func(event watch.Event) (bool, error) {
+		accessor, err := meta.Accessor(event.Object)
+		if err != nil || accessor.GetName() != name {
+			return false, nil
+		}
+		return event.Type == watch.Deleted, nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
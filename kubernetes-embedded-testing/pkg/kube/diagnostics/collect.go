@@ -0,0 +1,239 @@
+package diagnostics
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"testrunner/pkg/archive"
+	"testrunner/pkg/logger"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// Collect captures a diagnostics bundle for the test namespace: events, describe-style status of pods,
+// deployments and statefulsets, container logs including previous restarts, and job/pod YAML.
+// When dest ends in .tar.gz or .tgz the bundle is written as a tarball, otherwise into a directory.
+// Collection is best effort, so a partial bundle is written even if some steps fail.
+func Collect(ctx context.Context, client kubernetes.Interface, namespace, dest string) error {
+	if isTarball(dest) {
+		tmpDir, err := os.MkdirTemp("", "ket-diagnostics-")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary diagnostics directory: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		collectErr := collectInto(ctx, client, namespace, filepath.Join(tmpDir, namespace))
+		if err := writeTarball(tmpDir, dest); err != nil {
+			return err
+		}
+		return collectErr
+	}
+
+	return collectInto(ctx, client, namespace, filepath.Join(dest, namespace))
+}
+
+// collectInto writes the diagnostics bundle for the namespace into dir
+func collectInto(ctx context.Context, client kubernetes.Interface, namespace, dir string) error {
+	logger.KubeLogger.Info("Collecting diagnostics for namespace %s into %s", namespace, dir)
+
+	b := &bundle{dir: dir}
+
+	events, err := client.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{})
+	if b.check("list events", err) {
+		sortEvents(events.Items)
+		b.write("events.txt", formatEvents(events.Items))
+	}
+
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if b.check("list pods", err) {
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			b.write(filepath.Join("pods", pod.Name+".txt"), describePod(pod, eventsFor(events, "Pod", pod.Name)))
+			b.writeYAML(filepath.Join("pods", pod.Name+".yaml"), pod, "v1", "Pod")
+			b.collectLogs(ctx, client, pod)
+		}
+	}
+
+	jobs, err := client.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{})
+	if b.check("list jobs", err) {
+		for i := range jobs.Items {
+			b.writeYAML(filepath.Join("jobs", jobs.Items[i].Name+".yaml"), &jobs.Items[i], "batch/v1", "Job")
+		}
+	}
+
+	deployments, err := client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if b.check("list deployments", err) {
+		for i := range deployments.Items {
+			d := &deployments.Items[i]
+			b.write(filepath.Join("deployments", d.Name+".txt"), describeDeployment(d, eventsFor(events, "Deployment", d.Name)))
+		}
+	}
+
+	statefulSets, err := client.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if b.check("list statefulsets", err) {
+		for i := range statefulSets.Items {
+			s := &statefulSets.Items[i]
+			b.write(filepath.Join("statefulsets", s.Name+".txt"), describeStatefulSet(s, eventsFor(events, "StatefulSet", s.Name)))
+		}
+	}
+
+	return errors.Join(b.errs...)
+}
+
+// bundle accumulates files for a diagnostics bundle along with any errors hit while collecting them
+type bundle struct {
+	dir  string
+	errs []error
+}
+
+// check records err, returning true if the step succeeded
+func (b *bundle) check(step string, err error) bool {
+	if err != nil {
+		b.errs = append(b.errs, fmt.Errorf("failed to %s: %w", step, err))
+		return false
+	}
+	return true
+}
+
+func (b *bundle) write(name, content string) {
+	path := filepath.Join(b.dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		b.check("create "+filepath.Dir(path), err)
+		return
+	}
+	b.check("write "+name, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func (b *bundle) writeYAML(name string, obj runtime.Object, apiVersion, kind string) {
+	obj = obj.DeepCopyObject()
+	obj.GetObjectKind().SetGroupVersionKind(schema.FromAPIVersionAndKind(apiVersion, kind))
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetManagedFields(nil)
+	}
+
+	data, err := yaml.Marshal(obj)
+	if b.check("encode "+name, err) {
+		b.write(name, string(data))
+	}
+}
+
+// collectLogs writes the logs of every init and regular container of the pod, including the
+// previous instance of containers that restarted
+func (b *bundle) collectLogs(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod) {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		logDir := filepath.Join("logs", pod.Name)
+		// A waiting container is not running, so only a previous instance may have logs
+		if status.State.Waiting == nil {
+			if logs, err := containerLogs(ctx, client, pod, status.Name, false); b.check(fmt.Sprintf("get logs of %s/%s", pod.Name, status.Name), err) {
+				b.write(filepath.Join(logDir, status.Name+".log"), logs)
+			}
+		}
+		if status.RestartCount > 0 {
+			if logs, err := containerLogs(ctx, client, pod, status.Name, true); b.check(fmt.Sprintf("get previous logs of %s/%s", pod.Name, status.Name), err) {
+				b.write(filepath.Join(logDir, status.Name+".previous.log"), logs)
+			}
+		}
+	}
+}
+
+func containerLogs(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod, container string, previous bool) (string, error) {
+	stream, err := client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: container,
+		Previous:  previous,
+	}).Stream(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	data, err := io.ReadAll(stream)
+	return string(data), err
+}
+
+// sortEvents orders events from oldest to newest
+func sortEvents(events []corev1.Event) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventTime(events[i]).Before(eventTime(events[j]))
+	})
+}
+
+func eventTime(event corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	default:
+		return event.CreationTimestamp.Time
+	}
+}
+
+// eventsFor returns the events concerning the given object
+func eventsFor(events *corev1.EventList, kind, name string) []corev1.Event {
+	if events == nil {
+		return nil
+	}
+	var matched []corev1.Event
+	for _, event := range events.Items {
+		if event.InvolvedObject.Kind == kind && event.InvolvedObject.Name == name {
+			matched = append(matched, event)
+		}
+	}
+	return matched
+}
+
+func formatEvents(events []corev1.Event) string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LAST SEEN\tTYPE\tREASON\tOBJECT\tCOUNT\tMESSAGE")
+	for _, event := range events {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s/%s\t%d\t%s\n",
+			eventTime(event).Format(time.RFC3339), event.Type, event.Reason,
+			strings.ToLower(event.InvolvedObject.Kind), event.InvolvedObject.Name,
+			event.Count, strings.TrimSpace(event.Message))
+	}
+	w.Flush()
+	return sb.String()
+}
+
+func isTarball(dest string) bool {
+	return strings.HasSuffix(dest, ".tar.gz") || strings.HasSuffix(dest, ".tgz")
+}
+
+// writeTarball archives the contents of dir as a gzipped tarball at dest
+func writeTarball(dir, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", dest, err)
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create diagnostics bundle %s: %w", dest, err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	if err := archive.Create(gz, dir, nil); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write diagnostics bundle %s: %w", dest, err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package diagnostics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// describePod renders a kubectl describe-style summary of a pod's status
+func describePod(pod *corev1.Pod, events []corev1.Event) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Name:       %s\n", pod.Name)
+	fmt.Fprintf(&sb, "Namespace:  %s\n", pod.Namespace)
+	fmt.Fprintf(&sb, "Node:       %s\n", valueOrNone(pod.Spec.NodeName))
+	fmt.Fprintf(&sb, "Phase:      %s\n", pod.Status.Phase)
+	if pod.Status.Reason != "" {
+		fmt.Fprintf(&sb, "Reason:     %s\n", pod.Status.Reason)
+	}
+	if pod.Status.Message != "" {
+		fmt.Fprintf(&sb, "Message:    %s\n", pod.Status.Message)
+	}
+
+	if len(pod.Status.InitContainerStatuses) > 0 {
+		sb.WriteString("Init Containers:\n")
+		writeContainerStatuses(&sb, pod.Status.InitContainerStatuses)
+	}
+	sb.WriteString("Containers:\n")
+	writeContainerStatuses(&sb, pod.Status.ContainerStatuses)
+
+	sb.WriteString("Conditions:\n")
+	for _, c := range pod.Status.Conditions {
+		fmt.Fprintf(&sb, "  %-20s %-6s %s %s\n", c.Type, c.Status, c.Reason, c.Message)
+	}
+
+	writeEvents(&sb, events)
+	return sb.String()
+}
+
+func writeContainerStatuses(sb *strings.Builder, statuses []corev1.ContainerStatus) {
+	for _, status := range statuses {
+		fmt.Fprintf(sb, "  %s:\n", status.Name)
+		fmt.Fprintf(sb, "    Image:          %s\n", status.Image)
+		fmt.Fprintf(sb, "    State:          %s\n", describeState(status.State))
+		if status.LastTerminationState.Terminated != nil {
+			fmt.Fprintf(sb, "    Last State:     %s\n", describeState(status.LastTerminationState))
+		}
+		fmt.Fprintf(sb, "    Ready:          %t\n", status.Ready)
+		fmt.Fprintf(sb, "    Restart Count:  %d\n", status.RestartCount)
+	}
+}
+
+func describeState(state corev1.ContainerState) string {
+	switch {
+	case state.Waiting != nil:
+		return strings.TrimSpace(fmt.Sprintf("Waiting (%s) %s", state.Waiting.Reason, state.Waiting.Message))
+	case state.Running != nil:
+		return fmt.Sprintf("Running since %s", state.Running.StartedAt.Format(time.RFC3339))
+	case state.Terminated != nil:
+		return strings.TrimSpace(fmt.Sprintf("Terminated (%s, exit code %d) %s",
+			state.Terminated.Reason, state.Terminated.ExitCode, state.Terminated.Message))
+	default:
+		return "Unknown"
+	}
+}
+
+// describeDeployment renders a kubectl describe-style summary of a deployment's status
+func describeDeployment(d *appsv1.Deployment, events []corev1.Event) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Name:       %s\n", d.Name)
+	fmt.Fprintf(&sb, "Namespace:  %s\n", d.Namespace)
+	fmt.Fprintf(&sb, "Replicas:   %d desired | %d updated | %d ready | %d available | %d unavailable\n",
+		replicas(d.Spec.Replicas), d.Status.UpdatedReplicas, d.Status.ReadyReplicas,
+		d.Status.AvailableReplicas, d.Status.UnavailableReplicas)
+	sb.WriteString("Conditions:\n")
+	for _, c := range d.Status.Conditions {
+		fmt.Fprintf(&sb, "  %-20s %-6s %s %s\n", c.Type, c.Status, c.Reason, c.Message)
+	}
+	writeEvents(&sb, events)
+	return sb.String()
+}
+
+// describeStatefulSet renders a kubectl describe-style summary of a statefulset's status
+func describeStatefulSet(s *appsv1.StatefulSet, events []corev1.Event) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Name:       %s\n", s.Name)
+	fmt.Fprintf(&sb, "Namespace:  %s\n", s.Namespace)
+	fmt.Fprintf(&sb, "Replicas:   %d desired | %d current | %d ready | %d available\n",
+		replicas(s.Spec.Replicas), s.Status.CurrentReplicas, s.Status.ReadyReplicas, s.Status.AvailableReplicas)
+	sb.WriteString("Conditions:\n")
+	for _, c := range s.Status.Conditions {
+		fmt.Fprintf(&sb, "  %-20s %-6s %s %s\n", c.Type, c.Status, c.Reason, c.Message)
+	}
+	writeEvents(&sb, events)
+	return sb.String()
+}
+
+func writeEvents(sb *strings.Builder, events []corev1.Event) {
+	sb.WriteString("Events:\n")
+	if len(events) == 0 {
+		sb.WriteString("  <none>\n")
+		return
+	}
+	for _, event := range events {
+		fmt.Fprintf(sb, "  %s  %-8s %-20s %s\n",
+			eventTime(event).Format(time.RFC3339), event.Type, event.Reason, strings.TrimSpace(event.Message))
+	}
+}
+
+func replicas(count *int32) int32 {
+	if count == nil {
+		return 1
+	}
+	return *count
+}
+
+func valueOrNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
@@ -0,0 +1,112 @@
+package diagnostics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDescribePod_IncludesStatusAndEvents(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "mongo-0", Namespace: "test-namespace"},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name:         "mongo",
+					Image:        "mongo:7",
+					RestartCount: 2,
+					State: corev1.ContainerState{
+						Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+					},
+					LastTerminationState: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137},
+					},
+				},
+			},
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}},
+		},
+	}
+	events := []corev1.Event{
+		{Type: "Warning", Reason: "BackOff", Message: "Back-off restarting failed container", LastTimestamp: metav1.NewTime(time.Now())},
+	}
+
+	output := describePod(pod, events)
+
+	assert.Contains(t, output, "Name:       mongo-0")
+	assert.Contains(t, output, "Node:       <none>")
+	assert.Contains(t, output, "Waiting (CrashLoopBackOff)")
+	assert.Contains(t, output, "Terminated (OOMKilled, exit code 137)")
+	assert.Contains(t, output, "Restart Count:  2")
+	assert.Contains(t, output, "Back-off restarting failed container")
+}
+
+func TestDescribeDeployment_ShowsReplicaCounts(t *testing.T) {
+	replicas := int32(3)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "test-namespace"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{ReadyReplicas: 1, AvailableReplicas: 1, UnavailableReplicas: 2},
+	}
+
+	output := describeDeployment(deployment, nil)
+
+	assert.Contains(t, output, "3 desired")
+	assert.Contains(t, output, "2 unavailable")
+	assert.Contains(t, output, "Events:\n  <none>")
+}
+
+func TestFormatEvents_SortedOldestFirst(t *testing.T) {
+	now := time.Now()
+	events := []corev1.Event{
+		{Reason: "Second", InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "b"}, LastTimestamp: metav1.NewTime(now)},
+		{Reason: "First", InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "a"}, LastTimestamp: metav1.NewTime(now.Add(-time.Minute))},
+	}
+
+	sortEvents(events)
+	output := formatEvents(events)
+
+	assert.Less(t, strings.Index(output, "First"), strings.Index(output, "Second"))
+	assert.Contains(t, output, "pod/a")
+}
+
+func TestCollect_SkipsLogsOfWaitingContainers(t *testing.T) {
+	waiting := corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-0", Namespace: "test-namespace"},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: "clone", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}},
+			},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "pulling", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}},
+				{Name: "crashing", State: waiting, RestartCount: 3, LastTerminationState: corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{ExitCode: 1},
+				}},
+			},
+		},
+	}
+	client := fake.NewSimpleClientset(pod)
+	dest := t.TempDir()
+
+	require.NoError(t, Collect(context.Background(), client, "test-namespace", dest))
+
+	logDir := filepath.Join(dest, "test-namespace", "logs", "app-0")
+	var logs []string
+	entries, err := os.ReadDir(logDir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		logs = append(logs, entry.Name())
+	}
+	assert.Equal(t, []string{"clone.log", "crashing.previous.log"}, logs, "waiting containers only have the logs of their previous instance")
+}
@@ -0,0 +1,247 @@
+package generate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"testrunner/pkg/config"
+
+	"github.com/subosito/gotenv"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// RedactedValue replaces Secret values in rendered manifests
+	RedactedValue = "<redacted>"
+	// TestEnvSecretName is the Secret holding the custom environment variables of the test container,
+	// which keeps their values out of the Job spec
+	TestEnvSecretName = "ket-test-env"
+)
+
+// TestEnv returns the custom environment variables of the test container. Variables from env files
+// come first, then forwarded host variables, then explicit KEY=VALUE pairs, with later sources
+// overriding earlier ones. Forwarded variables that are not set on the host are skipped.
+func TestEnv(cfg config.Config) ([]corev1.EnvVar, error) {
+	var env []corev1.EnvVar
+	index := map[string]int{}
+	set := func(name, value string) {
+		if i, ok := index[name]; ok {
+			env[i].Value = value
+			return
+		}
+		index[name] = len(env)
+		env = append(env, corev1.EnvVar{Name: name, Value: value})
+	}
+
+	for _, path := range cfg.EnvFrom {
+		values, err := loadEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			set(name, values[name])
+		}
+	}
+
+	for _, name := range cfg.ForwardEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			set(name, value)
+		}
+	}
+
+	for _, pair := range cfg.Env {
+		name, value, err := parseKeyValue(pair)
+		if err != nil {
+			return nil, fmt.Errorf("invalid env %q: %w", pair, err)
+		}
+		set(name, value)
+	}
+
+	for _, variable := range env {
+		if errs := validation.IsEnvVarName(variable.Name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid environment variable name %q: %s", variable.Name, strings.Join(errs, ", "))
+		}
+	}
+	return env, nil
+}
+
+// testEnvRefs returns the custom environment variables as references into TestEnvSecretName
+func testEnvRefs(env []corev1.EnvVar) []corev1.EnvVar {
+	refs := make([]corev1.EnvVar, 0, len(env))
+	for _, variable := range env {
+		refs = append(refs, corev1.EnvVar{
+			Name: variable.Name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: TestEnvSecretName},
+					Key:                  variable.Name,
+				},
+			},
+		})
+	}
+	return refs
+}
+
+// Secrets generates the configured Secrets for the run's test namespace, reading their values from
+// literals, local files and host environment variables. The custom environment variables of the test
+// container are added as TestEnvSecretName when there are any.
+func Secrets(cfg config.Config, run RunMetadata) ([]*corev1.Secret, error) {
+	var secrets []*corev1.Secret
+	for _, secretCfg := range cfg.Secrets {
+		if errs := validation.IsDNS1123Subdomain(secretCfg.Name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid secret name %q: %s", secretCfg.Name, strings.Join(errs, ", "))
+		}
+		if secretCfg.Name == TestEnvSecretName {
+			return nil, fmt.Errorf("secret name %q is reserved for the test environment", secretCfg.Name)
+		}
+
+		data := map[string][]byte{}
+		for _, literal := range secretCfg.Literals {
+			key, value, err := parseKeyValue(literal)
+			if err != nil {
+				return nil, fmt.Errorf("invalid literal in secret %s: %w", secretCfg.Name, err)
+			}
+			data[key] = []byte(value)
+		}
+		for _, file := range secretCfg.Files {
+			key, path := filepath.Base(file), file
+			if k, p, ok := strings.Cut(file, "="); ok {
+				key, path = k, p
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read file for secret %s: %w", secretCfg.Name, err)
+			}
+			data[key] = content
+		}
+		for _, name := range secretCfg.Env {
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				return nil, fmt.Errorf("environment variable %s for secret %s is not set", name, secretCfg.Name)
+			}
+			data[name] = []byte(value)
+		}
+
+		for key := range data {
+			if errs := validation.IsConfigMapKey(key); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid key %q in secret %s: %s", key, secretCfg.Name, strings.Join(errs, ", "))
+			}
+		}
+
+		secrets = append(secrets, secret(secretCfg.Name, run, data))
+	}
+
+	env, err := TestEnv(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(env) > 0 {
+		data := make(map[string][]byte, len(env))
+		for _, variable := range env {
+			data[variable.Name] = []byte(variable.Value)
+		}
+		secrets = append(secrets, secret(TestEnvSecretName, run, data))
+	}
+	return secrets, nil
+}
+
+// secret returns an Opaque Secret of the run's test namespace
+func secret(name string, run RunMetadata, data map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   run.Namespace,
+			Labels:      run.Labels(),
+			Annotations: run.Annotations(),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+}
+
+// RedactSecret returns a copy of the secret with every value replaced by RedactedValue
+func RedactSecret(secret *corev1.Secret) *corev1.Secret {
+	redacted := secret.DeepCopy()
+	redacted.Data = nil
+	redacted.StringData = map[string]string{}
+	for key := range secret.Data {
+		redacted.StringData[key] = RedactedValue
+	}
+	return redacted
+}
+
+// secretVolumes returns the volumes, mounts and envFrom sources exposing the configured Secrets to the
+// test container. Secrets with a mount path are mounted there, and the others are injected as
+// environment variables, as are mounted Secrets with inject set.
+func secretVolumes(cfg config.Config) ([]corev1.Volume, []corev1.VolumeMount, []corev1.EnvFromSource) {
+	var (
+		volumes []corev1.Volume
+		mounts  []corev1.VolumeMount
+		envFrom []corev1.EnvFromSource
+	)
+	for _, secretCfg := range cfg.Secrets {
+		if secretCfg.MountPath != "" {
+			volumeName := "secret-" + strings.ReplaceAll(secretCfg.Name, ".", "-")
+			if len(volumeName) > validation.DNS1123LabelMaxLength {
+				volumeName = strings.TrimRight(volumeName[:validation.DNS1123LabelMaxLength], "-")
+			}
+			volumes = append(volumes, corev1.Volume{
+				Name: volumeName,
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{SecretName: secretCfg.Name},
+				},
+			})
+			mounts = append(mounts, corev1.VolumeMount{
+				Name:      volumeName,
+				MountPath: secretCfg.MountPath,
+				ReadOnly:  true,
+			})
+		}
+		if secretCfg.MountPath == "" || secretCfg.Inject {
+			envFrom = append(envFrom, corev1.EnvFromSource{
+				SecretRef: &corev1.SecretEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secretCfg.Name},
+				},
+			})
+		}
+	}
+	return volumes, mounts, envFrom
+}
+
+// loadEnvFile reads the variables of a .env file
+func loadEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open env file: %w", err)
+	}
+	defer file.Close()
+
+	values, err := gotenv.StrictParse(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse env file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// parseKeyValue splits a KEY=VALUE pair
+func parseKeyValue(pair string) (string, string, error) {
+	key, value, ok := strings.Cut(pair, "=")
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("expected KEY=VALUE")
+	}
+	return key, value, nil
+}
@@ -0,0 +1,211 @@
+package generate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// kustomizationFiles are the file names marking a directory as a kustomization
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// clusterScopedKinds lists the common built-in kinds that are not placed in the test namespace.
+// The apply step uses the cluster's REST mapping instead, this list only affects rendered manifests.
+var clusterScopedKinds = map[string]bool{
+	"Namespace":                      true,
+	"Node":                           true,
+	"PersistentVolume":               true,
+	"ClusterRole":                    true,
+	"ClusterRoleBinding":             true,
+	"CustomResourceDefinition":       true,
+	"StorageClass":                   true,
+	"PriorityClass":                  true,
+	"IngressClass":                   true,
+	"RuntimeClass":                   true,
+	"APIService":                     true,
+	"ValidatingWebhookConfiguration": true,
+	"MutatingWebhookConfiguration":   true,
+}
+
+// IsClusterScopedKind reports whether kind is a known cluster-scoped built-in kind
+func IsClusterScopedKind(kind string) bool {
+	return clusterScopedKinds[kind]
+}
+
+// Fixtures loads the fixture manifests at paths and prepares them for the run's test namespace.
+// Each path may be a manifest file, a directory of manifests, or a kustomization directory.
+// Objects keep the order they appear in, and carry the run labels and annotations, so cluster-scoped
+// ones can be told apart and reaped by `ket gc` once the run's namespace is gone or its TTL expired.
+func Fixtures(paths []string, run RunMetadata) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	for _, path := range paths {
+		loaded, err := loadFixturePath(path)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, loaded...)
+	}
+
+	for _, obj := range objects {
+		if !IsClusterScopedKind(obj.GetKind()) {
+			obj.SetNamespace(run.Namespace)
+		}
+
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		for key, value := range run.Labels() {
+			labels[key] = value
+		}
+		obj.SetLabels(labels)
+
+		annotations := obj.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		for key, value := range run.Annotations() {
+			annotations[key] = value
+		}
+		if len(annotations) > 0 {
+			obj.SetAnnotations(annotations)
+		}
+	}
+
+	return objects, nil
+}
+
+// loadFixturePath loads the objects from a single fixture path
+func loadFixturePath(path string) ([]*unstructured.Unstructured, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture %s: %w", path, err)
+	}
+
+	if !info.IsDir() {
+		return loadFixtureFile(path)
+	}
+
+	if isKustomization(path) {
+		return buildKustomization(path)
+	}
+
+	var objects []*unstructured.Unstructured
+	err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if file != path && isKustomization(file) {
+				loaded, err := buildKustomization(file)
+				if err != nil {
+					return err
+				}
+				objects = append(objects, loaded...)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".yaml", ".yml", ".json":
+			loaded, err := loadFixtureFile(file)
+			if err != nil {
+				return err
+			}
+			objects = append(objects, loaded...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// isKustomization reports whether dir contains a kustomization file
+func isKustomization(dir string) bool {
+	for _, name := range kustomizationFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// buildKustomization renders a kustomization directory
+func buildKustomization(dir string) ([]*unstructured.Unstructured, error) {
+	resources, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(filesys.MakeFsOnDisk(), dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kustomization %s: %w", dir, err)
+	}
+
+	data, err := resources.AsYaml()
+	if err != nil {
+		return nil, fmt.Errorf("failed to render kustomization %s: %w", dir, err)
+	}
+
+	return decodeFixtures(dir, data)
+}
+
+// loadFixtureFile reads the objects from a single YAML or JSON file
+func loadFixtureFile(file string) ([]*unstructured.Unstructured, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture %s: %w", file, err)
+	}
+	return decodeFixtures(file, data)
+}
+
+// decodeFixtures decodes a multi-document YAML or JSON stream, expanding List objects into their items
+func decodeFixtures(source string, data []byte) ([]*unstructured.Unstructured, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+
+	var objects []*unstructured.Unstructured
+	for {
+		var content map[string]interface{}
+		if err := decoder.Decode(&content); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to parse fixture %s: %w", source, err)
+		}
+		if len(content) == 0 {
+			continue
+		}
+
+		obj := &unstructured.Unstructured{Object: content}
+		if obj.IsList() {
+			err := obj.EachListItem(func(item runtime.Object) error {
+				objects = append(objects, item.(*unstructured.Unstructured))
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse fixture %s: %w", source, err)
+			}
+			continue
+		}
+		objects = append(objects, obj)
+	}
+
+	for _, obj := range objects {
+		if obj.GetAPIVersion() == "" || obj.GetKind() == "" {
+			return nil, fmt.Errorf("fixture %s contains an object without apiVersion or kind", source)
+		}
+		if obj.GetName() == "" {
+			return nil, fmt.Errorf("fixture %s contains a %s without metadata.name", source, obj.GetKind())
+		}
+	}
+
+	return objects, nil
+}
@@ -1,48 +1,164 @@
 package generate
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"testrunner/pkg/config"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
 )
 
 func TestNamespace_GeneratesCorrectManifest(t *testing.T) {
 	namespace := "test-namespace"
-	ns := Namespace(namespace)
+	ns := Namespace(RunMetadata{Namespace: namespace})
 
 	assert.Equal(t, "v1", ns.APIVersion)
 	assert.Equal(t, "Namespace", ns.Kind)
 	assert.Equal(t, namespace, ns.Name)
+	assert.Equal(t, RunLabels(namespace), ns.Labels)
 }
 
-
-
 func TestRole_GeneratesCorrectManifest(t *testing.T) {
-	role := ClusterRole()
+	role := Role(RunMetadata{Namespace: "test-namespace"}, GetTestRunnerRBACRules()...)
 
 	assert.Equal(t, "rbac.authorization.k8s.io/v1", role.APIVersion)
-	assert.Equal(t, "ClusterRole", role.Kind)
+	assert.Equal(t, "Role", role.Kind)
 	assert.Equal(t, "ket-test-runner", role.Name)
-	assert.NotEmpty(t, role.Rules)
+	assert.Equal(t, "test-namespace", role.Namespace)
+	assert.Equal(t, ManagedByValue, role.Labels[ManagedByLabel])
+	assert.Equal(t, "test-namespace", role.Labels[TestNamespaceLabel])
+	assert.Equal(t, GetTestRunnerRBACRules(), role.Rules)
+}
+
+func TestServiceAccount_GeneratesCorrectManifest(t *testing.T) {
+	sa := ServiceAccount(RunMetadata{Namespace: "test-namespace"})
+
+	assert.Equal(t, "v1", sa.APIVersion)
+	assert.Equal(t, "ServiceAccount", sa.Kind)
+	assert.Equal(t, TestRunnerServiceAccountName, sa.Name)
+	assert.Equal(t, "test-namespace", sa.Namespace)
+	assert.Equal(t, RunLabels("test-namespace"), sa.Labels)
+}
+
+func TestRBAC_ClusterRulesOnlyWhenMarked(t *testing.T) {
+	run := RunMetadata{Namespace: "test-namespace"}
+	namespaced := []rbacv1.PolicyRule{{APIGroups: []string{"custom.io"}, Resources: []string{"widgets"}, Verbs: []string{"get"}}}
+	clusterWide := []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"list"}}}
+
+	rbac := RBAC(run, RBACRules{Rules: namespaced})
+	assert.Nil(t, rbac.ClusterRole)
+	assert.Nil(t, rbac.ClusterRoleBinding)
+	assert.Len(t, rbac.List(), 3)
+	assert.Contains(t, rbac.Role.Rules, namespaced[0])
+
+	rbac = RBAC(run, RBACRules{Rules: namespaced, ClusterRules: clusterWide})
+	require.NotNil(t, rbac.ClusterRole)
+	assert.Equal(t, clusterWide, rbac.ClusterRole.Rules, "only the rules marked as cluster-wide are granted cluster-wide")
+	assert.NotContains(t, rbac.Role.Rules, clusterWide[0])
+	assert.Len(t, rbac.List(), 5)
+}
+
+func TestRole_NamesAreUniquePerNamespace(t *testing.T) {
+	first := ClusterRole(RunMetadata{Namespace: "test-namespace-a"})
+	second := ClusterRole(RunMetadata{Namespace: "test-namespace-b"})
+
+	assert.NotEqual(t, first.Name, second.Name)
+	assert.NotEqual(t, ClusterRoleBinding(RunMetadata{Namespace: "test-namespace-a"}).Name, ClusterRoleBinding(RunMetadata{Namespace: "test-namespace-b"}).Name)
+}
+
+func TestRunSelector_MatchesRunLabels(t *testing.T) {
+	selector := RunSelector("test-namespace")
+
+	assert.Contains(t, selector, ManagedByLabel+"="+ManagedByValue)
+	assert.Contains(t, selector, TestNamespaceLabel+"=test-namespace")
+}
+
+func TestRunMetadata_StampsGeneratedObjects(t *testing.T) {
+	run := RunMetadata{
+		Namespace: "test-namespace",
+		RunID:     "run-123",
+		CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		TTL:       2 * time.Hour,
+		OwnerHost: "ci-runner",
+		OwnerUser: "alice",
+	}
+	job, err := Job(config.Config{Image: "node:18", TestCommand: "npm test"}, run, 0)
+	require.NoError(t, err)
+
+	for name, obj := range map[string]metav1.Object{
+		"namespace":          Namespace(run),
+		"serviceaccount":     ServiceAccount(run),
+		"role":               Role(run),
+		"rolebinding":        RoleBinding(run),
+		"clusterrole":        ClusterRole(run),
+		"clusterrolebinding": ClusterRoleBinding(run),
+		"job":                job,
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, "run-123", obj.GetLabels()[RunIDLabel])
+			assert.Equal(t, "test-namespace", obj.GetLabels()[TestNamespaceLabel])
+			assert.Equal(t, map[string]string{
+				CreatedAtAnnotation: "2024-05-01T12:00:00Z",
+				TTLAnnotation:       "2h0m0s",
+				OwnerHostAnnotation: "ci-runner",
+				OwnerUserAnnotation: "alice",
+			}, obj.GetAnnotations())
+		})
+	}
+}
+
+func TestRunExpiry(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	expiry, ok := RunExpiry(RunMetadata{CreatedAt: created, TTL: time.Hour}.Annotations(), time.Time{})
+	require.True(t, ok)
+	assert.Equal(t, created.Add(time.Hour), expiry)
+
+	expiry, ok = RunExpiry(map[string]string{TTLAnnotation: "30m"}, created)
+	require.True(t, ok, "the creation timestamp is used when the annotation is missing")
+	assert.Equal(t, created.Add(30*time.Minute), expiry)
+
+	_, ok = RunExpiry(RunMetadata{CreatedAt: created}.Annotations(), created)
+	assert.False(t, ok, "objects without a TTL never expire")
 }
 
 func TestRoleBinding_GeneratesCorrectManifest(t *testing.T) {
 	namespace := "test-namespace"
-	rb := ClusterRoleBinding(namespace)
+	rb := RoleBinding(RunMetadata{Namespace: namespace})
+
+	assert.Equal(t, "RoleBinding", rb.Kind)
+	assert.Equal(t, "ket-test-runner", rb.Name)
+	assert.Equal(t, namespace, rb.Namespace)
+	assert.Equal(t, []rbacv1.Subject{{Kind: "ServiceAccount", Name: TestRunnerServiceAccountName, Namespace: namespace}}, rb.Subjects)
+	assert.Equal(t, rbacv1.RoleRef{Kind: "Role", Name: "ket-test-runner", APIGroup: "rbac.authorization.k8s.io"}, rb.RoleRef)
+}
+
+func TestClusterRoleBinding_GeneratesCorrectManifest(t *testing.T) {
+	namespace := "test-namespace"
+	rb := ClusterRoleBinding(RunMetadata{Namespace: namespace})
 
 	assert.Equal(t, "rbac.authorization.k8s.io/v1", rb.APIVersion)
 	assert.Equal(t, "ClusterRoleBinding", rb.Kind)
-	assert.Equal(t, "ket-test-runner", rb.Name)
+	assert.Equal(t, "ket-test-runner-test-namespace", rb.Name)
+	assert.Equal(t, RunLabels(namespace), rb.Labels)
 	assert.Len(t, rb.Subjects, 1)
 	assert.Equal(t, "ServiceAccount", rb.Subjects[0].Kind)
-	assert.Equal(t, "default", rb.Subjects[0].Name)
+	assert.Equal(t, TestRunnerServiceAccountName, rb.Subjects[0].Name)
 	assert.Equal(t, namespace, rb.Subjects[0].Namespace)
 	assert.Equal(t, "ClusterRole", rb.RoleRef.Kind)
-	assert.Equal(t, "ket-test-runner", rb.RoleRef.Name)
+	assert.Equal(t, "ket-test-runner-test-namespace", rb.RoleRef.Name)
 }
 
 func TestJob_GeneratesCorrectManifest(t *testing.T) {
@@ -56,7 +172,7 @@ func TestJob_GeneratesCorrectManifest(t *testing.T) {
 	}
 	namespace := "test-namespace"
 
-	job, err := Job(cfg, namespace)
+	job, err := Job(cfg, RunMetadata{Namespace: namespace}, 0)
 	require.NoError(t, err)
 
 	assert.Equal(t, "batch/v1", job.APIVersion)
@@ -65,6 +181,7 @@ func TestJob_GeneratesCorrectManifest(t *testing.T) {
 	assert.Equal(t, namespace, job.Namespace)
 	assert.Equal(t, int32(2), *job.Spec.BackoffLimit)
 	assert.Equal(t, int64(1800), *job.Spec.ActiveDeadlineSeconds)
+	assert.Equal(t, TestRunnerServiceAccountName, job.Spec.Template.Spec.ServiceAccountName)
 
 	// Verify container configuration
 	container := job.Spec.Template.Spec.Containers[0]
@@ -81,6 +198,8 @@ func TestJob_GeneratesCorrectManifest(t *testing.T) {
 	assert.Equal(t, namespace, envVars["KET_TEST_NAMESPACE"])
 	assert.Equal(t, "test-project", envVars["KET_PROJECT_ROOT"])
 	assert.Equal(t, "/workspace", envVars["KET_WORKSPACE_PATH"])
+	assert.Equal(t, "0", envVars["KET_SHARD_INDEX"])
+	assert.Equal(t, "1", envVars["KET_SHARD_TOTAL"])
 
 	// Verify volume mounts
 	assert.Len(t, container.VolumeMounts, 2)
@@ -101,6 +220,705 @@ func TestJob_GeneratesCorrectManifest(t *testing.T) {
 	assert.True(t, volumeNames["reports"])
 }
 
+func TestJobs_OneJobPerShard(t *testing.T) {
+	cfg := config.Config{
+		ProjectRoot:   "test-project",
+		Image:         "test-image:latest",
+		TestCommand:   "npm test",
+		WorkspacePath: "/workspace",
+		Shards:        3,
+	}
+
+	jobs, err := Jobs(cfg, RunMetadata{Namespace: "test-namespace"})
+	require.NoError(t, err)
+	require.Len(t, jobs, 3)
+
+	for i, job := range jobs {
+		assert.Equal(t, fmt.Sprintf("ket-test-project-shard-%d", i), job.Name)
+		assert.Equal(t, strconv.Itoa(i), job.Labels[ShardIndexLabel])
+		assert.Equal(t, strconv.Itoa(i), job.Spec.Template.Labels[ShardIndexLabel], "pods carry the shard for log prefixes")
+
+		envVars := make(map[string]string)
+		for _, env := range job.Spec.Template.Spec.Containers[0].Env {
+			envVars[env.Name] = env.Value
+		}
+		assert.Equal(t, strconv.Itoa(i), envVars["KET_SHARD_INDEX"])
+		assert.Equal(t, "3", envVars["KET_SHARD_TOTAL"])
+	}
+
+	_, err = Job(cfg, RunMetadata{Namespace: "test-namespace"}, 3)
+	assert.Error(t, err)
+
+	cfg.Shards = 0
+	jobs, err = Jobs(cfg, RunMetadata{Namespace: "test-namespace"})
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "ket-test-project", jobs[0].Name, "unsharded runs keep the plain job name")
+	assert.NotContains(t, jobs[0].Labels, ShardIndexLabel)
+}
+
+func TestJobs_MatrixCells(t *testing.T) {
+	cfg := config.Config{
+		ProjectRoot:   "test-project",
+		Image:         "test-image:latest",
+		TestCommand:   "npm test",
+		WorkspacePath: "/workspace",
+		Shards:        2,
+		Matrix: config.MatrixConfig{
+			Images: []string{"node:18", "node:20"},
+			Env:    []config.MatrixAxis{{Name: "DB", Values: []string{"mongo"}}},
+		},
+	}
+
+	jobs, err := Jobs(cfg, RunMetadata{Namespace: "test-namespace"})
+	require.NoError(t, err)
+	require.Len(t, jobs, 4, "every matrix cell gets its own shards")
+
+	job := jobs[3]
+	assert.Equal(t, "ket-test-project-m1-shard-1", job.Name)
+	assert.Equal(t, "1", job.Labels[MatrixCellLabel])
+	assert.Equal(t, "node:20 DB=mongo", job.Annotations[MatrixCellAnnotation])
+	assert.Equal(t, "node:20 DB=mongo", job.Spec.Template.Annotations[MatrixCellAnnotation])
+
+	container := job.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, "node:20", container.Image)
+	envVars := make(map[string]string)
+	for _, env := range container.Env {
+		envVars[env.Name] = env.Value
+	}
+	assert.Equal(t, "mongo", envVars["DB"])
+	assert.Equal(t, "node:20 DB=mongo", envVars["KET_MATRIX_CELL"])
+
+	cfg.Matrix.Env = append(cfg.Matrix.Env, config.MatrixAxis{Values: []string{"x"}})
+	_, err = Jobs(cfg, RunMetadata{Namespace: "test-namespace"})
+	assert.Error(t, err, "unnamed axes are rejected")
+}
+
+func TestTestEnv_SourcesAndPrecedence(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	require.NoError(t, os.WriteFile(envFile, []byte("# comment\nFEATURE_X=on\nexport API_URL=\"http://from-file\"\nLOG_LEVEL=info\n"), 0o644))
+	t.Setenv("KET_TEST_FORWARDED", "forwarded")
+	t.Setenv("LOG_LEVEL", "debug")
+
+	env, err := TestEnv(config.Config{
+		EnvFrom:    []string{envFile},
+		ForwardEnv: []string{"KET_TEST_FORWARDED", "LOG_LEVEL", "KET_TEST_UNSET"},
+		Env:        []string{"API_URL=http://explicit", "LIST=a,b"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "API_URL", Value: "http://explicit"},
+		{Name: "FEATURE_X", Value: "on"},
+		{Name: "LOG_LEVEL", Value: "debug"},
+		{Name: "KET_TEST_FORWARDED", Value: "forwarded"},
+		{Name: "LIST", Value: "a,b"},
+	}, env)
+
+	_, err = TestEnv(config.Config{Env: []string{"NO_VALUE"}})
+	assert.Error(t, err)
+	_, err = TestEnv(config.Config{Env: []string{"1INVALID=x"}})
+	assert.Error(t, err)
+	_, err = TestEnv(config.Config{EnvFrom: []string{filepath.Join(t.TempDir(), "missing.env")}})
+	assert.Error(t, err)
+}
+
+func TestSecrets_FromLiteralsFilesAndEnv(t *testing.T) {
+	certFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(certFile, []byte("CERT"), 0o644))
+	t.Setenv("KET_TEST_TOKEN", "token-value")
+
+	secrets, err := Secrets(config.Config{Secrets: []config.SecretConfig{{
+		Name:     "api-credentials",
+		Literals: []string{"USER=admin"},
+		Files:    []string{certFile, "tls.key=" + certFile},
+		Env:      []string{"KET_TEST_TOKEN"},
+	}}}, RunMetadata{Namespace: "test-namespace"})
+	require.NoError(t, err)
+	require.Len(t, secrets, 1)
+
+	secret := secrets[0]
+	assert.Equal(t, "test-namespace", secret.Namespace)
+	assert.Equal(t, map[string][]byte{
+		"USER":           []byte("admin"),
+		"ca.pem":         []byte("CERT"),
+		"tls.key":        []byte("CERT"),
+		"KET_TEST_TOKEN": []byte("token-value"),
+	}, secret.Data)
+
+	redacted := RedactSecret(secret)
+	assert.Nil(t, redacted.Data)
+	assert.Equal(t, RedactedValue, redacted.StringData["USER"])
+	assert.Equal(t, []byte("admin"), secret.Data["USER"], "the original secret is unchanged")
+
+	_, err = Secrets(config.Config{Secrets: []config.SecretConfig{{Name: "s", Env: []string{"KET_TEST_UNSET"}}}}, RunMetadata{})
+	assert.Error(t, err, "secrets fail when a host variable is missing")
+	_, err = Secrets(config.Config{Secrets: []config.SecretConfig{{Name: "Invalid_Name"}}}, RunMetadata{})
+	assert.Error(t, err)
+	_, err = Secrets(config.Config{Secrets: []config.SecretConfig{{Name: TestEnvSecretName}}}, RunMetadata{})
+	assert.Error(t, err, "the test environment secret name is reserved")
+}
+
+func TestSecrets_TestEnvironment(t *testing.T) {
+	t.Setenv("KET_TEST_FORWARDED", "forwarded")
+
+	secrets, err := Secrets(config.Config{}, RunMetadata{Namespace: "test-namespace"})
+	require.NoError(t, err)
+	assert.Empty(t, secrets, "no secret without custom environment variables")
+
+	secrets, err = Secrets(config.Config{
+		ForwardEnv: []string{"KET_TEST_FORWARDED"},
+		Env:        []string{"CI=true"},
+	}, RunMetadata{Namespace: "test-namespace"})
+	require.NoError(t, err)
+	require.Len(t, secrets, 1)
+	assert.Equal(t, TestEnvSecretName, secrets[0].Name)
+	assert.Equal(t, "test-namespace", secrets[0].Namespace)
+	assert.Equal(t, map[string][]byte{
+		"KET_TEST_FORWARDED": []byte("forwarded"),
+		"CI":                 []byte("true"),
+	}, secrets[0].Data)
+}
+
+func TestJob_CustomEnvAndSecrets(t *testing.T) {
+	cfg := config.Config{
+		ProjectRoot:   "test-project",
+		Image:         "test-image:latest",
+		TestCommand:   "npm test",
+		WorkspacePath: "/workspace",
+		Env:           []string{"CI=true"},
+		Secrets: []config.SecretConfig{
+			{Name: "injected"},
+			{Name: "mounted", MountPath: "/etc/creds"},
+			{Name: "both", MountPath: "/etc/both", Inject: true},
+		},
+	}
+
+	job, err := Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
+	require.NoError(t, err)
+	container := job.Spec.Template.Spec.Containers[0]
+
+	assert.Contains(t, container.Env, corev1.EnvVar{Name: "CI", ValueFrom: &corev1.EnvVarSource{
+		SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: TestEnvSecretName},
+			Key:                  "CI",
+		},
+	}}, "custom values stay out of the job spec")
+
+	var envFrom []string
+	for _, source := range container.EnvFrom {
+		envFrom = append(envFrom, source.SecretRef.Name)
+	}
+	assert.Equal(t, []string{"injected", "both"}, envFrom)
+
+	mounts := make(map[string]corev1.VolumeMount)
+	for _, mount := range container.VolumeMounts {
+		mounts[mount.MountPath] = mount
+	}
+	assert.Equal(t, "secret-mounted", mounts["/etc/creds"].Name)
+	assert.True(t, mounts["/etc/creds"].ReadOnly)
+	assert.Contains(t, mounts, "/etc/both")
+	assert.Len(t, job.Spec.Template.Spec.Volumes, 4)
+}
+
+func TestJob_PodConfig(t *testing.T) {
+	runAsNonRoot := true
+	allowEscalation := false
+	cfg := config.Config{
+		ProjectRoot: "test-project",
+		Image:       "test-image:latest",
+		ReportsDir:  "./reports",
+		Pod: config.PodConfig{
+			Resources: config.ResourcesConfig{
+				Requests: config.ResourceListConfig{CPU: "500m", Memory: "512Mi"},
+				Limits:   config.ResourceListConfig{CPU: "2", Memory: "1Gi", EphemeralStorage: "2Gi"},
+			},
+			NodeSelector:      []string{"kubernetes.io/arch=amd64", "pool=ci"},
+			Tolerations:       []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "ci", Effect: corev1.TaintEffectNoSchedule}},
+			PriorityClassName: "ci-low",
+			ImagePullSecrets:  []string{"registry-creds"},
+			SecurityContext:   &corev1.PodSecurityContext{RunAsNonRoot: &runAsNonRoot},
+			ContainerSecurityContext: &corev1.SecurityContext{
+				AllowPrivilegeEscalation: &allowEscalation,
+				Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+			},
+		},
+	}
+
+	job, err := Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
+	require.NoError(t, err)
+	spec := job.Spec.Template.Spec
+
+	runner := spec.Containers[0]
+	assert.Equal(t, "500m", runner.Resources.Requests.Cpu().String())
+	assert.Equal(t, "1Gi", runner.Resources.Limits.Memory().String())
+	assert.Equal(t, "2Gi", runner.Resources.Limits.StorageEphemeral().String())
+	assert.Equal(t, ReportsCollectorContainerName, spec.Containers[1].Name)
+	assert.Equal(t, helperResources, spec.Containers[1].Resources, "helper containers get their own fixed resources")
+
+	assert.Equal(t, map[string]string{"kubernetes.io/arch": "amd64", "pool": "ci"}, spec.NodeSelector)
+	assert.Equal(t, cfg.Pod.Tolerations, spec.Tolerations)
+	assert.Equal(t, "ci-low", spec.PriorityClassName)
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "registry-creds"}}, spec.ImagePullSecrets)
+	assert.Equal(t, cfg.Pod.SecurityContext, spec.SecurityContext)
+
+	for _, container := range append(spec.InitContainers, spec.Containers...) {
+		assert.Equal(t, cfg.Pod.ContainerSecurityContext, container.SecurityContext, container.Name)
+	}
+	spec.Containers[0].SecurityContext.Capabilities.Drop[0] = "NET_RAW"
+	assert.Equal(t, corev1.Capability("ALL"), spec.Containers[1].SecurityContext.Capabilities.Drop[0], "containers should not share a security context")
+}
+
+func TestJob_HelperContainerResources(t *testing.T) {
+	sources := []config.SourceConfig{
+		{Mode: config.SourceModeUpload},
+		{Mode: config.SourceModeGit, Git: config.GitSourceConfig{Repository: "https://example.com/repo.git", Ref: "main"}},
+	}
+	for _, source := range sources {
+		cfg := config.Config{ProjectRoot: ".", Image: "test-image:latest", ReportsDir: "./reports", Source: source}
+		job, err := Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
+		require.NoError(t, err)
+		spec := job.Spec.Template.Spec
+
+		require.NotEmpty(t, spec.InitContainers, source.Mode)
+		for _, container := range append(spec.InitContainers, spec.Containers...) {
+			if container.Name == TestRunnerContainerName {
+				assert.Empty(t, container.Resources, "the test container only gets the configured resources")
+				continue
+			}
+			assert.NotEmpty(t, container.Resources.Requests, container.Name)
+			assert.NotEmpty(t, container.Resources.Limits, container.Name)
+		}
+	}
+}
+
+func TestJob_PodConfigValidation(t *testing.T) {
+	seconds := int64(30)
+	tests := []struct {
+		name     string
+		pod      config.PodConfig
+		expected string
+	}{
+		{"bad quantity", config.PodConfig{Resources: config.ResourcesConfig{Limits: config.ResourceListConfig{Memory: "lots"}}}, "invalid resource limits"},
+		{"request above limit", config.PodConfig{Resources: config.ResourcesConfig{
+			Requests: config.ResourceListConfig{CPU: "2"},
+			Limits:   config.ResourceListConfig{CPU: "1"},
+		}}, "exceeds its limit"},
+		{"node selector without value", config.PodConfig{NodeSelector: []string{"pool"}}, "expected key=value"},
+		{"invalid node selector value", config.PodConfig{NodeSelector: []string{"pool=not valid"}}, "invalid node selector value"},
+		{"unknown operator", config.PodConfig{Tolerations: []corev1.Toleration{{Key: "a", Operator: "In"}}}, "unsupported operator"},
+		{"exists with value", config.PodConfig{Tolerations: []corev1.Toleration{{Key: "a", Operator: corev1.TolerationOpExists, Value: "b"}}}, "value must be empty"},
+		{"unknown effect", config.PodConfig{Tolerations: []corev1.Toleration{{Key: "a", Effect: "NoRun"}}}, "unsupported effect"},
+		{"seconds without NoExecute", config.PodConfig{Tolerations: []corev1.Toleration{{Key: "a", TolerationSeconds: &seconds}}}, "requires the NoExecute effect"},
+		{"invalid priority class", config.PodConfig{PriorityClassName: "Low_Priority"}, "invalid priority class name"},
+		{"invalid pull secret", config.PodConfig{ImagePullSecrets: []string{"Registry"}}, "invalid image pull secret name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{ProjectRoot: "test-project", Image: "test-image:latest", Pod: tt.pod}
+			_, err := Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid pod config")
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+func TestJob_Services(t *testing.T) {
+	allowEscalation := false
+	cfg := config.Config{
+		ProjectRoot: "test-project",
+		Image:       "test-image:latest",
+		Source:      config.SourceConfig{Mode: config.SourceModeGit, Git: config.GitSourceConfig{Repository: "https://example.com/repo.git"}},
+		Services: []config.ServiceConfig{
+			{
+				Name:           "postgres",
+				Image:          "postgres:16-alpine",
+				Ports:          []int32{5432},
+				Env:            []string{"POSTGRES_PASSWORD=test"},
+				ReadinessProbe: &config.ProbeConfig{Exec: []string{"pg_isready", "-U", "postgres"}},
+			},
+			{
+				Name:           "mock-api",
+				Image:          "mockserver/mockserver:5.15.0",
+				Args:           []string{"-serverPort", "1080"},
+				ReadinessProbe: &config.ProbeConfig{HTTPGet: &config.HTTPGetProbeConfig{Port: 1080}, PeriodS: 5, FailureThreshold: 10},
+			},
+			{Name: "redis", Image: "redis:7"},
+		},
+		Pod: config.PodConfig{ContainerSecurityContext: &corev1.SecurityContext{AllowPrivilegeEscalation: &allowEscalation}},
+	}
+
+	job, err := Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
+	require.NoError(t, err)
+	initContainers := job.Spec.Template.Spec.InitContainers
+	require.Len(t, initContainers, 4)
+	assert.Equal(t, SourceGitContainerName, initContainers[0].Name, "services start once the source is in place")
+	assert.Nil(t, initContainers[0].RestartPolicy)
+
+	postgres := initContainers[1]
+	assert.Equal(t, "postgres", postgres.Name)
+	require.NotNil(t, postgres.RestartPolicy)
+	assert.Equal(t, corev1.ContainerRestartPolicyAlways, *postgres.RestartPolicy)
+	assert.Equal(t, []corev1.ContainerPort{{ContainerPort: 5432, Protocol: corev1.ProtocolTCP}}, postgres.Ports)
+	assert.Equal(t, []corev1.EnvVar{{Name: "POSTGRES_PASSWORD", Value: "test"}}, postgres.Env)
+	assert.Equal(t, []string{"pg_isready", "-U", "postgres"}, postgres.ReadinessProbe.Exec.Command)
+	assert.Equal(t, int32(2), postgres.ReadinessProbe.PeriodSeconds)
+	assert.Equal(t, postgres.ReadinessProbe.Exec, postgres.StartupProbe.Exec)
+	assert.Equal(t, int32(30), postgres.StartupProbe.FailureThreshold)
+	assert.Equal(t, &allowEscalation, postgres.SecurityContext.AllowPrivilegeEscalation)
+
+	mock := initContainers[2]
+	assert.Equal(t, []string{"-serverPort", "1080"}, mock.Args)
+	assert.Equal(t, "/", mock.ReadinessProbe.HTTPGet.Path)
+	assert.Equal(t, int32(1080), mock.ReadinessProbe.HTTPGet.Port.IntVal)
+	assert.Equal(t, int32(5), mock.StartupProbe.PeriodSeconds)
+	assert.Equal(t, int32(10), mock.StartupProbe.FailureThreshold)
+
+	assert.Nil(t, initContainers[3].ReadinessProbe)
+	assert.Nil(t, initContainers[3].StartupProbe)
+}
+
+func TestJob_ServiceValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		service  config.ServiceConfig
+		expected string
+	}{
+		{"invalid name", config.ServiceConfig{Name: "Postgres", Image: "postgres"}, "invalid service name"},
+		{"reserved name", config.ServiceConfig{Name: TestRunnerContainerName, Image: "postgres"}, "already in use"},
+		{"missing image", config.ServiceConfig{Name: "postgres"}, "has no image"},
+		{"invalid port", config.ServiceConfig{Name: "postgres", Image: "postgres", Ports: []int32{70000}}, "invalid port"},
+		{"invalid env", config.ServiceConfig{Name: "postgres", Image: "postgres", Env: []string{"PASSWORD"}}, "expected KEY=VALUE"},
+		{"probe without handler", config.ServiceConfig{Name: "postgres", Image: "postgres", ReadinessProbe: &config.ProbeConfig{PeriodS: 1}}, "exactly one of"},
+		{"probe with two handlers", config.ServiceConfig{Name: "postgres", Image: "postgres", ReadinessProbe: &config.ProbeConfig{
+			TCPPort: 5432,
+			Exec:    []string{"pg_isready"},
+		}}, "exactly one of"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{ProjectRoot: "test-project", Image: "test-image:latest", Services: []config.ServiceConfig{tt.service}}
+			_, err := Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+
+	cfg := config.Config{ProjectRoot: "test-project", Services: []config.ServiceConfig{{Name: "db", Image: "postgres"}, {Name: "db", Image: "mysql"}}}
+	_, err := Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
+	assert.ErrorContains(t, err, "already in use")
+}
+
+func TestObjects_AppliesPatches(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "fixtures.yml")
+	require.NoError(t, os.WriteFile(fixture, []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: mongodb
+spec:
+  template:
+    spec:
+      containers:
+        - name: mongodb
+          image: mongo:7
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+spec:
+  size: 1
+`), 0o644))
+
+	cfg := config.Config{
+		ProjectRoot: "test-project",
+		Image:       "test-image:latest",
+		Fixtures:    []string{fixture},
+		Patches: []config.PatchConfig{
+			{
+				Target: config.PatchTarget{Kind: "Job"},
+				Patch: `spec:
+  template:
+    spec:
+      containers:
+        - name: test-runner
+          imagePullPolicy: Always`,
+			},
+			{
+				Target: config.PatchTarget{Kind: "Namespace"},
+				Type:   config.PatchTypeJSON,
+				Patch:  `[{"op": "add", "path": "/metadata/labels/pod-security.kubernetes.io~1enforce", "value": "restricted"}]`,
+			},
+			{
+				Target: config.PatchTarget{Kind: "Deployment", Name: "mongo*"},
+				Patch:  `{"spec": {"template": {"spec": {"containers": [{"name": "mongodb", "args": ["--quiet"]}]}}}}`,
+			},
+			{
+				Target: config.PatchTarget{Kind: "Widget"},
+				Patch:  "spec:\n  size: 3",
+			},
+		},
+	}
+
+	objects, err := Objects(cfg, RunMetadata{Namespace: "test-namespace"})
+	require.NoError(t, err)
+
+	containers := objects.Jobs[0].Spec.Template.Spec.Containers
+	require.Len(t, containers, 1, "a strategic-merge patch merges containers by name")
+	assert.Equal(t, corev1.PullAlways, containers[0].ImagePullPolicy)
+	assert.Equal(t, "test-image:latest", containers[0].Image)
+
+	assert.Equal(t, "restricted", objects.Namespace.Labels["pod-security.kubernetes.io/enforce"])
+	assert.Equal(t, ManagedByValue, objects.Namespace.Labels[ManagedByLabel])
+
+	deploymentContainers, _, err := unstructured.NestedSlice(objects.Fixtures[0].Object, "spec", "template", "spec", "containers")
+	require.NoError(t, err)
+	require.Len(t, deploymentContainers, 1)
+	assert.Equal(t, "mongo:7", deploymentContainers[0].(map[string]interface{})["image"])
+	assert.Equal(t, []interface{}{"--quiet"}, deploymentContainers[0].(map[string]interface{})["args"])
+
+	size, _, err := unstructured.NestedInt64(objects.Fixtures[1].Object, "spec", "size")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), size, "kinds unknown to the scheme get a JSON merge patch")
+}
+
+func TestObjects_PatchErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		patch    config.PatchConfig
+		expected string
+	}{
+		{"no match", config.PatchConfig{Target: config.PatchTarget{Kind: "Deployment"}, Patch: "metadata: {}"}, "matches no generated objects"},
+		{"unknown type", config.PatchConfig{Type: "merge", Patch: "metadata: {}"}, "unsupported patch type"},
+		{"missing patch", config.PatchConfig{Target: config.PatchTarget{Kind: "Job"}}, "one of patch or path is required"},
+		{"renames object", config.PatchConfig{Target: config.PatchTarget{Kind: "Job"}, Patch: "metadata:\n  name: other"}, "must not change the kind, name or namespace"},
+		{"failed JSON patch", config.PatchConfig{
+			Target: config.PatchTarget{Kind: "Job"},
+			Type:   config.PatchTypeJSON,
+			Patch:  `[{"op": "remove", "path": "/spec/missing"}]`,
+		}, "failed to apply patch 0 to Job test-namespace/ket-test-project"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{ProjectRoot: "test-project", Image: "test-image:latest", Patches: []config.PatchConfig{tt.patch}}
+			_, err := Objects(cfg, RunMetadata{Namespace: "test-namespace"})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+func TestObjects_PatchFromFile(t *testing.T) {
+	patchFile := filepath.Join(t.TempDir(), "priority.yaml")
+	require.NoError(t, os.WriteFile(patchFile, []byte("spec:\n  template:\n    spec:\n      priorityClassName: ci-low\n"), 0o644))
+
+	cfg := config.Config{
+		ProjectRoot: "test-project",
+		Image:       "test-image:latest",
+		Shards:      2,
+		Patches:     []config.PatchConfig{{Target: config.PatchTarget{Kind: "Job", Name: "*-shard-1"}, Path: patchFile}},
+	}
+
+	objects, err := Objects(cfg, RunMetadata{Namespace: "test-namespace"})
+	require.NoError(t, err)
+	require.Len(t, objects.Jobs, 2)
+	assert.Empty(t, objects.Jobs[0].Spec.Template.Spec.PriorityClassName)
+	assert.Equal(t, "ci-low", objects.Jobs[1].Spec.Template.Spec.PriorityClassName)
+}
+
+func TestJob_ReportsCollector(t *testing.T) {
+	cfg := config.Config{
+		ProjectRoot: "test-project",
+		Image:       "test-image:latest",
+		TestCommand: "npm test # runs the suite",
+	}
+
+	job, err := Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
+	require.NoError(t, err)
+	assert.Len(t, job.Spec.Template.Spec.Containers, 1, "collector should only be added when reports are collected")
+	assert.Equal(t, "npm test # runs the suite", job.Spec.Template.Spec.Containers[0].Command[2])
+
+	cfg.ReportsDir = "./reports"
+	job, err = Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
+	require.NoError(t, err)
+	require.Len(t, job.Spec.Template.Spec.Containers, 2)
+
+	collector := job.Spec.Template.Spec.Containers[1]
+	assert.Equal(t, ReportsCollectorContainerName, collector.Name)
+	assert.Equal(t, "test-image:latest", collector.Image)
+	assert.Contains(t, collector.Command[2], ReportsCollectedMarker)
+	assert.Contains(t, collector.Command[2], TestExitedMarker, "the collector should not depend on ket alone to exit")
+	assert.Equal(t, "(\nnpm test # runs the suite\n)\nstatus=$?\ntouch /reports/.ket-test-exited\nexit $status",
+		job.Spec.Template.Spec.Containers[0].Command[2])
+	require.Len(t, collector.VolumeMounts, 1)
+	assert.Equal(t, "reports", collector.VolumeMounts[0].Name)
+	assert.Equal(t, ReportsMountPath, collector.VolumeMounts[0].MountPath)
+}
+
+func TestJob_RBACAudit(t *testing.T) {
+	cfg := config.Config{ProjectRoot: "test-project", Image: "test-image:latest"}
+
+	job, err := Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
+	require.NoError(t, err)
+	assert.Empty(t, job.Spec.Template.Spec.InitContainers, "the auditing proxy should only be added when RBAC is audited")
+
+	cfg.RbacAudit.Enabled = true
+	job, err = Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
+	require.NoError(t, err)
+	podSpec := job.Spec.Template.Spec
+	require.Len(t, podSpec.InitContainers, 1)
+
+	proxy := podSpec.InitContainers[0]
+	assert.Equal(t, RBACAuditContainerName, proxy.Name)
+	assert.Equal(t, DefaultRBACAuditImage, proxy.Image)
+	require.NotNil(t, proxy.RestartPolicy)
+	assert.Equal(t, corev1.ContainerRestartPolicyAlways, *proxy.RestartPolicy, "the proxy runs alongside the test container")
+	assert.Equal(t, int32(RBACAuditProxyPort), proxy.StartupProbe.TCPSocket.Port.IntVal, "the tests wait for the proxy to listen")
+	assert.Contains(t, proxy.Command[2], "kubectl proxy")
+	assert.Contains(t, proxy.Command[2], "server: http://127.0.0.1:8001")
+	assert.Contains(t, proxy.Command[2], "namespace: test-namespace")
+	assert.Equal(t, helperResources, proxy.Resources)
+
+	container := podSpec.Containers[0]
+	assert.Contains(t, container.Env, corev1.EnvVar{Name: "KUBECONFIG", Value: RBACAuditKubeconfig})
+	assert.Contains(t, container.VolumeMounts, proxy.VolumeMounts[0])
+	assert.Contains(t, podSpec.Volumes, rbacAuditVolume())
+
+	cfg.RbacAudit.Image = "registry.example.com/kubectl:1.30"
+	job, err = Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "registry.example.com/kubectl:1.30", job.Spec.Template.Spec.InitContainers[0].Image)
+
+	cfg.Services = []config.ServiceConfig{{Name: RBACAuditContainerName, Image: "redis:7"}}
+	_, err = Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
+	assert.ErrorContains(t, err, "already in use")
+}
+
+func TestJob_SourceModes(t *testing.T) {
+	tests := []struct {
+		name          string
+		source        config.SourceConfig
+		initContainer string
+		check         func(t *testing.T, volume corev1.Volume)
+	}{
+		{
+			name:   "default is host path",
+			source: config.SourceConfig{},
+			check: func(t *testing.T, volume corev1.Volume) {
+				require.NotNil(t, volume.HostPath)
+				assert.Equal(t, "/workspace/test-project", volume.HostPath.Path)
+			},
+		},
+		{
+			name:          "upload",
+			source:        config.SourceConfig{Mode: config.SourceModeUpload},
+			initContainer: SourceUploadContainerName,
+			check: func(t *testing.T, volume corev1.Volume) {
+				assert.NotNil(t, volume.EmptyDir)
+			},
+		},
+		{
+			name: "git",
+			source: config.SourceConfig{
+				Mode: config.SourceModeGit,
+				Git:  config.GitSourceConfig{Repository: "https://example.com/repo.git", Ref: "main"},
+			},
+			initContainer: SourceGitContainerName,
+			check: func(t *testing.T, volume corev1.Volume) {
+				assert.NotNil(t, volume.EmptyDir)
+			},
+		},
+		{
+			name: "pvc",
+			source: config.SourceConfig{
+				Mode: config.SourceModePVC,
+				PVC:  config.PVCSourceConfig{ClaimName: "sources"},
+			},
+			check: func(t *testing.T, volume corev1.Volume) {
+				require.NotNil(t, volume.PersistentVolumeClaim)
+				assert.Equal(t, "sources", volume.PersistentVolumeClaim.ClaimName)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{
+				ProjectRoot:   "test-project",
+				WorkspacePath: "/workspace",
+				Image:         "test-image:latest",
+				Source:        tt.source,
+			}
+
+			job, err := Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
+			require.NoError(t, err)
+
+			podSpec := job.Spec.Template.Spec
+			var source *corev1.Volume
+			for i := range podSpec.Volumes {
+				if podSpec.Volumes[i].Name == SourceVolumeName {
+					source = &podSpec.Volumes[i]
+				}
+			}
+			require.NotNil(t, source)
+			tt.check(t, *source)
+
+			if tt.initContainer == "" {
+				assert.Empty(t, podSpec.InitContainers)
+			} else {
+				require.Len(t, podSpec.InitContainers, 1)
+				assert.Equal(t, tt.initContainer, podSpec.InitContainers[0].Name)
+				assert.Equal(t, SourceMountPath, podSpec.InitContainers[0].VolumeMounts[0].MountPath)
+			}
+		})
+	}
+}
+
+func TestJob_GitSourceEnvironment(t *testing.T) {
+	cfg := config.Config{
+		Source: config.SourceConfig{
+			Mode: config.SourceModeGit,
+			Git:  config.GitSourceConfig{Repository: "https://example.com/repo.git"},
+		},
+	}
+
+	job, err := Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
+	require.NoError(t, err)
+
+	initContainer := job.Spec.Template.Spec.InitContainers[0]
+	assert.Equal(t, DefaultGitImage, initContainer.Image)
+	envVars := make(map[string]string)
+	for _, env := range initContainer.Env {
+		envVars[env.Name] = env.Value
+	}
+	assert.Equal(t, "https://example.com/repo.git", envVars["KET_GIT_REPOSITORY"])
+	assert.Equal(t, "HEAD", envVars["KET_GIT_REF"])
+}
+
+func TestJob_SourceModeValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		source config.SourceConfig
+		errMsg string
+	}{
+		{"unknown mode", config.SourceConfig{Mode: "ftp"}, "unsupported source mode"},
+		{"git without repository", config.SourceConfig{Mode: config.SourceModeGit}, "source.git.repository"},
+		{"pvc without claim", config.SourceConfig{Mode: config.SourceModePVC}, "source.pvc.claimName"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Job(config.Config{Source: tt.source}, RunMetadata{Namespace: "test-namespace"}, 0)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
 func TestJob_WorkingDirectoryCalculation(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -141,7 +959,7 @@ func TestJob_WorkingDirectoryCalculation(t *testing.T) {
 				WorkspacePath: tt.workspacePath,
 			}
 
-			job, err := Job(cfg, "test-namespace")
+			job, err := Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
 			require.NoError(t, err)
 
 			container := job.Spec.Template.Spec.Containers[0]
@@ -174,7 +992,7 @@ func TestJob_ProjectNameGeneration(t *testing.T) {
 				ProjectRoot: tt.projectRoot,
 			}
 
-			job, err := Job(cfg, "test-namespace")
+			job, err := Job(cfg, RunMetadata{Namespace: "test-namespace"}, 0)
 			require.NoError(t, err)
 
 			assert.Equal(t, tt.expectedJob, job.Name)
@@ -201,7 +1019,7 @@ func TestGetTestRunnerRBACRules_ContainsExpectedRules(t *testing.T) {
 	// Verify core resources
 	coreRules := findAllRulesByAPIGroup(rules, "")
 	require.NotEmpty(t, coreRules)
-	
+
 	// Check for pods, services in main core rule
 	foundPods := false
 	foundEvents := false
@@ -276,6 +1094,136 @@ func TestMergeRBACRules_WithEmptyAdditional(t *testing.T) {
 	assert.Equal(t, defaultRules, merged)
 }
 
+func TestLoadRBACRulesFromFile_ClusterRules(t *testing.T) {
+	path := writeFixture(t, t.TempDir(), "rbac.yaml", `rules:
+  - apiGroups: ["custom.io"]
+    resources: ["widgets"]
+    verbs: ["get"]
+clusterRules:
+  - apiGroups: [""]
+    resources: ["nodes"]
+    verbs: ["list"]
+`)
+
+	rules, err := LoadRBACRulesFromFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []rbacv1.PolicyRule{{APIGroups: []string{"custom.io"}, Resources: []string{"widgets"}, Verbs: []string{"get"}}}, rules.Rules)
+	assert.Equal(t, []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"list"}}}, rules.ClusterRules)
+
+	rules, err = LoadRBACRulesFromFile("")
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+}
+
+func TestLoadRBACRulesFromFile_DocumentForms(t *testing.T) {
+	path := writeFixture(t, t.TempDir(), "rbac.yaml", `- apiGroups: ["custom.io"]
+  resources: ["widgets"]
+  verbs: ["get"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: openfeature
+rules:
+  - apiGroups: ["core.openfeature.dev"]
+    resources: ["featureflags"]
+    verbs: ["get", "list"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: nodes
+  annotations:
+    ket.io/cluster-wide: "true"
+rules:
+  - apiGroups: [""]
+    resources: ["nodes"]
+    verbs: ["list"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: leases
+rules:
+  - apiGroups: ["coordination.k8s.io"]
+    resources: ["leases"]
+    verbs: ["get"]
+`)
+
+	rules, err := LoadRBACRulesFromFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []rbacv1.PolicyRule{
+		{APIGroups: []string{"custom.io"}, Resources: []string{"widgets"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"core.openfeature.dev"}, Resources: []string{"featureflags"}, Verbs: []string{"get", "list"}},
+		{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: []string{"get"}},
+	}, rules.Rules, "roles are granted in the test namespace unless marked as cluster-wide")
+	assert.Equal(t, []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"list"}}}, rules.ClusterRules)
+}
+
+func TestLoadRBACRulesFromFile_Example(t *testing.T) {
+	rules, err := LoadRBACRulesFromFile(filepath.Join("..", "..", "..", "..", "example", "rbac", "manifests", "rbac-rules.yaml"))
+	require.NoError(t, err)
+	require.Len(t, rules.Rules, 1)
+	assert.Equal(t, []string{"core.openfeature.dev"}, rules.Rules[0].APIGroups)
+	assert.Empty(t, rules.ClusterRules)
+}
+
+func TestLoadRBACRulesFromFile_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"unsupported kind", "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: x\n", `unsupported kind "ConfigMap"`},
+		{"wrong group", "apiVersion: v1\nkind: Role\nrules: []\n", `unsupported apiVersion "v1" for Role`},
+		{"aggregated", "apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: agg\naggregationRule:\n  clusterRoleSelectors: []\n", "aggregated ClusterRole agg"},
+		{"unknown field", "rules: []\nclusterRule: []\n", "clusterRule"},
+		{"namespaced non-resource URL", "rules:\n  - nonResourceURLs: [/healthz]\n    verbs: [get]\n", "can only be granted cluster-wide"},
+		{"second document", "rules: []\n---\nkind: Secret\n", "document 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFixture(t, t.TempDir(), "rbac.yaml", tt.content)
+			_, err := LoadRBACRulesFromFile(path)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+func TestLoadRBACRules_GlobsAndDeduplication(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "rbac/a.yaml", "- apiGroups: [\"custom.io\"]\n  resources: [widgets]\n  verbs: [get]\n")
+	writeFixture(t, dir, "rbac/b.yaml", "- apiGroups: [\"custom.io\"]\n  resources: [widgets]\n  verbs: [get, list]\n")
+	extra := writeFixture(t, dir, "extra.yaml", "clusterRules:\n  - apiGroups: [\"\"]\n    resources: [nodes]\n    verbs: [list]\n")
+
+	rules, err := LoadRBACRules([]string{filepath.Join(dir, "rbac", "*.yaml"), extra})
+	require.NoError(t, err)
+	assert.Equal(t, []rbacv1.PolicyRule{
+		{APIGroups: []string{"custom.io"}, Resources: []string{"widgets"}, Verbs: []string{"get", "list"}},
+	}, rules.Rules, "repeated rules merge their verbs")
+	assert.Len(t, rules.ClusterRules, 1)
+
+	_, err = LoadRBACRules([]string{filepath.Join(dir, "missing.yaml")})
+	assert.ErrorContains(t, err, "missing.yaml")
+	_, err = LoadRBACRules([]string{filepath.Join(dir, "none", "*.yaml")})
+	assert.ErrorContains(t, err, "matches no files")
+}
+
+func TestMergeRBACRules_MergesWithDefaults(t *testing.T) {
+	defaults := GetTestRunnerRBACRules()
+	merged := MergeRBACRules(defaults, []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"get", "create"}},
+	})
+
+	assert.Len(t, merged, len(defaults), "a rule for resources the defaults already cover adds to that rule")
+	events := findAllRulesByAPIGroup(merged, "")[2]
+	assert.Equal(t, []string{"events"}, events.Resources)
+	assert.Equal(t, []string{"get", "list", "watch", "create"}, events.Verbs)
+	assert.Equal(t, []string{"get", "list", "watch"}, GetTestRunnerRBACRules()[2].Verbs)
+	assert.Equal(t, []string{"get", "list", "watch"}, defaults[2].Verbs, "the defaults are not modified")
+}
+
 func TestRole_WithAdditionalRules(t *testing.T) {
 	additionalRules := []rbacv1.PolicyRule{
 		{
@@ -285,16 +1233,22 @@ func TestRole_WithAdditionalRules(t *testing.T) {
 		},
 	}
 
-	role := ClusterRole(additionalRules...)
+	rbacFile, err := yaml.Marshal(additionalRules)
+	require.NoError(t, err)
+	path := writeFixture(t, t.TempDir(), "rbac.yaml", string(rbacFile))
+
+	objects, err := Objects(config.Config{RbacFiles: []string{path}}, RunMetadata{Namespace: "test-namespace"})
+	require.NoError(t, err)
+	role := objects.Role
 
 	assert.Equal(t, "rbac.authorization.k8s.io/v1", role.APIVersion)
-	assert.Equal(t, "ClusterRole", role.Kind)
+	assert.Equal(t, "Role", role.Kind)
 	assert.Equal(t, "ket-test-runner", role.Name)
-	
+
 	// Should have default rules plus additional rules
 	defaultRuleCount := len(GetTestRunnerRBACRules())
 	assert.Len(t, role.Rules, defaultRuleCount+len(additionalRules))
-	
+
 	// Verify the additional rule is present
 	foundCustomRule := false
 	for _, rule := range role.Rules {
@@ -306,7 +1260,162 @@ func TestRole_WithAdditionalRules(t *testing.T) {
 	assert.True(t, foundCustomRule, "Additional custom rule should be present")
 }
 
+func TestRBACProfile(t *testing.T) {
+	rules, err := RBACProfile("")
+	require.NoError(t, err)
+	assert.Equal(t, GetTestRunnerRBACRules(), rules.Rules, "the default profile is used when none is set")
+
+	rules, err = RBACProfile(config.RBACProfileNone)
+	require.NoError(t, err)
+	assert.Empty(t, rules.Rules)
+	assert.Empty(t, rules.ClusterRules)
+
+	rules, err = RBACProfile(config.RBACProfileReadOnly)
+	require.NoError(t, err)
+	for _, rule := range rules.Rules {
+		assert.Equal(t, []string{"get", "list", "watch"}, rule.Verbs)
+		assert.NotContains(t, rule.Resources, "secrets")
+	}
+
+	rules, err = RBACProfile(config.RBACProfileNamespaceAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, []rbacv1.PolicyRule{{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}}}, rules.Rules)
+	assert.Empty(t, rules.ClusterRules, "namespace-admin stays in the test namespace")
+
+	rules, err = RBACProfile(config.RBACProfileCRDAuthor)
+	require.NoError(t, err)
+	assert.Equal(t, GetTestRunnerRBACRules(), rules.Rules)
+	require.Len(t, rules.ClusterRules, 1)
+	assert.Equal(t, []string{"customresourcedefinitions"}, rules.ClusterRules[0].Resources)
+
+	_, err = RBACProfile("superuser")
+	assert.ErrorContains(t, err, `unknown RBAC profile "superuser"`)
+}
+
+func TestRunRBACRules_ProfilesAndReplace(t *testing.T) {
+	custom := rbacv1.PolicyRule{APIGroups: []string{"custom.io"}, Resources: []string{"widgets"}, Verbs: []string{"get"}}
+	path := writeFixture(t, t.TempDir(), "rbac.yaml", "- apiGroups: [custom.io]\n  resources: [widgets]\n  verbs: [get]\n")
+
+	rules, err := RunRBACRules(config.Config{RbacFiles: []string{path}, RbacProfile: config.RBACProfileReadOnly})
+	require.NoError(t, err)
+	assert.Equal(t, append(readOnlyRBACRules(), custom), rules.Rules, "the RBAC files extend the profile")
+
+	rules, err = RunRBACRules(config.Config{RbacFiles: []string{path}, RbacReplace: true})
+	require.NoError(t, err)
+	assert.Equal(t, []rbacv1.PolicyRule{custom}, rules.Rules, "the RBAC files replace the defaults")
+
+	_, err = RunRBACRules(config.Config{RbacReplace: true, RbacProfile: config.RBACProfileCRDAuthor})
+	assert.ErrorContains(t, err, "has no effect")
+	_, err = Objects(config.Config{RbacProfile: "admin"}, RunMetadata{Namespace: "test-namespace"})
+	assert.ErrorContains(t, err, `unknown RBAC profile "admin"`)
+}
+
 // Helper function to find rule by API group
+func TestParseRBACAuditLog(t *testing.T) {
+	log := `Starting to serve on 127.0.0.1:8001
+I1016 10:00:00.000000       1 round_trippers.go:553] GET https://10.96.0.1:443/api/v1/namespaces/ns/pods?limit=500 200 OK in 4 milliseconds
+I1016 10:00:00.100000       1 round_trippers.go:553] GET https://10.96.0.1:443/api/v1/namespaces/ns/pods/web-0/log 200 OK in 2 milliseconds
+I1016 10:00:00.200000       1 round_trippers.go:632] "Response" verb="GET" url="https://10.96.0.1:443/apis/apps/v1/namespaces/ns/deployments?watch=true" status="200 OK" milliseconds=1
+I1016 10:00:00.300000       1 round_trippers.go:632] "Response" verb="POST" url="https://10.96.0.1:443/apis/apps/v1/namespaces/ns/deployments" status="201 Created" milliseconds=5
+I1016 10:00:00.400000       1 round_trippers.go:553] PATCH https://10.96.0.1:443/api/v1/namespaces/ns/configmaps/settings 200 OK in 3 milliseconds
+I1016 10:00:00.500000       1 round_trippers.go:553] PUT https://10.96.0.1:443/api/v1/namespaces/ns/pods/web-0/status 200 OK in 3 milliseconds
+I1016 10:00:00.600000       1 round_trippers.go:553] DELETE https://10.96.0.1:443/api/v1/namespaces/ns/secrets 200 OK in 3 milliseconds
+I1016 10:00:00.700000       1 round_trippers.go:553] DELETE https://10.96.0.1:443/api/v1/namespaces/ns 200 OK in 3 milliseconds
+I1016 10:00:00.800000       1 round_trippers.go:553] GET https://10.96.0.1:443/api/v1/nodes/node-1 403 Forbidden in 1 milliseconds
+I1016 10:00:00.900000       1 round_trippers.go:553] GET https://10.96.0.1:443/apis/apps/v1 200 OK in 1 milliseconds
+I1016 10:00:01.000000       1 round_trippers.go:553] GET https://10.96.0.1:443/version 200 OK in 1 milliseconds
+`
+
+	requests, err := ParseRBACAuditLog(strings.NewReader(log))
+	require.NoError(t, err)
+	assert.Equal(t, []AuditedRequest{
+		{Verb: "list", Resource: "pods", Namespace: "ns", Status: 200},
+		{Verb: "get", Resource: "pods/log", Name: "web-0", Namespace: "ns", Status: 200},
+		{Verb: "watch", APIGroup: "apps", Resource: "deployments", Namespace: "ns", Status: 200},
+		{Verb: "create", APIGroup: "apps", Resource: "deployments", Namespace: "ns", Status: 201},
+		{Verb: "patch", Resource: "configmaps", Name: "settings", Namespace: "ns", Status: 200},
+		{Verb: "update", Resource: "pods/status", Name: "web-0", Namespace: "ns", Status: 200},
+		{Verb: "deletecollection", Resource: "secrets", Namespace: "ns", Status: 200},
+		{Verb: "delete", Resource: "namespaces", Name: "ns", Namespace: "ns", Status: 200},
+		{Verb: "get", Resource: "nodes", Name: "node-1", Status: 403},
+		{Verb: "get", NonResourceURL: "/apis/apps/v1", Status: 200},
+		{Verb: "get", NonResourceURL: "/version", Status: 200},
+	}, requests)
+	assert.True(t, requests[8].Denied())
+	assert.Equal(t, "get nodes/node-1", requests[8].String())
+}
+
+func TestAuditRBAC(t *testing.T) {
+	requests := []AuditedRequest{
+		{Verb: "list", Resource: "pods", Namespace: "ns"},
+		{Verb: "watch", Resource: "pods", Namespace: "ns"},
+		{Verb: "list", Resource: "services", Namespace: "ns"},
+		{Verb: "watch", Resource: "services", Namespace: "ns"},
+		{Verb: "get", Resource: "pods/log", Name: "web-0", Namespace: "ns"},
+		{Verb: "create", APIGroup: "apps", Resource: "deployments", Namespace: "ns"},
+		{Verb: "delete", Resource: "namespaces", Name: "ns", Namespace: "ns"},
+		{Verb: "get", Resource: "nodes", Name: "node-1", Status: 403},
+		{Verb: "list", Resource: "configmaps", Namespace: "kube-system"},
+		{Verb: "get", NonResourceURL: "/version"},
+	}
+	granted := RBACRules{
+		Rules: GetTestRunnerRBACRules(),
+		ClusterRules: []rbacv1.PolicyRule{
+			{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"list"}},
+			{APIGroups: []string{"apiextensions.k8s.io"}, Resources: []string{"customresourcedefinitions"}, Verbs: []string{"*"}},
+			{NonResourceURLs: []string{"/ver*"}, Verbs: []string{"get"}},
+		},
+	}
+
+	report := AuditRBAC("ns", granted, requests)
+	assert.Equal(t, 10, report.Requests)
+	assert.Equal(t, []AuditedRequest{requests[7]}, report.Denied)
+
+	assert.Equal(t, []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"delete"}},
+		{APIGroups: []string{""}, Resources: []string{"pods", "services"}, Verbs: []string{"list", "watch"}},
+		{APIGroups: []string{""}, Resources: []string{"pods/log"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"create"}},
+	}, report.Suggested.Rules, "resources needing the same verbs share a rule")
+	assert.Equal(t, []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"list"}},
+		{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"get"}},
+		{NonResourceURLs: []string{"/version"}, Verbs: []string{"get"}},
+	}, report.Suggested.ClusterRules, "requests outside the test namespace need cluster-wide rules")
+
+	var unusedGroups []string
+	for _, rule := range report.Unused.Rules {
+		unusedGroups = append(unusedGroups, rule.APIGroups...)
+	}
+	assert.ElementsMatch(t, []string{"", "batch", "networking.k8s.io"}, unusedGroups, "only the events rule of the core group goes unused")
+	assert.Equal(t, []string{"events"}, report.Unused.Rules[0].Resources)
+	assert.Equal(t, granted.ClusterRules[1:2], report.Unused.ClusterRules)
+}
+
+func TestUncoveredRules(t *testing.T) {
+	held := []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"pods", "pods/log"}, Verbs: []string{"*"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"get"}, ResourceNames: []string{"web"}},
+		{NonResourceURLs: []string{"/healthz*"}, Verbs: []string{"get"}},
+	}
+	rules := []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"pods", "services"}, Verbs: []string{"get", "list"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"get"}, ResourceNames: []string{"web", "db"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"*"}, Verbs: []string{"get"}},
+		{NonResourceURLs: []string{"/healthz/ready", "/metrics"}, Verbs: []string{"get"}},
+	}
+
+	assert.Equal(t, []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"services"}, Verbs: []string{"get"}},
+		{APIGroups: []string{""}, Resources: []string{"services"}, Verbs: []string{"list"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"get"}, ResourceNames: []string{"db"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"*"}, Verbs: []string{"get"}},
+		{NonResourceURLs: []string{"/metrics"}, Verbs: []string{"get"}},
+	}, UncoveredRules(held, rules), "a wildcard is only covered by a wildcard")
+
+	assert.Empty(t, UncoveredRules([]rbacv1.PolicyRule{{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}}}, GetTestRunnerRBACRules()))
+}
+
 func findRuleByAPIGroup(rules []rbacv1.PolicyRule, apiGroup string) *rbacv1.PolicyRule {
 	for _, rule := range rules {
 		if len(rule.APIGroups) == 1 && rule.APIGroups[0] == apiGroup {
@@ -315,3 +1424,106 @@ func findRuleByAPIGroup(rules []rbacv1.PolicyRule, apiGroup string) *rbacv1.Poli
 	}
 	return nil
 }
+
+// writeFixture writes content to name under dir, creating parent directories
+func writeFixture(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestFixtures_LoadsFilesAndDirectories(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFixture(t, dir, "mongodb.yml", `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: mongodb
+  namespace: somewhere-else
+  labels:
+    app: mongodb
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: mongodb
+`)
+	writeFixture(t, dir, "more/a-config.yaml", `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "settings"}}`)
+	writeFixture(t, dir, "more/b-crd.yaml", `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+`)
+	writeFixture(t, dir, "more/notes.txt", "not a manifest")
+
+	objects, err := Fixtures([]string{file, filepath.Join(dir, "more")}, RunMetadata{Namespace: "test-ns"})
+	require.NoError(t, err)
+	require.Len(t, objects, 4)
+
+	assert.Equal(t, "Deployment", objects[0].GetKind())
+	assert.Equal(t, "test-ns", objects[0].GetNamespace(), "fixtures are moved into the test namespace")
+	assert.Equal(t, "mongodb", objects[0].GetLabels()["app"], "existing labels are kept")
+	assert.Equal(t, "test-ns", objects[0].GetLabels()[TestNamespaceLabel])
+
+	assert.Equal(t, "Service", objects[1].GetKind())
+	assert.Equal(t, "settings", objects[2].GetName())
+
+	assert.Equal(t, "CustomResourceDefinition", objects[3].GetKind())
+	assert.Empty(t, objects[3].GetNamespace(), "cluster-scoped fixtures get no namespace")
+}
+
+func TestFixtures_ExpandsLists(t *testing.T) {
+	file := writeFixture(t, t.TempDir(), "list.yaml", `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: one
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: two
+`)
+
+	objects, err := Fixtures([]string{file}, RunMetadata{Namespace: "test-ns"})
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+	assert.Equal(t, "one", objects[0].GetName())
+	assert.Equal(t, "two", objects[1].GetName())
+}
+
+func TestFixtures_BuildsKustomization(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "base/deployment.yaml", `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: server
+`)
+	writeFixture(t, dir, "base/kustomization.yaml", `resources:
+- deployment.yaml
+namePrefix: example-
+`)
+
+	objects, err := Fixtures([]string{filepath.Join(dir, "base")}, RunMetadata{Namespace: "test-ns"})
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "example-server", objects[0].GetName())
+	assert.Equal(t, "test-ns", objects[0].GetNamespace())
+}
+
+func TestFixtures_Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := Fixtures([]string{filepath.Join(dir, "missing.yaml")}, RunMetadata{Namespace: "test-ns"})
+	assert.ErrorContains(t, err, "failed to read fixture")
+
+	unnamed := writeFixture(t, dir, "unnamed.yaml", "apiVersion: v1\nkind: ConfigMap\n")
+	_, err = Fixtures([]string{unnamed}, RunMetadata{Namespace: "test-ns"})
+	assert.ErrorContains(t, err, "without metadata.name")
+
+	invalid := writeFixture(t, dir, "invalid.yaml", "apiVersion: [")
+	_, err = Fixtures([]string{invalid}, RunMetadata{Namespace: "test-ns"})
+	assert.ErrorContains(t, err, "failed to parse fixture")
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"testrunner/pkg/config"
 
@@ -12,8 +13,71 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// Job generates a job manifest
-func Job(cfg config.Config, namespace string) (*batchv1.Job, error) {
+const (
+	// TestRunnerContainerName is the name of the container running the test command
+	TestRunnerContainerName = "test-runner"
+	// ReportsCollectorContainerName is the name of the container keeping /reports available for collection
+	ReportsCollectorContainerName = "ket-reports"
+	// ReportsMountPath is where the reports volume is mounted in the test pod
+	ReportsMountPath = "/reports"
+	// ReportsCollectedMarker is created by ket once /reports has been copied back to the host
+	ReportsCollectedMarker = ReportsMountPath + "/.ket-collected"
+	// TestExitedMarker is created by the test container once the test command exits, when reports are collected
+	TestExitedMarker = ReportsMountPath + "/.ket-test-exited"
+	// ReportsCollectionGraceSeconds is how long the reports collector waits for ket after the test
+	// command exited, before it gives up so the pod can complete even if ket cannot release it
+	ReportsCollectionGraceSeconds = 300
+	// ShardIndexLabel records which shard a test runner job runs when the suite is sharded
+	ShardIndexLabel = "ket.io/shard-index"
+	// MatrixCellLabel records the index of the matrix cell a test runner job runs
+	MatrixCellLabel = "ket.io/matrix-cell"
+	// MatrixCellAnnotation describes the matrix cell a test runner job runs
+	MatrixCellAnnotation = "ket.io/matrix-cell"
+)
+
+// ShardCount returns the number of test runner jobs the suite is split across
+func ShardCount(cfg config.Config) int {
+	if cfg.Shards < 1 {
+		return 1
+	}
+	return int(cfg.Shards)
+}
+
+// Jobs generates one test runner job manifest per shard for the run's test namespace. When the
+// config has a matrix and no cell has been selected, every cell gets its own set of shards.
+func Jobs(cfg config.Config, run RunMetadata) ([]*batchv1.Job, error) {
+	if err := cfg.Matrix.Validate(); err != nil {
+		return nil, err
+	}
+
+	configs := []config.Config{cfg}
+	if cells := cfg.Matrix.Cells(); cfg.Cell == nil && len(cells) > 0 {
+		configs = configs[:0]
+		for _, cell := range cells {
+			configs = append(configs, cfg.WithCell(cell))
+		}
+	}
+
+	var jobs []*batchv1.Job
+	for _, cellCfg := range configs {
+		for shard := 0; shard < ShardCount(cellCfg); shard++ {
+			job, err := Job(cellCfg, run, shard)
+			if err != nil {
+				return nil, err
+			}
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// Job generates the test runner job manifest running the given shard in the run's test namespace
+func Job(cfg config.Config, run RunMetadata, shard int) (*batchv1.Job, error) {
+	namespace := run.Namespace
+	shards := ShardCount(cfg)
+	if shard < 0 || shard >= shards {
+		return nil, fmt.Errorf("shard %d is out of range for %d shards", shard, shards)
+	}
 	hostProjectRoot := filepath.Join(cfg.WorkspacePath, cfg.ProjectRoot)
 	if cfg.ProjectRoot == "." {
 		hostProjectRoot = cfg.WorkspacePath
@@ -24,6 +88,21 @@ func Job(cfg config.Config, namespace string) (*batchv1.Job, error) {
 		return nil, fmt.Errorf("failed to calculate working directory: %w", err)
 	}
 
+	source, sourceInitContainers, err := sourceVolume(cfg, hostProjectRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure source volume: %w", err)
+	}
+
+	customEnv, err := TestEnv(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure test environment: %w", err)
+	}
+
+	services, err := serviceContainers(cfg.Services)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure services: %w", err)
+	}
+
 	projectName := "project"
 	if cfg.ProjectRoot == "." {
 		if cwd, err := os.Getwd(); err == nil {
@@ -33,32 +112,55 @@ func Job(cfg config.Config, namespace string) (*batchv1.Job, error) {
 		projectName = filepath.Base(cfg.ProjectRoot)
 	}
 
+	name := fmt.Sprintf("ket-%s", projectName)
+	labels := run.Labels()
+	annotations := run.Annotations()
+	podLabels := map[string]string{}
+	var podAnnotations map[string]string
+	if cfg.Cell != nil {
+		name = fmt.Sprintf("%s-m%d", name, cfg.Cell.Index)
+		labels[MatrixCellLabel] = strconv.Itoa(cfg.Cell.Index)
+		podLabels[MatrixCellLabel] = strconv.Itoa(cfg.Cell.Index)
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[MatrixCellAnnotation] = cfg.Cell.Name()
+		podAnnotations = map[string]string{MatrixCellAnnotation: cfg.Cell.Name()}
+	}
+	if shards > 1 {
+		name = fmt.Sprintf("%s-shard-%d", name, shard)
+		labels[ShardIndexLabel] = strconv.Itoa(shard)
+		podLabels[ShardIndexLabel] = strconv.Itoa(shard)
+	}
+	if len(podLabels) == 0 {
+		podLabels = nil
+	}
+
 	job := &batchv1.Job{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "batch/v1",
 			Kind:       "Job",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("ket-%s", projectName),
-			Namespace: namespace,
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 		Spec: batchv1.JobSpec{
 			BackoffLimit:          &cfg.BackoffLimit,
 			ActiveDeadlineSeconds: &cfg.ActiveDeadlineS,
 			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      podLabels,
+					Annotations: podAnnotations,
+				},
 				Spec: corev1.PodSpec{
-					ServiceAccountName: "default",
+					ServiceAccountName: TestRunnerServiceAccountName,
 					RestartPolicy:      corev1.RestartPolicyNever,
+					InitContainers:     sourceInitContainers,
 					Volumes: []corev1.Volume{
-						{
-							Name: "source-code",
-							VolumeSource: corev1.VolumeSource{
-								HostPath: &corev1.HostPathVolumeSource{
-									Path: hostProjectRoot,
-									Type: &[]corev1.HostPathType{corev1.HostPathDirectory}[0],
-								},
-							},
-						},
+						source,
 						{
 							Name: "reports",
 							VolumeSource: corev1.VolumeSource{
@@ -68,7 +170,7 @@ func Job(cfg config.Config, namespace string) (*batchv1.Job, error) {
 					},
 					Containers: []corev1.Container{
 						{
-							Name:            "test-runner",
+							Name:            TestRunnerContainerName,
 							Image:           cfg.Image,
 							ImagePullPolicy: corev1.PullIfNotPresent,
 							Command: []string{
@@ -90,15 +192,20 @@ func Job(cfg config.Config, namespace string) (*batchv1.Job, error) {
 									Name:  "KET_WORKSPACE_PATH",
 									Value: cfg.WorkspacePath,
 								},
-							},
-							VolumeMounts: []corev1.VolumeMount{
 								{
-									Name:      "source-code",
-									MountPath: "/workspace",
+									Name:  "KET_SHARD_INDEX",
+									Value: strconv.Itoa(shard),
+								},
+								{
+									Name:  "KET_SHARD_TOTAL",
+									Value: strconv.Itoa(shards),
 								},
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								sourceVolumeMount(cfg),
 								{
 									Name:      "reports",
-									MountPath: "/reports",
+									MountPath: ReportsMountPath,
 								},
 							},
 						},
@@ -108,9 +215,90 @@ func Job(cfg config.Config, namespace string) (*batchv1.Job, error) {
 		},
 	}
 
+	podSpec := &job.Spec.Template.Spec
+	container := &podSpec.Containers[0]
+	container.Env = append(container.Env, testEnvRefs(customEnv)...)
+
+	volumes, mounts, envFrom := secretVolumes(cfg)
+	podSpec.Volumes = append(podSpec.Volumes, volumes...)
+	container.VolumeMounts = append(container.VolumeMounts, mounts...)
+	container.EnvFrom = envFrom
+
+	if cfg.Cell != nil {
+		container.Env = append(container.Env, corev1.EnvVar{Name: "KET_MATRIX_CELL", Value: cfg.Cell.Name()})
+		for _, env := range cfg.Cell.Env {
+			container.Env = append(container.Env, corev1.EnvVar{Name: env.Name, Value: env.Value})
+		}
+	}
+
+	if ReportsCollectionEnabled(cfg) {
+		container.Command[2] = signalTestExit(cfg.TestCommand)
+		podSpec.Containers = append(podSpec.Containers, reportsCollectorContainer(cfg.Image))
+	}
+
+	// Services start after the source is in place, as sidecars running alongside the test container
+	podSpec.InitContainers = append(podSpec.InitContainers, services...)
+
+	if cfg.RbacAudit.Enabled {
+		// Clients reading KUBECONFIG talk to the API server through the auditing proxy
+		podSpec.InitContainers = append(podSpec.InitContainers, rbacAuditContainer(cfg.RbacAudit.Image, namespace))
+		podSpec.Volumes = append(podSpec.Volumes, rbacAuditVolume())
+		container.VolumeMounts = append(container.VolumeMounts, rbacAuditVolumeMount())
+		container.Env = append(container.Env, corev1.EnvVar{Name: "KUBECONFIG", Value: RBACAuditKubeconfig})
+	}
+
+	if err := applyPodConfig(podSpec, cfg.Pod); err != nil {
+		return nil, fmt.Errorf("invalid pod config: %w", err)
+	}
+
 	return job, nil
 }
 
+// ReportsCollectionEnabled reports whether /reports is copied back to the host, either because a
+// reports directory was requested or because a results file has to be parsed
+func ReportsCollectionEnabled(cfg config.Config) bool {
+	return cfg.ReportsDir != "" || cfg.Results.Format != ""
+}
+
+// signalTestExit wraps the test command so it creates TestExitedMarker once it exits, keeping its
+// exit code. The command runs in a subshell so an exec in it does not skip the marker, and on lines of
+// its own so a trailing comment cannot swallow the rest.
+func signalTestExit(testCommand string) string {
+	return fmt.Sprintf("(\n%s\n)\nstatus=$?\ntouch %s\nexit $status", testCommand, TestExitedMarker)
+}
+
+// reportsCollectorContainer keeps the pod alive after the test container exits so ket can copy
+// /reports back to the host, and exits once ket marks the collection as done. If ket cannot, as when
+// it is not allowed to exec into the pod, the collector gives up ReportsCollectionGraceSeconds after
+// the test command exited rather than holding the pod until the job's deadline.
+func reportsCollectorContainer(image string) corev1.Container {
+	script := fmt.Sprintf(`waited=0
+until [ -f %s ]; do
+  if [ -f %s ]; then
+    waited=$((waited + 1))
+    if [ "$waited" -ge %d ]; then exit 0; fi
+  fi
+  sleep 1
+done`, ReportsCollectedMarker, TestExitedMarker, ReportsCollectionGraceSeconds)
+
+	return corev1.Container{
+		Name:            ReportsCollectorContainerName,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command: []string{
+			"/bin/sh",
+			"-c",
+			script,
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "reports",
+				MountPath: ReportsMountPath,
+			},
+		},
+	}
+}
+
 // calculateWorkingDirectory calculates the working directory for the test runner
 func calculateWorkingDirectory(projectRoot, workspacePath string) (string, error) {
 	if projectRoot == "." {
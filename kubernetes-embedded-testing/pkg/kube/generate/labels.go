@@ -0,0 +1,34 @@
+package generate
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// ManagedByLabel is the standard label identifying the tool that manages an object
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// ManagedByValue is the ManagedByLabel value set on every object ket creates
+	ManagedByValue = "ket"
+	// TestNamespaceLabel records the test namespace a run's objects belong to
+	TestNamespaceLabel = "ket.io/test-namespace"
+)
+
+// RunLabels returns the labels identifying objects owned by the run in the given test namespace
+func RunLabels(namespace string) map[string]string {
+	return map[string]string{
+		ManagedByLabel:     ManagedByValue,
+		TestNamespaceLabel: namespace,
+	}
+}
+
+// RunSelector returns a label selector matching only objects owned by the run in the given test namespace
+func RunSelector(namespace string) string {
+	return labels.SelectorFromSet(RunLabels(namespace)).String()
+}
+
+// TestRunnerRBACName returns the name of the per-run RBAC objects for the given test namespace
+func TestRunnerRBACName(namespace string) string {
+	return fmt.Sprintf("ket-test-runner-%s", namespace)
+}
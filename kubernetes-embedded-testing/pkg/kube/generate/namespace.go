@@ -5,15 +5,17 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// Namespace generates a namespace manifest
-func Namespace(namespace string) *corev1.Namespace {
+// Namespace generates the manifest for the run's test namespace
+func Namespace(run RunMetadata) *corev1.Namespace {
 	return &corev1.Namespace{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "v1",
 			Kind:       "Namespace",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: namespace,
+			Name:        run.Namespace,
+			Labels:      run.Labels(),
+			Annotations: run.Annotations(),
 		},
 	}
 }
@@ -0,0 +1,73 @@
+package generate
+
+import (
+	"fmt"
+
+	"testrunner/pkg/config"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// RunObjects holds every object ket creates for a run
+type RunObjects struct {
+	Namespace *corev1.Namespace
+	RunRBAC
+	Secrets  []*corev1.Secret
+	Fixtures []*unstructured.Unstructured
+	Jobs     []*batchv1.Job
+}
+
+// Objects generates every object of the run and applies the configured patches to them. Both
+// launching and rendering manifests start from these objects, so they always agree.
+func Objects(cfg config.Config, run RunMetadata) (*RunObjects, error) {
+	rules, err := RunRBACRules(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load RBAC rules: %w", err)
+	}
+
+	secrets, err := Secrets(cfg, run)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate secrets: %w", err)
+	}
+
+	fixtures, err := Fixtures(cfg.Fixtures, run)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load fixtures: %w", err)
+	}
+
+	jobs, err := Jobs(cfg, run)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate job manifest: %w", err)
+	}
+
+	objects := &RunObjects{
+		Namespace: Namespace(run),
+		RunRBAC:   RBAC(run, rules),
+		Secrets:   secrets,
+		Fixtures:  fixtures,
+		Jobs:      jobs,
+	}
+	if err := ApplyPatches(cfg.Patches, objects.List()); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// List returns the objects in the order they are created: the namespace, RBAC, secrets, fixtures and jobs
+func (o *RunObjects) List() []runtime.Object {
+	objects := []runtime.Object{o.Namespace}
+	objects = append(objects, o.RunRBAC.List()...)
+	for _, secret := range o.Secrets {
+		objects = append(objects, secret)
+	}
+	for _, fixture := range o.Fixtures {
+		objects = append(objects, fixture)
+	}
+	for _, job := range o.Jobs {
+		objects = append(objects, job)
+	}
+	return objects
+}
@@ -0,0 +1,153 @@
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+
+	"testrunner/pkg/config"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+// ApplyPatches applies the configured patches, in order, to every object their target selects.
+// A patch that selects no object, or that changes an object's kind, name or namespace, is an error.
+func ApplyPatches(patches []config.PatchConfig, objects []runtime.Object) error {
+	for i, patchCfg := range patches {
+		data, err := loadPatch(patchCfg)
+		if err != nil {
+			return fmt.Errorf("invalid patch %d: %w", i, err)
+		}
+
+		matched := false
+		for _, obj := range objects {
+			if !patchTargets(patchCfg.Target, obj) {
+				continue
+			}
+			matched = true
+			if err := patchObject(obj, patchCfg.Type, data); err != nil {
+				return fmt.Errorf("failed to apply patch %d to %s: %w", i, describeObject(obj), err)
+			}
+		}
+		if !matched {
+			return fmt.Errorf("patch %d matches no generated objects (kind %q, name %q)", i, patchCfg.Target.Kind, patchCfg.Target.Name)
+		}
+	}
+	return nil
+}
+
+// loadPatch returns the patch as JSON, converting it from YAML if needed
+func loadPatch(patchCfg config.PatchConfig) ([]byte, error) {
+	switch patchCfg.Type {
+	case "", config.PatchTypeStrategic, config.PatchTypeJSON:
+	default:
+		return nil, fmt.Errorf("unsupported patch type %q (expected %s or %s)", patchCfg.Type, config.PatchTypeStrategic, config.PatchTypeJSON)
+	}
+	if _, err := path.Match(patchCfg.Target.Name, ""); err != nil {
+		return nil, fmt.Errorf("invalid target name pattern %q: %w", patchCfg.Target.Name, err)
+	}
+
+	content := []byte(patchCfg.Patch)
+	switch {
+	case patchCfg.Patch != "" && patchCfg.Path != "":
+		return nil, fmt.Errorf("patch and path are mutually exclusive")
+	case patchCfg.Path != "":
+		var err error
+		content, err = os.ReadFile(patchCfg.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read patch file: %w", err)
+		}
+	case patchCfg.Patch == "":
+		return nil, fmt.Errorf("one of patch or path is required")
+	}
+
+	data, err := yaml.YAMLToJSON(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse patch: %w", err)
+	}
+	return data, nil
+}
+
+// patchTargets reports whether the target selects the object
+func patchTargets(target config.PatchTarget, obj runtime.Object) bool {
+	if target.Kind != "" && target.Kind != obj.GetObjectKind().GroupVersionKind().Kind {
+		return false
+	}
+	if target.Name != "" {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return false
+		}
+		if matched, _ := path.Match(target.Name, accessor.GetName()); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// patchObject patches the object in place. Strategic-merge patches need the object's Go type for
+// its merge keys, so objects of kinds unknown to the client scheme get a JSON merge patch instead.
+func patchObject(obj runtime.Object, patchType string, data []byte) error {
+	original, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+	if patchType == config.PatchTypeJSON {
+		jsonPatch, err := jsonpatch.DecodePatch(data)
+		if err != nil {
+			return fmt.Errorf("failed to decode JSON patch: %w", err)
+		}
+		patched, err = jsonPatch.Apply(original)
+		if err != nil {
+			return err
+		}
+	} else {
+		schema := obj
+		if _, ok := obj.(*unstructured.Unstructured); ok {
+			schema, _ = scheme.Scheme.New(obj.GetObjectKind().GroupVersionKind())
+		}
+		if schema != nil {
+			patched, err = strategicpatch.StrategicMergePatch(original, data, schema)
+		} else {
+			patched, err = jsonpatch.MergePatch(original, data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	before := describeObject(obj)
+	// Decode into a fresh value so fields the patch removed do not linger
+	value := reflect.ValueOf(obj).Elem()
+	value.Set(reflect.Zero(value.Type()))
+	if err := json.Unmarshal(patched, obj); err != nil {
+		return fmt.Errorf("failed to decode patched object: %w", err)
+	}
+	if after := describeObject(obj); after != before {
+		return fmt.Errorf("patches must not change the kind, name or namespace, got %s", after)
+	}
+	return nil
+}
+
+// describeObject identifies an object by its kind, namespace and name
+func describeObject(obj runtime.Object) string {
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return kind
+	}
+	if accessor.GetNamespace() == "" {
+		return fmt.Sprintf("%s %s", kind, accessor.GetName())
+	}
+	return fmt.Sprintf("%s %s/%s", kind, accessor.GetNamespace(), accessor.GetName())
+}
@@ -0,0 +1,201 @@
+package generate
+
+import (
+	"fmt"
+	"strings"
+
+	"testrunner/pkg/config"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// helperContainers are the containers ket adds to the test pod next to the test container
+var helperContainers = map[string]bool{
+	ReportsCollectorContainerName: true,
+	SourceUploadContainerName:     true,
+	SourceGitContainerName:        true,
+	RBACAuditContainerName:        true,
+}
+
+// helperResources are the fixed requests and limits of ket's helper containers. Every container gets
+// both, so the pod is admitted in namespaces whose LimitRange or ResourceQuota requires them.
+var helperResources = corev1.ResourceRequirements{
+	Requests: corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("10m"),
+		corev1.ResourceMemory: resource.MustParse("32Mi"),
+	},
+	Limits: corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("500m"),
+		corev1.ResourceMemory: resource.MustParse("256Mi"),
+	},
+}
+
+// applyPodConfig customizes the test pod with the configured resources, scheduling constraints and
+// security contexts. The configured resources apply to the test container, while ket's helper
+// containers get helperResources. The container security context applies to every container ket runs
+// in the pod, so the pod passes Pod Security Admission as a whole.
+func applyPodConfig(spec *corev1.PodSpec, pod config.PodConfig) error {
+	resources, err := resourceRequirements(pod.Resources)
+	if err != nil {
+		return err
+	}
+	for i := range spec.InitContainers {
+		if helperContainers[spec.InitContainers[i].Name] {
+			spec.InitContainers[i].Resources = *helperResources.DeepCopy()
+		}
+	}
+	for i := range spec.Containers {
+		switch {
+		case spec.Containers[i].Name == TestRunnerContainerName:
+			spec.Containers[i].Resources = resources
+		case helperContainers[spec.Containers[i].Name]:
+			spec.Containers[i].Resources = *helperResources.DeepCopy()
+		}
+	}
+
+	nodeSelector, err := nodeSelector(pod.NodeSelector)
+	if err != nil {
+		return err
+	}
+	spec.NodeSelector = nodeSelector
+
+	if err := validateTolerations(pod.Tolerations); err != nil {
+		return err
+	}
+	for _, toleration := range pod.Tolerations {
+		spec.Tolerations = append(spec.Tolerations, *toleration.DeepCopy())
+	}
+
+	if pod.Affinity != nil {
+		spec.Affinity = pod.Affinity.DeepCopy()
+	}
+
+	if pod.PriorityClassName != "" {
+		if errs := validation.IsDNS1123Subdomain(pod.PriorityClassName); len(errs) > 0 {
+			return fmt.Errorf("invalid priority class name %q: %s", pod.PriorityClassName, strings.Join(errs, ", "))
+		}
+		spec.PriorityClassName = pod.PriorityClassName
+	}
+
+	for _, name := range pod.ImagePullSecrets {
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return fmt.Errorf("invalid image pull secret name %q: %s", name, strings.Join(errs, ", "))
+		}
+		spec.ImagePullSecrets = append(spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+	}
+
+	if pod.SecurityContext != nil {
+		spec.SecurityContext = pod.SecurityContext.DeepCopy()
+	}
+	if pod.ContainerSecurityContext != nil {
+		for i := range spec.InitContainers {
+			spec.InitContainers[i].SecurityContext = pod.ContainerSecurityContext.DeepCopy()
+		}
+		for i := range spec.Containers {
+			spec.Containers[i].SecurityContext = pod.ContainerSecurityContext.DeepCopy()
+		}
+	}
+
+	return nil
+}
+
+// resourceRequirements parses the configured requests and limits, rejecting requests above their limit
+func resourceRequirements(cfg config.ResourcesConfig) (corev1.ResourceRequirements, error) {
+	requests, err := resourceList(cfg.Requests)
+	if err != nil {
+		return corev1.ResourceRequirements{}, fmt.Errorf("invalid resource requests: %w", err)
+	}
+	limits, err := resourceList(cfg.Limits)
+	if err != nil {
+		return corev1.ResourceRequirements{}, fmt.Errorf("invalid resource limits: %w", err)
+	}
+
+	for name, request := range requests {
+		if limit, ok := limits[name]; ok && request.Cmp(limit) > 0 {
+			return corev1.ResourceRequirements{}, fmt.Errorf("%s request %s exceeds its limit %s", name, request.String(), limit.String())
+		}
+	}
+	return corev1.ResourceRequirements{Requests: requests, Limits: limits}, nil
+}
+
+// resourceList parses the quantities that are set, returning nil when none are
+func resourceList(cfg config.ResourceListConfig) (corev1.ResourceList, error) {
+	quantities := map[corev1.ResourceName]string{
+		corev1.ResourceCPU:              cfg.CPU,
+		corev1.ResourceMemory:           cfg.Memory,
+		corev1.ResourceEphemeralStorage: cfg.EphemeralStorage,
+	}
+
+	var list corev1.ResourceList
+	for name, value := range quantities {
+		if value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q: %w", name, value, err)
+		}
+		if quantity.Sign() < 0 {
+			return nil, fmt.Errorf("%s %q must not be negative", name, value)
+		}
+		if list == nil {
+			list = corev1.ResourceList{}
+		}
+		list[name] = quantity
+	}
+	return list, nil
+}
+
+// nodeSelector parses key=value node labels
+func nodeSelector(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	selector := map[string]string{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid node selector %q: expected key=value", pair)
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid node selector key %q: %s", key, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid node selector value %q: %s", value, strings.Join(errs, ", "))
+		}
+		selector[key] = value
+	}
+	return selector, nil
+}
+
+// validateTolerations applies the API server's toleration rules so mistakes surface before the job is created
+func validateTolerations(tolerations []corev1.Toleration) error {
+	for i, toleration := range tolerations {
+		switch toleration.Operator {
+		case "", corev1.TolerationOpEqual:
+		case corev1.TolerationOpExists:
+			if toleration.Value != "" {
+				return fmt.Errorf("toleration %d: value must be empty when operator is Exists", i)
+			}
+		default:
+			return fmt.Errorf("toleration %d: unsupported operator %q (expected Equal or Exists)", i, toleration.Operator)
+		}
+
+		if toleration.Key == "" && toleration.Operator != corev1.TolerationOpExists {
+			return fmt.Errorf("toleration %d: operator must be Exists when key is empty", i)
+		}
+
+		switch toleration.Effect {
+		case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return fmt.Errorf("toleration %d: unsupported effect %q", i, toleration.Effect)
+		}
+		if toleration.TolerationSeconds != nil && toleration.Effect != corev1.TaintEffectNoExecute {
+			return fmt.Errorf("toleration %d: tolerationSeconds requires the NoExecute effect", i)
+		}
+	}
+	return nil
+}
@@ -1,11 +1,20 @@
 package generate
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
-// GetTestRunnerRBACRules returns the RBAC rules for the test runner ClusterRole
+const (
+	// TestRunnerServiceAccountName is the ServiceAccount the test pods run as
+	TestRunnerServiceAccountName = "ket-runner"
+	// TestRunnerRoleName names the Role and RoleBinding granting the test runner its rules in the test namespace
+	TestRunnerRoleName = "ket-test-runner"
+)
+
+// GetTestRunnerRBACRules returns the default RBAC rules of the test runner in its test namespace
 func GetTestRunnerRBACRules() []rbacv1.PolicyRule {
 	return []rbacv1.PolicyRule{
 		{
@@ -39,50 +48,156 @@ func GetTestRunnerRBACRules() []rbacv1.PolicyRule {
 			Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
 		},
 		{
-			APIGroups: []string{""}, // The core API group for Namespaces
+			// Bound in the test namespace, this only covers the test namespace itself
+			APIGroups: []string{""},
 			Resources: []string{"namespaces"},
 			Verbs:     []string{"get", "delete"},
 		},
 	}
 }
 
-// ClusterRole generates a ClusterRole manifest
-func ClusterRole(additionalRules ...rbacv1.PolicyRule) *rbacv1.ClusterRole {
-	rules := MergeRBACRules(GetTestRunnerRBACRules(), additionalRules)
+// RunRBAC holds the RBAC objects of a run. The test pods run as a dedicated ServiceAccount whose rules
+// are bound in the test namespace only. ClusterRole and ClusterRoleBinding are nil unless rules are
+// marked as cluster-wide.
+type RunRBAC struct {
+	ServiceAccount     *corev1.ServiceAccount
+	Role               *rbacv1.Role
+	RoleBinding        *rbacv1.RoleBinding
+	ClusterRole        *rbacv1.ClusterRole
+	ClusterRoleBinding *rbacv1.ClusterRoleBinding
+}
+
+// RBAC generates the RBAC objects of the run, granting the rules in the test namespace and the
+// cluster-wide rules across the cluster
+func RBAC(run RunMetadata, rules RBACRules) RunRBAC {
+	rbac := RunRBAC{
+		ServiceAccount: ServiceAccount(run),
+		Role:           Role(run, rules.Rules...),
+		RoleBinding:    RoleBinding(run),
+	}
+	if len(rules.ClusterRules) > 0 {
+		rbac.ClusterRole = ClusterRole(run, rules.ClusterRules...)
+		rbac.ClusterRoleBinding = ClusterRoleBinding(run)
+	}
+	return rbac
+}
+
+// List returns the RBAC objects in the order they are created, leaving out the cluster-wide ones when there are none
+func (r RunRBAC) List() []runtime.Object {
+	objects := []runtime.Object{r.ServiceAccount, r.Role, r.RoleBinding}
+	if r.ClusterRole != nil {
+		objects = append(objects, r.ClusterRole, r.ClusterRoleBinding)
+	}
+	return objects
+}
 
+// Rules returns the rules the RBAC objects grant, in the test namespace and cluster-wide
+func (r RunRBAC) Rules() RBACRules {
+	rules := RBACRules{Rules: r.Role.Rules}
+	if r.ClusterRole != nil {
+		rules.ClusterRules = r.ClusterRole.Rules
+	}
+	return rules
+}
+
+// ServiceAccount generates the ServiceAccount the test pods run as
+func ServiceAccount(run RunMetadata) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ServiceAccount",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        TestRunnerServiceAccountName,
+			Namespace:   run.Namespace,
+			Labels:      run.Labels(),
+			Annotations: run.Annotations(),
+		},
+	}
+}
+
+// Role generates the test runner's Role in the run's test namespace
+func Role(run RunMetadata, rules ...rbacv1.PolicyRule) *rbacv1.Role {
+	return &rbacv1.Role{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       "Role",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        TestRunnerRoleName,
+			Namespace:   run.Namespace,
+			Labels:      run.Labels(),
+			Annotations: run.Annotations(),
+		},
+		Rules: rules,
+	}
+}
+
+// RoleBinding generates the RoleBinding granting the test runner's Role to its ServiceAccount
+func RoleBinding(run RunMetadata) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       "RoleBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        TestRunnerRoleName,
+			Namespace:   run.Namespace,
+			Labels:      run.Labels(),
+			Annotations: run.Annotations(),
+		},
+		Subjects: []rbacv1.Subject{testRunnerSubject(run.Namespace)},
+		RoleRef: rbacv1.RoleRef{
+			Kind:     "Role",
+			Name:     TestRunnerRoleName,
+			APIGroup: "rbac.authorization.k8s.io",
+		},
+	}
+}
+
+// ClusterRole generates the per-run ClusterRole manifest holding the rules marked as cluster-wide
+func ClusterRole(run RunMetadata, rules ...rbacv1.PolicyRule) *rbacv1.ClusterRole {
 	return &rbacv1.ClusterRole{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "rbac.authorization.k8s.io/v1",
 			Kind:       "ClusterRole",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: "ket-test-runner",
+			Name:        TestRunnerRBACName(run.Namespace),
+			Labels:      run.Labels(),
+			Annotations: run.Annotations(),
 		},
 		Rules: rules,
 	}
 }
 
-// ClusterRoleBinding generates a ClusterRoleBinding manifest
-func ClusterRoleBinding(namespace string) *rbacv1.ClusterRoleBinding {
+// ClusterRoleBinding generates the per-run ClusterRoleBinding manifest for the run's test namespace
+func ClusterRoleBinding(run RunMetadata) *rbacv1.ClusterRoleBinding {
+	namespace := run.Namespace
 	return &rbacv1.ClusterRoleBinding{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "rbac.authorization.k8s.io/v1",
 			Kind:       "ClusterRoleBinding",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: "ket-test-runner",
-		},
-		Subjects: []rbacv1.Subject{
-			{
-				Kind:      "ServiceAccount",
-				Name:      "default",
-				Namespace: namespace,
-			},
+			Name:        TestRunnerRBACName(namespace),
+			Labels:      run.Labels(),
+			Annotations: run.Annotations(),
 		},
+		Subjects: []rbacv1.Subject{testRunnerSubject(namespace)},
 		RoleRef: rbacv1.RoleRef{
 			Kind:     "ClusterRole",
-			Name:     "ket-test-runner",
+			Name:     TestRunnerRBACName(namespace),
 			APIGroup: "rbac.authorization.k8s.io",
 		},
 	}
 }
+
+// testRunnerSubject is the test runner's ServiceAccount in the given test namespace
+func testRunnerSubject(namespace string) rbacv1.Subject {
+	return rbacv1.Subject{
+		Kind:      "ServiceAccount",
+		Name:      TestRunnerServiceAccountName,
+		Namespace: namespace,
+	}
+}
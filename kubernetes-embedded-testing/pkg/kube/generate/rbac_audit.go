@@ -0,0 +1,439 @@
+package generate
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// RBACAuditContainerName is the sidecar proxying the test runner's API requests when RBAC is audited
+	RBACAuditContainerName = "ket-rbac-audit"
+	// RBACAuditMountPath is where the volume holding the kubeconfig of the auditing proxy is mounted
+	RBACAuditMountPath = "/ket/rbac-audit"
+	// RBACAuditKubeconfig is the kubeconfig pointing the test container at the auditing proxy
+	RBACAuditKubeconfig = RBACAuditMountPath + "/kubeconfig"
+	// RBACAuditProxyPort is the port the auditing proxy listens on, on the pod's loopback interface
+	RBACAuditProxyPort = 8001
+	// DefaultRBACAuditImage is the image running the auditing proxy when none is configured
+	DefaultRBACAuditImage = "bitnami/kubectl:latest"
+	// rbacAuditVolumeName is the name of the volume holding the kubeconfig of the auditing proxy
+	rbacAuditVolumeName = "ket-rbac-audit"
+)
+
+// rbacAuditContainer runs kubectl proxy as a native sidecar in front of the API server. The proxy
+// authenticates as the pod's ServiceAccount and logs every request it forwards, and the kubeconfig it
+// writes first points the test container's clients at it. Its startup probe holds back the test
+// container until the proxy is listening.
+func rbacAuditContainer(image, namespace string) corev1.Container {
+	if image == "" {
+		image = DefaultRBACAuditImage
+	}
+
+	// The proxy only listens on the pod's loopback interface, so its request filter is not needed,
+	// and it would reject the exec and attach requests of the tests
+	script := fmt.Sprintf(`cat > %s <<'EOF'
+apiVersion: v1
+kind: Config
+clusters:
+- name: ket-rbac-audit
+  cluster:
+    server: http://127.0.0.1:%d
+contexts:
+- name: ket-rbac-audit
+  context:
+    cluster: ket-rbac-audit
+    namespace: %s
+current-context: ket-rbac-audit
+EOF
+exec kubectl proxy --address=127.0.0.1 --port=%d --disable-filter --v=6`, RBACAuditKubeconfig, RBACAuditProxyPort, namespace, RBACAuditProxyPort)
+
+	restartPolicy := corev1.ContainerRestartPolicyAlways
+	return corev1.Container{
+		Name:            RBACAuditContainerName,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command: []string{
+			"/bin/sh",
+			"-c",
+			script,
+		},
+		RestartPolicy: &restartPolicy,
+		StartupProbe: &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(RBACAuditProxyPort)},
+			},
+			PeriodSeconds:    1,
+			FailureThreshold: 60,
+		},
+		VolumeMounts: []corev1.VolumeMount{rbacAuditVolumeMount()},
+	}
+}
+
+// rbacAuditVolume holds the kubeconfig the auditing proxy writes for the test container
+func rbacAuditVolume() corev1.Volume {
+	return corev1.Volume{
+		Name: rbacAuditVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	}
+}
+
+// rbacAuditVolumeMount mounts the volume holding the kubeconfig of the auditing proxy
+func rbacAuditVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      rbacAuditVolumeName,
+		MountPath: RBACAuditMountPath,
+	}
+}
+
+// AuditedRequest is an API request the test runner made through the auditing proxy, described by the
+// attributes RBAC authorizes it with. Resource includes the subresource, as in pods/log.
+type AuditedRequest struct {
+	Verb           string
+	APIGroup       string
+	Resource       string
+	Name           string
+	Namespace      string
+	NonResourceURL string
+	// Status is the HTTP status code the API server answered with
+	Status int
+}
+
+// Denied reports whether the API server refused the request for lack of permissions
+func (r AuditedRequest) Denied() bool {
+	return r.Status == http.StatusForbidden
+}
+
+func (r AuditedRequest) String() string {
+	if r.NonResourceURL != "" {
+		return fmt.Sprintf("%s %s", r.Verb, r.NonResourceURL)
+	}
+	resource := r.Resource
+	if r.APIGroup != "" {
+		resource = fmt.Sprintf("%s.%s", r.Resource, r.APIGroup)
+	}
+	if r.Name != "" {
+		resource = fmt.Sprintf("%s/%s", resource, r.Name)
+	}
+	if r.Namespace != "" {
+		return fmt.Sprintf("%s %s in namespace %s", r.Verb, resource, r.Namespace)
+	}
+	return fmt.Sprintf("%s %s", r.Verb, resource)
+}
+
+// rbacAuditLogPatterns match the requests kubectl proxy logs at -v=6, capturing their method, URL and
+// status code. Newer kubectl versions log structured key-value pairs, older ones a plain line.
+var rbacAuditLogPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`verb="([A-Z]+)" url="([^"]+)" status="(\d{3})`),
+	regexp.MustCompile(`\] ([A-Z]+) (https?://\S+) (\d{3})\b`),
+}
+
+// ParseRBACAuditLog reads the requests logged by the auditing proxy, skipping every other line
+func ParseRBACAuditLog(reader io.Reader) ([]AuditedRequest, error) {
+	var requests []AuditedRequest
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		for _, pattern := range rbacAuditLogPatterns {
+			match := pattern.FindStringSubmatch(scanner.Text())
+			if match == nil {
+				continue
+			}
+			request, err := auditedRequest(match[1], match[2])
+			if err != nil {
+				break
+			}
+			request.Status, _ = strconv.Atoi(match[3])
+			requests = append(requests, request)
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read RBAC audit log: %w", err)
+	}
+	return requests, nil
+}
+
+// namespaceSubresources are the subresources of a namespace, as opposed to the resources in it
+var namespaceSubresources = map[string]bool{"status": true, "finalize": true}
+
+// auditedRequest works out the RBAC attributes of a request the way the API server does: paths under
+// /api and /apis address resources, anything else is a non-resource URL
+func auditedRequest(method, rawURL string) (AuditedRequest, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return AuditedRequest{}, err
+	}
+	path := strings.Trim(parsed.Path, "/")
+	parts := strings.Split(path, "/")
+
+	var request AuditedRequest
+	switch {
+	case len(parts) >= 3 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 4 && parts[0] == "apis":
+		request.APIGroup = parts[1]
+		parts = parts[3:]
+	default:
+		return AuditedRequest{Verb: strings.ToLower(method), NonResourceURL: "/" + path}, nil
+	}
+
+	watch := false
+	if parts[0] == "watch" {
+		// Deprecated watch paths, as in /api/v1/watch/pods
+		watch = true
+		parts = parts[1:]
+	}
+	if len(parts) > 1 && parts[0] == "namespaces" {
+		request.Namespace = parts[1]
+		if len(parts) > 2 && !namespaceSubresources[parts[2]] {
+			parts = parts[2:]
+		}
+	}
+	if len(parts) == 0 {
+		return AuditedRequest{}, fmt.Errorf("no resource in %s", rawURL)
+	}
+	request.Resource = parts[0]
+	if len(parts) > 1 {
+		request.Name = parts[1]
+	}
+	if len(parts) > 2 {
+		request.Resource += "/" + parts[2]
+	}
+
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		switch {
+		case watch || parsed.Query().Get("watch") == "true" || parsed.Query().Get("watch") == "1":
+			request.Verb = "watch"
+		case request.Name == "":
+			request.Verb = "list"
+		default:
+			request.Verb = "get"
+		}
+	case http.MethodPost:
+		request.Verb = "create"
+	case http.MethodPut:
+		request.Verb = "update"
+	case http.MethodPatch:
+		request.Verb = "patch"
+	case http.MethodDelete:
+		if request.Name == "" {
+			request.Verb = "deletecollection"
+		} else {
+			request.Verb = "delete"
+		}
+	default:
+		request.Verb = strings.ToLower(method)
+	}
+	return request, nil
+}
+
+// RBACAuditReport compares the requests of an audited run with the rules it was granted
+type RBACAuditReport struct {
+	// Requests is the number of audited requests
+	Requests int
+	// Denied are the requests the test runner was not allowed to make
+	Denied []AuditedRequest
+	// Suggested are the fewest rules allowing every audited request, in the test namespace where they
+	// were made there and cluster-wide otherwise
+	Suggested RBACRules
+	// Unused are the granted rules no audited request needed
+	Unused RBACRules
+}
+
+// AuditRBAC reports the rules the requests made in the given test namespace needed, and which of the
+// granted rules went unused
+func AuditRBAC(namespace string, granted RBACRules, requests []AuditedRequest) RBACAuditReport {
+	report := RBACAuditReport{Requests: len(requests)}
+
+	var namespaced, clusterWide []AuditedRequest
+	for _, request := range requests {
+		if request.Denied() {
+			report.Denied = append(report.Denied, request)
+		}
+		if request.NonResourceURL == "" && request.Namespace == namespace {
+			namespaced = append(namespaced, request)
+		} else {
+			clusterWide = append(clusterWide, request)
+		}
+	}
+	report.Suggested = RBACRules{
+		Rules:        minimalRBACRules(namespaced),
+		ClusterRules: minimalRBACRules(clusterWide),
+	}
+
+	for _, rule := range granted.Rules {
+		if !anyRequestAllowed(rule, namespaced) {
+			report.Unused.Rules = append(report.Unused.Rules, rule)
+		}
+	}
+	for _, rule := range granted.ClusterRules {
+		if !anyRequestAllowed(rule, requests) {
+			report.Unused.ClusterRules = append(report.Unused.ClusterRules, rule)
+		}
+	}
+	return report
+}
+
+// minimalRBACRules returns the rules allowing exactly the verbs each resource or non-resource URL was
+// requested with, listing resources of the same API group that need the same verbs in a single rule
+func minimalRBACRules(requests []AuditedRequest) []rbacv1.PolicyRule {
+	type target struct{ group, resource, nonResourceURL string }
+	verbs := map[target][]string{}
+	var targets []target
+	for _, request := range requests {
+		key := target{request.APIGroup, request.Resource, request.NonResourceURL}
+		if _, ok := verbs[key]; !ok {
+			targets = append(targets, key)
+		}
+		if !slices.Contains(verbs[key], request.Verb) {
+			verbs[key] = append(verbs[key], request.Verb)
+		}
+	}
+	slices.SortFunc(targets, func(a, b target) int {
+		// Rules for resources come before those for non-resource URLs
+		if (a.nonResourceURL == "") != (b.nonResourceURL == "") {
+			return strings.Compare(a.nonResourceURL, b.nonResourceURL)
+		}
+		return cmp.Or(
+			strings.Compare(a.group, b.group),
+			strings.Compare(a.resource, b.resource),
+			strings.Compare(a.nonResourceURL, b.nonResourceURL),
+		)
+	})
+
+	var rules []rbacv1.PolicyRule
+	index := map[string]int{}
+	for _, target := range targets {
+		targetVerbs := verbs[target]
+		slices.Sort(targetVerbs)
+		key := fmt.Sprintf("%t|%s|%s", target.nonResourceURL != "", target.group, strings.Join(targetVerbs, ","))
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(rules)
+			rule := rbacv1.PolicyRule{Verbs: targetVerbs}
+			if target.nonResourceURL == "" {
+				rule.APIGroups = []string{target.group}
+			}
+			rules = append(rules, rule)
+			i = len(rules) - 1
+		}
+		if target.nonResourceURL != "" {
+			rules[i].NonResourceURLs = append(rules[i].NonResourceURLs, target.nonResourceURL)
+		} else {
+			rules[i].Resources = append(rules[i].Resources, target.resource)
+		}
+	}
+	return rules
+}
+
+// anyRequestAllowed reports whether the rule allows at least one of the requests
+func anyRequestAllowed(rule rbacv1.PolicyRule, requests []AuditedRequest) bool {
+	return slices.ContainsFunc(requests, func(request AuditedRequest) bool {
+		return ruleAllows(rule, request)
+	})
+}
+
+// ruleAllows reports whether the rule allows the request, following the API server's wildcards
+func ruleAllows(rule rbacv1.PolicyRule, request AuditedRequest) bool {
+	if !matchesValue(rule.Verbs, request.Verb) {
+		return false
+	}
+
+	if request.NonResourceURL != "" {
+		return slices.ContainsFunc(rule.NonResourceURLs, func(allowed string) bool {
+			if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+				return strings.HasPrefix(request.NonResourceURL, prefix)
+			}
+			return allowed == request.NonResourceURL
+		})
+	}
+
+	if !matchesValue(rule.APIGroups, request.APIGroup) {
+		return false
+	}
+	_, subresource, _ := strings.Cut(request.Resource, "/")
+	resourceAllowed := slices.ContainsFunc(rule.Resources, func(allowed string) bool {
+		return allowed == rbacv1.ResourceAll || allowed == request.Resource ||
+			(subresource != "" && allowed == rbacv1.ResourceAll+"/"+subresource)
+	})
+	if !resourceAllowed {
+		return false
+	}
+	return len(rule.ResourceNames) == 0 || slices.Contains(rule.ResourceNames, request.Name)
+}
+
+// matchesValue reports whether values hold the value or the RBAC wildcard
+func matchesValue(values []string, value string) bool {
+	return slices.Contains(values, "*") || slices.Contains(values, value)
+}
+
+// UncoveredRules returns the parts of rules that none of the held rules allow, each as a rule with a
+// single verb, API group, resource and resource name, or a single verb and non-resource URL. The API
+// server refuses to create a Role granting any of them unless the creator may escalate.
+func UncoveredRules(held, rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	var uncovered []rbacv1.PolicyRule
+	for _, rule := range rules {
+		for _, request := range ruleRequests(rule) {
+			if anyRuleAllows(held, request) {
+				continue
+			}
+			part := rbacv1.PolicyRule{Verbs: []string{request.Verb}}
+			if request.NonResourceURL != "" {
+				part.NonResourceURLs = []string{request.NonResourceURL}
+			} else {
+				part.APIGroups = []string{request.APIGroup}
+				part.Resources = []string{request.Resource}
+				if request.Name != "" {
+					part.ResourceNames = []string{request.Name}
+				}
+			}
+			uncovered = append(uncovered, part)
+		}
+	}
+	return uncovered
+}
+
+// ruleRequests breaks a rule down into the requests it allows, one per combination of its verbs,
+// API groups, resources and resource names. Wildcards are kept, so only a wildcard allows them.
+func ruleRequests(rule rbacv1.PolicyRule) []AuditedRequest {
+	var requests []AuditedRequest
+	for _, verb := range rule.Verbs {
+		for _, url := range rule.NonResourceURLs {
+			requests = append(requests, AuditedRequest{Verb: verb, NonResourceURL: url})
+		}
+		names := rule.ResourceNames
+		if len(names) == 0 {
+			names = []string{""}
+		}
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				for _, name := range names {
+					requests = append(requests, AuditedRequest{Verb: verb, APIGroup: group, Resource: resource, Name: name})
+				}
+			}
+		}
+	}
+	return requests
+}
+
+// anyRuleAllows reports whether at least one of the rules allows the request
+func anyRuleAllows(rules []rbacv1.PolicyRule, request AuditedRequest) bool {
+	return slices.ContainsFunc(rules, func(rule rbacv1.PolicyRule) bool {
+		return ruleAllows(rule, request)
+	})
+}
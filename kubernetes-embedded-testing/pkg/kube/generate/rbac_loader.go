@@ -1,46 +1,214 @@
 package generate
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	rbacv1 "k8s.io/api/rbac/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 	"sigs.k8s.io/yaml"
 )
 
-// RBACRulesFile represents the structure of an RBAC rules YAML file
+// ClusterWideAnnotation marks a Role or ClusterRole document in an RBAC file whose rules are granted
+// across the cluster rather than in the test namespace only
+const ClusterWideAnnotation = "ket.io/cluster-wide"
+
+// RBACRulesFile represents the structure of an RBAC rules YAML file. Rules are granted in the test
+// namespace only, and clusterRules across the whole cluster.
 type RBACRulesFile struct {
-	Rules []rbacv1.PolicyRule `yaml:"rules" json:"rules"`
+	Rules        []rbacv1.PolicyRule `yaml:"rules,omitempty" json:"rules,omitempty"`
+	ClusterRules []rbacv1.PolicyRule `yaml:"clusterRules,omitempty" json:"clusterRules,omitempty"`
+}
+
+// RBACRules are the additional rules granted to the test runner, in its test namespace and cluster-wide
+type RBACRules struct {
+	Rules        []rbacv1.PolicyRule
+	ClusterRules []rbacv1.PolicyRule
+}
+
+// LoadRBACRules loads the additional RBAC rules from every file matching the given paths or glob
+// patterns, in order, merging rules that differ only in their verbs
+func LoadRBACRules(patterns []string) (RBACRules, error) {
+	var rules RBACRules
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		files, err := rbacFiles(pattern)
+		if err != nil {
+			return RBACRules{}, err
+		}
+		for _, file := range files {
+			loaded, err := LoadRBACRulesFromFile(file)
+			if err != nil {
+				return RBACRules{}, err
+			}
+			rules.Rules = append(rules.Rules, loaded.Rules...)
+			rules.ClusterRules = append(rules.ClusterRules, loaded.ClusterRules...)
+		}
+	}
+	return RBACRules{
+		Rules:        MergeRBACRules(nil, rules.Rules),
+		ClusterRules: MergeRBACRules(nil, rules.ClusterRules),
+	}, nil
 }
 
-// LoadRBACRulesFromFile loads additional RBAC rules from a YAML file
-func LoadRBACRulesFromFile(filepath string) ([]rbacv1.PolicyRule, error) {
+// rbacFiles returns the files a path or glob pattern selects, failing when it selects none
+func rbacFiles(pattern string) ([]string, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RBAC file pattern %q: %w", pattern, err)
+	}
+	if len(files) == 0 {
+		if !strings.ContainsAny(pattern, `*?[\`) {
+			_, err := os.Stat(pattern)
+			return nil, fmt.Errorf("failed to read RBAC file %s: %w", pattern, err)
+		}
+		return nil, fmt.Errorf("RBAC file pattern %q matches no files", pattern)
+	}
+	return files, nil
+}
+
+// LoadRBACRulesFromFile loads additional RBAC rules from a YAML file. Each document of the file is a
+// list of rules, a rules file with rules and clusterRules, or a Role or ClusterRole. The rules of a
+// Role or ClusterRole are granted in the test namespace unless it carries ClusterWideAnnotation.
+func LoadRBACRulesFromFile(filepath string) (RBACRules, error) {
 	if filepath == "" {
-		return nil, nil
+		return RBACRules{}, nil
 	}
 
 	data, err := os.ReadFile(filepath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read RBAC file %s: %w", filepath, err)
+		return RBACRules{}, fmt.Errorf("failed to read RBAC file %s: %w", filepath, err)
 	}
 
-	var rbacFile RBACRulesFile
-	if err := yaml.Unmarshal(data, &rbacFile); err != nil {
-		return nil, fmt.Errorf("failed to parse RBAC file %s: %w", filepath, err)
+	var rules RBACRules
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for index := 0; ; index++ {
+		document, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return RBACRules{}, fmt.Errorf("failed to parse RBAC file %s: %w", filepath, err)
+		}
+
+		loaded, err := parseRBACDocument(document)
+		if err != nil {
+			return RBACRules{}, fmt.Errorf("failed to parse RBAC file %s, document %d: %w", filepath, index+1, err)
+		}
+		rules.Rules = append(rules.Rules, loaded.Rules...)
+		rules.ClusterRules = append(rules.ClusterRules, loaded.ClusterRules...)
 	}
 
-	return rbacFile.Rules, nil
+	for _, rule := range rules.Rules {
+		if len(rule.NonResourceURLs) > 0 {
+			return RBACRules{}, fmt.Errorf("invalid RBAC file %s: nonResourceURLs %v can only be granted cluster-wide", filepath, rule.NonResourceURLs)
+		}
+	}
+	return rules, nil
 }
 
-// MergeRBACRules merges default RBAC rules with additional rules from a file
+// parseRBACDocument reads the rules of a single YAML document of an RBAC file
+func parseRBACDocument(document []byte) (RBACRules, error) {
+	trimmed := bytes.TrimSpace(document)
+	if len(trimmed) == 0 || string(trimmed) == "null" {
+		return RBACRules{}, nil
+	}
+
+	data, err := yaml.YAMLToJSON(trimmed)
+	if err != nil {
+		return RBACRules{}, err
+	}
+	if data[0] == '[' {
+		// A YAML list is a plain list of rules
+		var list []rbacv1.PolicyRule
+		if err := yaml.UnmarshalStrict(trimmed, &list); err != nil {
+			return RBACRules{}, err
+		}
+		return RBACRules{Rules: list}, nil
+	}
+
+	var typeMeta struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+	}
+	if err := yaml.Unmarshal(data, &typeMeta); err != nil {
+		return RBACRules{}, fmt.Errorf("expected a list of rules or an object: %w", err)
+	}
+
+	switch typeMeta.Kind {
+	case "":
+		var file RBACRulesFile
+		if err := yaml.UnmarshalStrict(trimmed, &file); err != nil {
+			return RBACRules{}, err
+		}
+		return RBACRules{Rules: file.Rules, ClusterRules: file.ClusterRules}, nil
+	case "Role", "ClusterRole":
+		if group, _, _ := strings.Cut(typeMeta.APIVersion, "/"); group != rbacv1.GroupName {
+			return RBACRules{}, fmt.Errorf("unsupported apiVersion %q for %s (expected %s)", typeMeta.APIVersion, typeMeta.Kind, rbacv1.SchemeGroupVersion)
+		}
+		var role rbacv1.ClusterRole
+		if err := yaml.UnmarshalStrict(trimmed, &role); err != nil {
+			return RBACRules{}, err
+		}
+		if role.AggregationRule != nil {
+			return RBACRules{}, fmt.Errorf("aggregated ClusterRole %s is not supported, list its rules instead", role.Name)
+		}
+		if role.Annotations[ClusterWideAnnotation] == "true" {
+			return RBACRules{ClusterRules: role.Rules}, nil
+		}
+		return RBACRules{Rules: role.Rules}, nil
+	default:
+		return RBACRules{}, fmt.Errorf("unsupported kind %q (expected a list of rules, a rules file, a Role or a ClusterRole)", typeMeta.Kind)
+	}
+}
+
+// MergeRBACRules merges default RBAC rules with additional rules from a file. A rule for the same API
+// groups, resources, resource names and non-resource URLs as an earlier one adds its verbs to it
+// instead, so repeated rules are only granted once.
 func MergeRBACRules(defaultRules []rbacv1.PolicyRule, additionalRules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
 	if len(additionalRules) == 0 {
 		return defaultRules
 	}
 
-	merged := make([]rbacv1.PolicyRule, len(defaultRules))
-	copy(merged, defaultRules)
-	merged = append(merged, additionalRules...)
-	
+	var merged []rbacv1.PolicyRule
+	index := map[string]int{}
+	for _, rule := range append(slices.Clip(defaultRules), additionalRules...) {
+		key := ruleKey(rule)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			merged = append(merged, *rule.DeepCopy())
+			continue
+		}
+		for _, verb := range rule.Verbs {
+			if !slices.Contains(merged[i].Verbs, verb) {
+				merged[i].Verbs = append(merged[i].Verbs, verb)
+			}
+		}
+	}
 	return merged
 }
+
+// ruleKey identifies what a rule applies to, regardless of the order its fields are listed in
+func ruleKey(rule rbacv1.PolicyRule) string {
+	sorted := func(values []string) string {
+		values = slices.Clone(values)
+		slices.Sort(values)
+		return strings.Join(slices.Compact(values), ",")
+	}
+	return strings.Join([]string{
+		sorted(rule.APIGroups),
+		sorted(rule.Resources),
+		sorted(rule.ResourceNames),
+		sorted(rule.NonResourceURLs),
+	}, "|")
+}
@@ -0,0 +1,97 @@
+package generate
+
+import (
+	"fmt"
+
+	"testrunner/pkg/config"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// readVerbs are the verbs that never change an object
+var readVerbs = []string{"get", "list", "watch"}
+
+// RBACProfile returns the base rules of the named profile, which the rules of the RBAC files extend:
+//   - none grants nothing
+//   - read-only reads the common workload objects of the test namespace, but not its Secrets
+//   - default is GetTestRunnerRBACRules, and is used when no profile is set
+//   - namespace-admin grants everything in the test namespace
+//   - crd-author adds managing CustomResourceDefinitions across the cluster to default
+func RBACProfile(name string) (RBACRules, error) {
+	switch name {
+	case config.RBACProfileNone:
+		return RBACRules{}, nil
+	case config.RBACProfileReadOnly:
+		return RBACRules{Rules: readOnlyRBACRules()}, nil
+	case "", config.RBACProfileDefault:
+		return RBACRules{Rules: GetTestRunnerRBACRules()}, nil
+	case config.RBACProfileNamespaceAdmin:
+		return RBACRules{Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+		}}, nil
+	case config.RBACProfileCRDAuthor:
+		return RBACRules{
+			Rules: GetTestRunnerRBACRules(),
+			ClusterRules: []rbacv1.PolicyRule{{
+				APIGroups: []string{"apiextensions.k8s.io"},
+				Resources: []string{"customresourcedefinitions"},
+				Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+			}},
+		}, nil
+	default:
+		return RBACRules{}, fmt.Errorf("unknown RBAC profile %q (expected %s, %s, %s, %s or %s)", name,
+			config.RBACProfileNone, config.RBACProfileReadOnly, config.RBACProfileDefault, config.RBACProfileNamespaceAdmin, config.RBACProfileCRDAuthor)
+	}
+}
+
+// readOnlyRBACRules reads the objects the default rules manage, apart from Secrets
+func readOnlyRBACRules() []rbacv1.PolicyRule {
+	return []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"pods", "pods/log", "services", "endpoints", "configmaps", "persistentvolumeclaims", "events"},
+			Verbs:     readVerbs,
+		},
+		{
+			APIGroups: []string{"apps"},
+			Resources: []string{"deployments", "statefulsets", "daemonsets", "replicasets"},
+			Verbs:     readVerbs,
+		},
+		{
+			APIGroups: []string{"batch"},
+			Resources: []string{"jobs", "cronjobs"},
+			Verbs:     readVerbs,
+		},
+		{
+			APIGroups: []string{"networking.k8s.io"},
+			Resources: []string{"ingresses", "networkpolicies"},
+			Verbs:     readVerbs,
+		},
+	}
+}
+
+// RunRBACRules returns the rules granted to the test runner: those of the configured profile extended
+// by the RBAC files, or the rules of the RBAC files alone when they replace the defaults
+func RunRBACRules(cfg config.Config) (RBACRules, error) {
+	if cfg.RbacReplace && cfg.RbacProfile != "" {
+		return RBACRules{}, fmt.Errorf("rbacProfile %q has no effect when the RBAC files replace the defaults", cfg.RbacProfile)
+	}
+
+	base := RBACRules{}
+	if !cfg.RbacReplace {
+		var err error
+		base, err = RBACProfile(cfg.RbacProfile)
+		if err != nil {
+			return RBACRules{}, err
+		}
+	}
+
+	additional, err := LoadRBACRules(cfg.RbacFiles)
+	if err != nil {
+		return RBACRules{}, err
+	}
+	return RBACRules{
+		Rules:        MergeRBACRules(base.Rules, additional.Rules),
+		ClusterRules: MergeRBACRules(base.ClusterRules, additional.ClusterRules),
+	}, nil
+}
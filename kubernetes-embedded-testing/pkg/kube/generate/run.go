@@ -0,0 +1,95 @@
+package generate
+
+import (
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// RunIDLabel identifies every object created by a single run
+	RunIDLabel = "ket.io/run-id"
+	// CreatedAtAnnotation records when the run started, in RFC 3339 format
+	CreatedAtAnnotation = "ket.io/created-at"
+	// TTLAnnotation records how long the run's objects may live before `ket gc` reaps them
+	TTLAnnotation = "ket.io/ttl"
+	// OwnerHostAnnotation records the host that started the run
+	OwnerHostAnnotation = "ket.io/owner-host"
+	// OwnerUserAnnotation records the user that started the run
+	OwnerUserAnnotation = "ket.io/owner-user"
+)
+
+// RunMetadata identifies a single run and is stamped on every object it creates
+type RunMetadata struct {
+	Namespace string
+	RunID     string
+	CreatedAt time.Time
+	TTL       time.Duration
+	OwnerHost string
+	OwnerUser string
+}
+
+// NewRunMetadata creates the metadata for a new run in the given test namespace
+func NewRunMetadata(namespace string, ttl time.Duration) RunMetadata {
+	host, _ := os.Hostname()
+
+	owner := os.Getenv("USER")
+	if current, err := user.Current(); err == nil {
+		owner = current.Username
+	}
+
+	return RunMetadata{
+		Namespace: namespace,
+		RunID:     uuid.New().String(),
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		TTL:       ttl,
+		OwnerHost: host,
+		OwnerUser: owner,
+	}
+}
+
+// Labels returns the run labels, including the run ID when set
+func (m RunMetadata) Labels() map[string]string {
+	labels := RunLabels(m.Namespace)
+	if m.RunID != "" {
+		labels[RunIDLabel] = m.RunID
+	}
+	return labels
+}
+
+// Annotations returns the run's creation time, TTL and owner annotations, omitting unset values
+func (m RunMetadata) Annotations() map[string]string {
+	annotations := map[string]string{}
+	if !m.CreatedAt.IsZero() {
+		annotations[CreatedAtAnnotation] = m.CreatedAt.Format(time.RFC3339)
+	}
+	if m.TTL > 0 {
+		annotations[TTLAnnotation] = m.TTL.String()
+	}
+	if m.OwnerHost != "" {
+		annotations[OwnerHostAnnotation] = m.OwnerHost
+	}
+	if m.OwnerUser != "" {
+		annotations[OwnerUserAnnotation] = m.OwnerUser
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	return annotations
+}
+
+// RunExpiry returns when an object carrying run annotations expires. Objects without a TTL never expire.
+func RunExpiry(annotations map[string]string, creationTimestamp time.Time) (time.Time, bool) {
+	ttl, err := time.ParseDuration(annotations[TTLAnnotation])
+	if err != nil || ttl <= 0 {
+		return time.Time{}, false
+	}
+
+	createdAt, err := time.Parse(time.RFC3339, annotations[CreatedAtAnnotation])
+	if err != nil {
+		createdAt = creationTimestamp
+	}
+	return createdAt.Add(ttl), true
+}
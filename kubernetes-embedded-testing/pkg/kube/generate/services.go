@@ -0,0 +1,131 @@
+package generate
+
+import (
+	"fmt"
+	"strings"
+
+	"testrunner/pkg/config"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// defaultServiceProbePeriodS keeps the delay before the tests start short once a service is up
+	defaultServiceProbePeriodS = 2
+	// defaultServiceStartupFailures gives services a minute to become ready at the default period
+	defaultServiceStartupFailures = 30
+)
+
+// reservedContainerNames are the containers ket adds to the test pod itself
+var reservedContainerNames = map[string]bool{
+	TestRunnerContainerName:       true,
+	ReportsCollectorContainerName: true,
+	SourceUploadContainerName:     true,
+	SourceGitContainerName:        true,
+	RBACAuditContainerName:        true,
+}
+
+// serviceContainers returns the configured services as native sidecar containers: init containers
+// that keep running, so they start before the test container and stop after it. A service's
+// readiness probe doubles as its startup probe, which holds back the test container until it passes.
+func serviceContainers(services []config.ServiceConfig) ([]corev1.Container, error) {
+	var containers []corev1.Container
+	seen := map[string]bool{}
+	for _, service := range services {
+		if errs := validation.IsDNS1123Label(service.Name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid service name %q: %s", service.Name, strings.Join(errs, ", "))
+		}
+		if reservedContainerNames[service.Name] || seen[service.Name] {
+			return nil, fmt.Errorf("service name %q is already in use", service.Name)
+		}
+		seen[service.Name] = true
+		if service.Image == "" {
+			return nil, fmt.Errorf("service %s has no image", service.Name)
+		}
+
+		restartPolicy := corev1.ContainerRestartPolicyAlways
+		container := corev1.Container{
+			Name:            service.Name,
+			Image:           service.Image,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Command:         service.Command,
+			Args:            service.Args,
+			RestartPolicy:   &restartPolicy,
+		}
+
+		for _, port := range service.Ports {
+			if errs := validation.IsValidPortNum(int(port)); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid port %d for service %s: %s", port, service.Name, strings.Join(errs, ", "))
+			}
+			container.Ports = append(container.Ports, corev1.ContainerPort{ContainerPort: port, Protocol: corev1.ProtocolTCP})
+		}
+
+		for _, pair := range service.Env {
+			name, value, err := parseKeyValue(pair)
+			if err != nil {
+				return nil, fmt.Errorf("invalid env %q for service %s: %w", pair, service.Name, err)
+			}
+			if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid environment variable name %q for service %s: %s", name, service.Name, strings.Join(errs, ", "))
+			}
+			container.Env = append(container.Env, corev1.EnvVar{Name: name, Value: value})
+		}
+
+		if service.ReadinessProbe != nil {
+			readiness, err := serviceProbe(*service.ReadinessProbe)
+			if err != nil {
+				return nil, fmt.Errorf("invalid readiness probe for service %s: %w", service.Name, err)
+			}
+			startup := readiness.DeepCopy()
+			if service.ReadinessProbe.FailureThreshold == 0 {
+				startup.FailureThreshold = defaultServiceStartupFailures
+			}
+			container.ReadinessProbe = readiness
+			container.StartupProbe = startup
+		}
+
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+// serviceProbe builds a probe from exactly one of an exec command, TCP port or HTTP GET request
+func serviceProbe(probeCfg config.ProbeConfig) (*corev1.Probe, error) {
+	var handlers []corev1.ProbeHandler
+	if len(probeCfg.Exec) > 0 {
+		handlers = append(handlers, corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: probeCfg.Exec}})
+	}
+	if probeCfg.TCPPort != 0 {
+		if errs := validation.IsValidPortNum(int(probeCfg.TCPPort)); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid TCP port %d: %s", probeCfg.TCPPort, strings.Join(errs, ", "))
+		}
+		handlers = append(handlers, corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(probeCfg.TCPPort)}})
+	}
+	if probeCfg.HTTPGet != nil {
+		if errs := validation.IsValidPortNum(int(probeCfg.HTTPGet.Port)); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid HTTP port %d: %s", probeCfg.HTTPGet.Port, strings.Join(errs, ", "))
+		}
+		path := probeCfg.HTTPGet.Path
+		if path == "" {
+			path = "/"
+		}
+		handlers = append(handlers, corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: path, Port: intstr.FromInt32(probeCfg.HTTPGet.Port)}})
+	}
+	if len(handlers) != 1 {
+		return nil, fmt.Errorf("exactly one of exec, tcpPort or httpGet must be set")
+	}
+
+	periodS := probeCfg.PeriodS
+	if periodS == 0 {
+		periodS = defaultServiceProbePeriodS
+	}
+	return &corev1.Probe{
+		ProbeHandler:        handlers[0],
+		InitialDelaySeconds: probeCfg.InitialDelayS,
+		PeriodSeconds:       periodS,
+		TimeoutSeconds:      probeCfg.TimeoutS,
+		FailureThreshold:    probeCfg.FailureThreshold,
+	}, nil
+}
@@ -0,0 +1,139 @@
+package generate
+
+import (
+	"fmt"
+
+	"testrunner/pkg/config"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// SourceVolumeName is the name of the volume holding the project source
+	SourceVolumeName = "source-code"
+	// SourceMountPath is where the project source is mounted in the test pod
+	SourceMountPath = "/workspace"
+	// SourceUploadContainerName is the init container that waits for ket to upload the source
+	SourceUploadContainerName = "ket-source-upload"
+	// SourceUploadedMarker is created by ket once the source upload has finished
+	SourceUploadedMarker = SourceMountPath + "/.ket-uploaded"
+	// SourceGitContainerName is the init container that clones the source from git
+	SourceGitContainerName = "ket-source-git"
+	// DefaultGitImage is the image used to clone the source when none is configured
+	DefaultGitImage = "alpine/git:latest"
+)
+
+// sourceVolume returns the volume holding the project source for the configured source mode, along with
+// any init containers needed to populate it
+func sourceVolume(cfg config.Config, hostProjectRoot string) (corev1.Volume, []corev1.Container, error) {
+	volume := corev1.Volume{Name: SourceVolumeName}
+
+	switch cfg.Source.Mode {
+	case "", config.SourceModeHostPath:
+		volume.HostPath = &corev1.HostPathVolumeSource{
+			Path: hostProjectRoot,
+			Type: &[]corev1.HostPathType{corev1.HostPathDirectory}[0],
+		}
+		return volume, nil, nil
+
+	case config.SourceModeUpload:
+		volume.EmptyDir = &corev1.EmptyDirVolumeSource{}
+		return volume, []corev1.Container{sourceUploadContainer(cfg.Image)}, nil
+
+	case config.SourceModeGit:
+		if cfg.Source.Git.Repository == "" {
+			return volume, nil, fmt.Errorf("source mode %q requires source.git.repository", config.SourceModeGit)
+		}
+		volume.EmptyDir = &corev1.EmptyDirVolumeSource{}
+		return volume, []corev1.Container{sourceGitContainer(cfg.Source.Git)}, nil
+
+	case config.SourceModePVC:
+		if cfg.Source.PVC.ClaimName == "" {
+			return volume, nil, fmt.Errorf("source mode %q requires source.pvc.claimName", config.SourceModePVC)
+		}
+		volume.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{
+			ClaimName: cfg.Source.PVC.ClaimName,
+		}
+		return volume, nil, nil
+
+	default:
+		return volume, nil, fmt.Errorf("unsupported source mode %q (expected one of %s, %s, %s, %s)",
+			cfg.Source.Mode, config.SourceModeHostPath, config.SourceModeUpload, config.SourceModeGit, config.SourceModePVC)
+	}
+}
+
+// sourceVolumeMount returns the test container mount for the source volume
+func sourceVolumeMount(cfg config.Config) corev1.VolumeMount {
+	mount := corev1.VolumeMount{
+		Name:      SourceVolumeName,
+		MountPath: SourceMountPath,
+	}
+	if cfg.Source.Mode == config.SourceModePVC {
+		mount.SubPath = cfg.Source.PVC.SubPath
+	}
+	return mount
+}
+
+// sourceUploadContainer blocks pod startup until ket has copied the source into the source volume
+func sourceUploadContainer(image string) corev1.Container {
+	return corev1.Container{
+		Name:            SourceUploadContainerName,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command: []string{
+			"/bin/sh",
+			"-c",
+			fmt.Sprintf("until [ -f %[1]s ]; do sleep 1; done; rm -f %[1]s", SourceUploadedMarker),
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      SourceVolumeName,
+				MountPath: SourceMountPath,
+			},
+		},
+	}
+}
+
+// sourceGitContainer clones the configured repository and ref into the source volume
+func sourceGitContainer(git config.GitSourceConfig) corev1.Container {
+	image := git.Image
+	if image == "" {
+		image = DefaultGitImage
+	}
+	ref := git.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	return corev1.Container{
+		Name:            SourceGitContainerName,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command: []string{
+			"/bin/sh",
+			"-c",
+			fmt.Sprintf(`set -e
+git init -q %[1]s
+cd %[1]s
+git remote add origin "$KET_GIT_REPOSITORY"
+git fetch -q --depth 1 origin "$KET_GIT_REF"
+git checkout -q FETCH_HEAD`, SourceMountPath),
+		},
+		Env: []corev1.EnvVar{
+			{
+				Name:  "KET_GIT_REPOSITORY",
+				Value: git.Repository,
+			},
+			{
+				Name:  "KET_GIT_REF",
+				Value: ref,
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      SourceVolumeName,
+				MountPath: SourceMountPath,
+			},
+		},
+	}
+}
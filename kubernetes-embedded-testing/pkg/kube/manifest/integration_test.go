@@ -1,10 +1,13 @@
 package manifest
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"testrunner/pkg/config"
+	"testrunner/pkg/kube/generate"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -21,9 +24,9 @@ func TestAll_GeneratesValidYAML(t *testing.T) {
 	}
 	namespace := "test-namespace"
 
-	manifests, err := All(cfg, namespace)
+	manifests, err := All(cfg, generate.RunMetadata{Namespace: namespace})
 	require.NoError(t, err)
-	require.Len(t, manifests, 4) // Namespace, ClusterRole, ClusterRoleBinding, Job
+	require.Len(t, manifests, 5) // Namespace, ServiceAccount, Role, RoleBinding, Job
 
 	// Verify all manifests are valid YAML
 	for i, manifest := range manifests {
@@ -36,8 +39,9 @@ func TestAll_GeneratesValidYAML(t *testing.T) {
 	// Verify specific resource types
 	allManifests := strings.Join(manifests, "\n")
 	assert.Contains(t, allManifests, "kind: Namespace")
-	assert.Contains(t, allManifests, "kind: ClusterRole")
-	assert.Contains(t, allManifests, "kind: ClusterRoleBinding")
+	assert.Contains(t, allManifests, "kind: ServiceAccount")
+	assert.Contains(t, allManifests, "kind: Role")
+	assert.Contains(t, allManifests, "kind: RoleBinding")
 	assert.Contains(t, allManifests, "kind: Job")
 }
 
@@ -52,7 +56,7 @@ func TestAll_ContainsExpectedConfiguration(t *testing.T) {
 	}
 	namespace := "test-namespace"
 
-	manifests, err := All(cfg, namespace)
+	manifests, err := All(cfg, generate.RunMetadata{Namespace: namespace})
 	require.NoError(t, err)
 
 	allManifests := strings.Join(manifests, "\n")
@@ -60,8 +64,11 @@ func TestAll_ContainsExpectedConfiguration(t *testing.T) {
 	// Verify namespace
 	assert.Contains(t, allManifests, "name: "+namespace)
 
-	// Verify cluster role and cluster role binding
+	// Verify the test runner's ServiceAccount, Role and RoleBinding live in the test namespace
+	assert.Contains(t, allManifests, "name: ket-runner")
 	assert.Contains(t, allManifests, "name: ket-test-runner")
+	assert.Contains(t, allManifests, "serviceAccountName: ket-runner")
+	assert.Contains(t, allManifests, "ket.io/test-namespace: "+namespace)
 
 	// Verify job configuration
 	assert.Contains(t, allManifests, "name: ket-my-app")
@@ -111,7 +118,7 @@ func TestAll_ProjectRootVariations(t *testing.T) {
 				WorkspacePath: "/workspace",
 			}
 
-			manifests, err := All(cfg, "test-namespace")
+			manifests, err := All(cfg, generate.RunMetadata{Namespace: "test-namespace"})
 			require.NoError(t, err)
 
 			allManifests := strings.Join(manifests, "\n")
@@ -125,7 +132,7 @@ func TestAll_YAMLStructure(t *testing.T) {
 		ProjectRoot: "test-project",
 	}
 
-	manifests, err := All(cfg, "test-namespace")
+	manifests, err := All(cfg, generate.RunMetadata{Namespace: "test-namespace"})
 	require.NoError(t, err)
 
 	for i, manifest := range manifests {
@@ -140,3 +147,85 @@ func TestAll_YAMLStructure(t *testing.T) {
 			"Manifest %d first line should be '---' or start with 'apiVersion:', got: %s", i, firstLine)
 	}
 }
+
+func TestAll_IncludesFixtures(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "mongodb.yml")
+	require.NoError(t, os.WriteFile(fixture, []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: mongodb
+spec:
+  replicas: 1
+`), 0o644))
+
+	cfg := config.Config{
+		ProjectRoot:     ".",
+		Image:           "test-image:latest",
+		TestCommand:     "npm test",
+		BackoffLimit:    1,
+		ActiveDeadlineS: 1800,
+		WorkspacePath:   "/workspace",
+		Fixtures:        []string{fixture},
+	}
+
+	manifests, err := All(cfg, generate.RunMetadata{Namespace: "test-namespace"})
+	require.NoError(t, err)
+	require.Len(t, manifests, 6) // Namespace, ServiceAccount, Role, RoleBinding, fixture, Job
+
+	assert.Contains(t, manifests[4], "kind: Deployment")
+	assert.Contains(t, manifests[4], "name: mongodb")
+	assert.Contains(t, manifests[4], "namespace: test-namespace")
+	assert.Contains(t, manifests[5], "kind: Job")
+}
+
+func TestAll_RedactsSecrets(t *testing.T) {
+	cfg := config.Config{
+		ProjectRoot:   ".",
+		Image:         "test-image:latest",
+		TestCommand:   "npm test",
+		WorkspacePath: "/workspace",
+		Env:           []string{"CI=true", "DEPLOY_TOKEN=env-secret-token"},
+		ForwardEnv:    []string{"KET_TEST_FORWARDED_TOKEN"},
+		Secrets: []config.SecretConfig{
+			{Name: "api-credentials", Literals: []string{"API_TOKEN=super-secret-token"}},
+		},
+	}
+	t.Setenv("KET_TEST_FORWARDED_TOKEN", "forwarded-secret-token")
+
+	manifests, err := All(cfg, generate.RunMetadata{Namespace: "test-namespace"})
+	require.NoError(t, err)
+	require.Len(t, manifests, 7) // Namespace, ServiceAccount, Role, RoleBinding, Secret, test env Secret, Job
+
+	assert.Contains(t, manifests[4], "kind: Secret")
+	assert.Contains(t, manifests[4], "API_TOKEN: <redacted>")
+	assert.Contains(t, manifests[5], "name: "+generate.TestEnvSecretName)
+	assert.Contains(t, manifests[5], "KET_TEST_FORWARDED_TOKEN: <redacted>")
+	for _, manifest := range manifests {
+		assert.NotContains(t, manifest, "super-secret-token")
+		assert.NotContains(t, manifest, "env-secret-token")
+		assert.NotContains(t, manifest, "forwarded-secret-token")
+	}
+	assert.Contains(t, manifests[6], "name: CI")
+	assert.Contains(t, manifests[6], "name: api-credentials")
+}
+
+func TestAll_AppliesPatches(t *testing.T) {
+	cfg := config.Config{
+		ProjectRoot:   ".",
+		Image:         "test-image:latest",
+		TestCommand:   "npm test",
+		WorkspacePath: "/workspace",
+		Patches: []config.PatchConfig{
+			{Target: config.PatchTarget{Kind: "RoleBinding"}, Patch: "metadata:\n  annotations:\n    team: platform"},
+			{Target: config.PatchTarget{Kind: "Job"}, Type: config.PatchTypeJSON, Patch: `[{"op": "replace", "path": "/spec/backoffLimit", "value": 4}]`},
+		},
+	}
+
+	manifests, err := All(cfg, generate.RunMetadata{Namespace: "test-namespace"})
+	require.NoError(t, err)
+	require.Len(t, manifests, 5)
+
+	assert.Contains(t, manifests[3], "team: platform")
+	assert.NotContains(t, manifests[2], "team: platform")
+	assert.Contains(t, manifests[4], "backoffLimit: 4")
+}
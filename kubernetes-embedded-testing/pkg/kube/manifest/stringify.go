@@ -4,7 +4,7 @@ import (
 	"testrunner/pkg/config"
 	"testrunner/pkg/kube/generate"
 
-	rbacv1 "k8s.io/api/rbac/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -26,28 +26,20 @@ func marshalKubernetesObject(obj runtime.Object) ([]byte, error) {
 	return runtime.Encode(serializer, obj)
 }
 
-// All generates all manifests as YAML strings
-func All(cfg config.Config, namespace string) ([]string, error) {
-	ns := generate.Namespace(namespace)
-	
-	// Load additional RBAC rules from file if specified
-	var additionalRules []rbacv1.PolicyRule
-	if cfg.RbacFile != "" {
-		rules, err := generate.LoadRBACRulesFromFile(cfg.RbacFile)
-		if err != nil {
-			return nil, err
-		}
-		additionalRules = rules
-	}
-	
-	role := generate.ClusterRole(additionalRules...)
-	roleBinding := generate.ClusterRoleBinding(namespace)
-	job, err := generate.Job(cfg, namespace)
+// All generates all manifests for the run as YAML strings
+func All(cfg config.Config, run generate.RunMetadata) ([]string, error) {
+	objects, err := generate.Objects(cfg, run)
 	if err != nil {
 		return nil, err
 	}
 
-	manifests := []runtime.Object{ns, role, roleBinding, job}
+	manifests := objects.List()
+	for i, manifest := range manifests {
+		// Secret values never leave the host through rendered manifests
+		if secret, ok := manifest.(*corev1.Secret); ok {
+			manifests[i] = generate.RedactSecret(secret)
+		}
+	}
 	results := make([]string, len(manifests))
 
 	for i, manifest := range manifests {
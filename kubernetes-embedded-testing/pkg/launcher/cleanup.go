@@ -0,0 +1,107 @@
+package launcher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"testrunner/pkg/config"
+	"testrunner/pkg/kube/apply"
+	"testrunner/pkg/kube/generate"
+	"testrunner/pkg/logger"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// defaultCleanupTimeout bounds cleanup when no cleanup timeout is configured
+const defaultCleanupTimeout = 60 * time.Second
+
+// runResources tracks the resources a launch has created, so cleanup removes exactly those
+type runResources struct {
+	namespace        string
+	namespaceCreated bool
+	// rbac is the run's RBAC, set once it has been created
+	rbac *generate.RunRBAC
+	// secrets are the Secrets the run created
+	secrets []*corev1.Secret
+	jobs    []*batchv1.Job
+	// clusterFixtures are the cluster-scoped fixtures the run created, such as CRDs
+	clusterFixtures []apply.ClusterObject
+}
+
+// cleanupTimeout returns the configured cleanup timeout
+func cleanupTimeout(cfg config.Config) time.Duration {
+	if cfg.CleanupTimeout <= 0 {
+		return defaultCleanupTimeout
+	}
+	return time.Duration(cfg.CleanupTimeout) * time.Second
+}
+
+// cleanupRun removes the run's resources in order: the jobs with foreground propagation so their pods go
+// first, then the per-run cluster RBAC, then the namespace, waiting for it to finish terminating, and
+// last the cluster-scoped fixtures, once nothing in the namespace uses them any more. Cluster-scoped
+// fixtures are kept along with a kept namespace. A namespace that existed before the run is never
+// deleted; only the test runner's RBAC and the Secrets the run created are removed from it.
+// It runs on its own context so it still completes after the launch context is cancelled, and returns
+// one error per resource that could not be removed.
+func cleanupRun(clients *Clients, cfg config.Config, resources runResources, runFailed bool) []error {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout(cfg))
+	defer cancel()
+	client := clients.Kube
+
+	var failures []error
+
+	// A failed run's jobs are kept alongside the namespace for debugging
+	if !cfg.KeepNamespace || !runFailed {
+		for _, job := range resources.jobs {
+			if err := apply.DeleteJob(ctx, client, job); err != nil {
+				failures = append(failures, fmt.Errorf("job %s/%s: %w", job.Namespace, job.Name, err))
+			}
+		}
+	}
+
+	if resources.rbac != nil {
+		if err := apply.DeleteRBAC(ctx, client, resources.namespace); err != nil {
+			failures = append(failures, fmt.Errorf("cluster RBAC for namespace %s: %w", resources.namespace, err))
+		}
+	}
+
+	switch {
+	case cfg.KeepNamespace:
+	case resources.namespaceCreated:
+		logger.LauncherLogger.Info("Cleaning up test namespace %s", resources.namespace)
+		err := apply.DeleteNamespace(ctx, client, resources.namespace)
+		if err == nil {
+			err = apply.WaitForNamespaceDeletion(ctx, client, resources.namespace)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Errorf("namespace %s: %w", resources.namespace, err))
+		}
+	default:
+		logger.LauncherLogger.Info("Keeping test namespace %s, which existed before the run", resources.namespace)
+		if resources.rbac != nil {
+			if err := apply.DeleteNamespacedRBAC(ctx, client, *resources.rbac); err != nil {
+				failures = append(failures, fmt.Errorf("RBAC in namespace %s: %w", resources.namespace, err))
+			}
+		}
+		failures = append(failures, apply.DeleteSecrets(ctx, client, resources.secrets)...)
+	}
+
+	if len(resources.clusterFixtures) > 0 && !cfg.KeepNamespace {
+		failures = append(failures, apply.DeleteClusterObjects(ctx, clients.Dynamic, resources.clusterFixtures)...)
+	}
+
+	return failures
+}
+
+// reportCleanupFailures logs each resource cleanup could not remove
+func reportCleanupFailures(failures []error) {
+	if len(failures) == 0 {
+		return
+	}
+	logger.LauncherLogger.Error("Cleanup could not remove %d test resources, remove them manually:", len(failures))
+	for _, failure := range failures {
+		logger.LauncherLogger.Error("  %v", failure)
+	}
+}
@@ -0,0 +1,27 @@
+package launcher
+
+import (
+	"context"
+	"time"
+
+	"testrunner/pkg/kube/diagnostics"
+	"testrunner/pkg/logger"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// diagnosticsTimeout bounds how long collecting a diagnostics bundle may delay cleanup
+const diagnosticsTimeout = 60 * time.Second
+
+// collectDiagnostics captures a diagnostics bundle for the failed run before its namespace is cleaned up.
+// It uses its own context so a bundle is still written when the run was cancelled.
+func collectDiagnostics(client kubernetes.Interface, namespace, dest string) {
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticsTimeout)
+	defer cancel()
+
+	if err := diagnostics.Collect(ctx, client, namespace, dest); err != nil {
+		logger.LauncherLogger.Warn("Diagnostics for namespace %s in %s are incomplete: %v", namespace, dest, err)
+		return
+	}
+	logger.LauncherLogger.Info("Wrote diagnostics for namespace %s to %s", namespace, dest)
+}
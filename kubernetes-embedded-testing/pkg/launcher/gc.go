@@ -0,0 +1,69 @@
+package launcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"testrunner/pkg/config"
+	"testrunner/pkg/kube/apply"
+	"testrunner/pkg/logger"
+)
+
+// RunGC deletes expired and orphaned ket resources from the cluster
+func RunGC(cfg config.Config) error {
+	return RunGCWithClient(cfg, NewClient)
+}
+
+// RunGCWithClient deletes expired and orphaned ket resources using clients created by newClient
+func RunGCWithClient(cfg config.Config, newClient ClientFactory) error {
+	ctx := context.Background()
+	if cfg.Ctx != nil {
+		ctx = cfg.Ctx
+	}
+
+	logger.ConfigureFromConfig(cfg.Logging.Prefix, cfg.Logging.Timestamp)
+
+	if cfg.Debug {
+		logger.SetGlobalLevel(logger.DEBUG)
+	}
+
+	clients, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	candidates, err := apply.FindGarbage(ctx, clients.Kube, clients.Dynamic, apply.GCOptions{
+		Selector:  cfg.GC.Selector,
+		OlderThan: cfg.GC.OlderThan,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to find ket resources: %w", err)
+	}
+
+	if len(candidates) == 0 {
+		logger.LauncherLogger.Info("No expired or orphaned ket resources found")
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "KIND\tNAMESPACE\tNAME\tREASON")
+	for _, candidate := range candidates {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", candidate.Kind, candidate.Namespace, candidate.Name, candidate.Reason)
+	}
+	writer.Flush()
+
+	if cfg.GC.DryRun {
+		logger.LauncherLogger.Info("Dry run, %d resources would be deleted", len(candidates))
+		return nil
+	}
+
+	if failures := apply.DeleteGarbage(ctx, clients.Kube, clients.Dynamic, candidates); len(failures) > 0 {
+		reportCleanupFailures(failures)
+		return fmt.Errorf("failed to delete %d of %d ket resources", len(failures), len(candidates))
+	}
+
+	logger.LauncherLogger.Info("Deleted %d ket resources", len(candidates))
+	return nil
+}
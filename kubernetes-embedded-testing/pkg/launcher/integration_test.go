@@ -39,8 +39,9 @@ func TestRunManifest_GeneratesValidYAML(t *testing.T) {
 	outputStr := string(output)
 	assert.Contains(t, outputStr, "kind: Namespace")
 	assert.Contains(t, outputStr, "kind: ServiceAccount")
-	assert.Contains(t, outputStr, "kind: ClusterRole")
-	assert.Contains(t, outputStr, "kind: ClusterRoleBinding")
+	assert.Contains(t, outputStr, "kind: Role")
+	assert.Contains(t, outputStr, "kind: RoleBinding")
+	assert.NotContains(t, outputStr, "kind: ClusterRole", "nothing is granted cluster-wide by default")
 	assert.Contains(t, outputStr, "kind: Job")
 
 	// Verify YAML structure
@@ -164,7 +165,7 @@ func TestRunManifest_RBACConfiguration(t *testing.T) {
 	outputStr := string(output)
 
 	// Verify ServiceAccount
-	assert.Contains(t, outputStr, "name: default")
+	assert.Contains(t, outputStr, "name: ket-runner")
 
 	// Verify Role
 	assert.Contains(t, outputStr, "name: ket-test-runner")
@@ -175,7 +176,7 @@ func TestRunManifest_RBACConfiguration(t *testing.T) {
 
 	// Verify RoleBinding
 	assert.Contains(t, outputStr, "kind: ServiceAccount")
-	assert.Contains(t, outputStr, "kind: ClusterRole")
+	assert.Contains(t, outputStr, "kind: RoleBinding")
 }
 
 func TestRunManifest_JobConfiguration(t *testing.T) {
@@ -2,27 +2,102 @@ package launcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"testrunner/pkg/config"
 	"testrunner/pkg/kube/apply"
+	"testrunner/pkg/kube/generate"
 	"testrunner/pkg/logger"
-	 metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testrunner/pkg/results"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 )
 
 // TestExecutionError represents a test execution failure with an exit code
 type TestExecutionError struct {
 	ExitCode int
 	Message  string
+	// Category classifies the failure, so CI can tell failing tests from infrastructure problems
+	Category results.FailureCategory
 }
 
 func (e *TestExecutionError) Error() string {
-	return fmt.Sprintf("%s (exit code: %d)", e.Message, e.ExitCode)
+	return fmt.Sprintf("%s (%s, exit code: %d)", e.Message, e.Category, e.ExitCode)
+}
+
+// configError is an invalid config found before anything is created. Every other error of a launch
+// is the cluster's, and is reported as an infrastructure failure.
+type configError struct {
+	err error
+}
+
+func (e *configError) Error() string {
+	return e.err.Error()
+}
+
+func (e *configError) Unwrap() error {
+	return e.err
+}
+
+// Clients holds the Kubernetes clients used during a launch
+type Clients struct {
+	Kube kubernetes.Interface
+	// Dynamic and Mapper apply arbitrary fixture manifests
+	Dynamic dynamic.Interface
+	Mapper  meta.RESTMapper
+	// RestConfig is needed for exec-based operations such as source upload and reports collection
+	RestConfig *rest.Config
+}
+
+// ClientFactory creates the Kubernetes clients used for a launch
+type ClientFactory func(cfg config.Config) (*Clients, error)
+
+// NewClient is the default ClientFactory, connecting to the cluster selected by cfg.Cluster
+func NewClient(cfg config.Config) (*Clients, error) {
+	restConfig, err := apply.NewRestConfig(cfg.Cluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Kubernetes config: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes dynamic client: %w", err)
+	}
+
+	return &Clients{
+		Kube:       client,
+		Dynamic:    dynamicClient,
+		Mapper:     restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(client.Discovery())),
+		RestConfig: restConfig,
+	}, nil
 }
 
 // RunLaunch executes tests in Kubernetes
 func RunLaunch(cfg config.Config) error {
+	return RunLaunchWithClient(cfg, NewClient)
+}
+
+// RunLaunchWithClient executes tests in Kubernetes using clients created by newClient
+func RunLaunchWithClient(cfg config.Config, newClient ClientFactory) error {
 	ctx := context.Background()
 	if cfg.Ctx != nil {
 		ctx = cfg.Ctx
@@ -34,78 +109,333 @@ func RunLaunch(cfg config.Config) error {
 		logger.SetGlobalLevel(logger.DEBUG)
 	}
 
-	client, err := apply.NewClient()
+	if err := cfg.Matrix.Validate(); err != nil {
+		return fmt.Errorf("invalid matrix: %w", err)
+	}
+
+	clients, err := newClient(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to create Kubernetes client: %w", err)
+		return err
+	}
+
+	cells := cfg.Matrix.Cells()
+	var outcomes []shardOutcome
+	if len(cells) > 0 && cfg.Matrix.SeparateNamespaces {
+		outcomes, err = launchCells(ctx, clients, cfg, cells)
+	} else {
+		outcomes, err = launchRun(ctx, clients, cfg)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return &TestExecutionError{
+				ExitCode: results.FailureCancelled.ExitCode(0),
+				Message:  fmt.Sprintf("run cancelled: %v", err),
+				Category: results.FailureCancelled,
+			}
+		}
+		var invalid *configError
+		if errors.As(err, &invalid) {
+			return err
+		}
+		return &TestExecutionError{
+			ExitCode: results.FailureInfraError.ExitCode(0),
+			Message:  err.Error(),
+			Category: results.FailureInfraError,
+		}
+	}
+
+	summaries := make([]*results.Summary, len(outcomes))
+	for i, outcome := range outcomes {
+		summaries[i] = outcome.summary
+	}
+	summary := results.MergeShards(summaries)
+
+	if len(cells) > 0 {
+		reportMatrix(cells, outcomes)
 	}
+	reportSummary(cfg, summary)
+
+	if !summary.Success {
+		return &TestExecutionError{
+			ExitCode: summary.ExitCode,
+			Message:  failureMessage(outcomes),
+			Category: summary.Category,
+		}
+	}
+
+	logger.LauncherLogger.Info("Test execution completed successfully")
+	return nil
+}
+
+// launchRun runs the suite in a new test namespace: it creates the namespace, RBAC, fixtures and test
+// jobs, waits for the jobs to finish and cleans everything up again. Failing tests are reported in
+// the outcomes rather than as an error.
+func launchRun(ctx context.Context, clients *Clients, cfg config.Config) (outcomes []shardOutcome, runErr error) {
+	client := clients.Kube
 
 	namespace := generateTestNamespace(cfg)
 	logger.LauncherLogger.Info("Using test namespace: %s", namespace)
+	run := generate.NewRunMetadata(namespace, cfg.TTL)
+
+	// Everything is generated and patched up front, so invalid config fails before anything is created
+	objects, err := generate.Objects(cfg, run)
+	if err != nil {
+		return nil, &configError{err}
+	}
+
+	// Missing permissions are found before anything is created, rather than halfway through the run
+	if !cfg.SkipPreflight {
+		if err := preflight(ctx, clients, cfg, objects); err != nil {
+			return nil, err
+		}
+	}
 
 	// Track what resources were created for cleanup
-	var (
-		namespaceCreated = false
-		rbacCreated      = false
-	)
+	resources := runResources{namespace: namespace}
+	namespaceReady := false
+	diagnosticsCollected := false
+	testsFailed := false
 
 	defer func() {
-		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), 1*time.Second)
-		defer cleanupCancel()
-
-		// Only cleanup resources that were actually created
-		if rbacCreated {
-			if err := apply.DeleteRBAC(cleanupCtx, client, namespace); err != nil {
-				logger.LauncherLogger.Warn("Failed to cleanup RBAC resources: %v", err)
-			}
+		if ctx.Err() != nil {
+			logger.LauncherLogger.Warn("Run cancelled, cleaning up test resources...")
 		}
 
-		if namespaceCreated && !cfg.KeepNamespace {
-			logger.LauncherLogger.Info("Cleaning up test namespace %s", namespace)
-			if err := apply.DeleteNamespace(cleanupCtx, client, namespace); err != nil {
-				logger.LauncherLogger.Warn("Failed to cleanup namespace %s: %v", namespace, err)
-			}
+		if runErr != nil && namespaceReady && cfg.DiagnosticsDir != "" && !diagnosticsCollected {
+			collectDiagnostics(client, namespace, cfg.DiagnosticsDir)
 		}
+
+		reportCleanupFailures(cleanupRun(clients, cfg, resources, runErr != nil || testsFailed))
 	}()
 
-	createdNamespace, err := apply.Namespace(ctx, client, namespace)
+	created, err := apply.Namespace(ctx, client, objects.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create namespace: %w", err)
+	}
+	resources.namespaceCreated = created
+	namespaceReady = true
+
+	// Set up front so RBAC created before a failure part way is removed as well
+	resources.rbac = &objects.RunRBAC
+	if err := apply.RBAC(ctx, client, objects.RunRBAC); err != nil {
+		return nil, fmt.Errorf("failed to create RBAC resources: %w", err)
+	}
+
+	secrets, err := apply.Secrets(ctx, client, objects.Secrets)
+	resources.secrets = secrets
 	if err != nil {
-		return fmt.Errorf("failed to create namespace: %w", err)
+		return nil, err
 	}
-	namespaceCreated = true
 
-	err = apply.RBAC(ctx, client, createdNamespace, &cfg)
+	if len(objects.Fixtures) > 0 {
+		created, err := applyFixtures(ctx, clients, cfg, namespace, objects.Fixtures)
+		resources.clusterFixtures = created
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	jobs, err := apply.Jobs(ctx, client, objects.Jobs)
+	resources.jobs = jobs
 	if err != nil {
-		return fmt.Errorf("failed to create RBAC resources: %w", err)
+		return nil, fmt.Errorf("failed to create job: %w", err)
 	}
-	rbacCreated = true
 
-	job, err := apply.Job(ctx, client, cfg, createdNamespace)
+	reportsDir := cfg.ReportsDir
+	if generate.ReportsCollectionEnabled(cfg) && reportsDir == "" {
+		// Results still need parsing when the reports themselves are not kept
+		tmpDir, err := os.MkdirTemp("", "ket-reports-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary reports directory: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		reportsDir = tmpDir
+	}
+
+	outcomes, err = runShards(ctx, clients, cfg, jobs, reportsDir)
 	if err != nil {
-		return fmt.Errorf("failed to create job: %w", err)
+		return nil, err
 	}
 
-	if err := apply.StreamTestOutputToHost(ctx, client, job); err != nil {
-		return fmt.Errorf("failed to stream test output: %w", err)
+	if cfg.RbacAudit.Enabled {
+		reportRBACAudit(cfg, namespace, objects.RunRBAC.Rules(), outcomes)
 	}
 
-	result, err := apply.WaitForTestCompletion(ctx, client, job)
+	for i := range outcomes {
+		outcome := &outcomes[i]
+		outcome.summary = buildSummary(cfg, outcome.job, outcome.result, outcome.reportsDir)
+		if !outcome.result.Success {
+			testsFailed = true
+		}
+	}
+
+	if testsFailed && cfg.DiagnosticsDir != "" {
+		// Capture the failed pods before the jobs and their pods are deleted
+		collectDiagnostics(client, namespace, cfg.DiagnosticsDir)
+		diagnosticsCollected = true
+	}
+
+	return outcomes, nil
+}
+
+// applyFixtures applies the fixtures into the test namespace and waits for them to become ready. It
+// returns the cluster-scoped fixtures it created, which cleanup has to delete.
+func applyFixtures(ctx context.Context, clients *Clients, cfg config.Config, namespace string, fixtures []*unstructured.Unstructured) ([]apply.ClusterObject, error) {
+	logger.LauncherLogger.Info("Applying %d fixture objects...", len(fixtures))
+	created, err := apply.Fixtures(ctx, clients.Dynamic, clients.Mapper, namespace, fixtures)
 	if err != nil {
-		return fmt.Errorf("failed to wait for test completion: %w", err)
+		return created, err
+	}
+
+	waitCtx := ctx
+	if cfg.FixturesTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, time.Duration(cfg.FixturesTimeout)*time.Second)
+		defer cancel()
+	}
+	if err := apply.WaitForFixtures(waitCtx, clients.Kube, namespace, fixtures); err != nil {
+		return created, err
 	}
 
-	logger.LauncherLogger.Info("Deleting job %s...", job.Name)
-	policy := metav1.DeletePropagationBackground
-	err = client.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{
-		PropagationPolicy: &policy,
-	})
+	logger.LauncherLogger.Info("Fixtures ready")
+	return created, nil
+}
 
-	if !result.Success {
-		return &TestExecutionError{
-			ExitCode: result.ExitCode,
-			Message:  result.Error.Error(),
+// shardOutcome is the result of running one shard's test job
+type shardOutcome struct {
+	job        *batchv1.Job
+	reportsDir string
+	result     *apply.TestResult
+	summary    *results.Summary
+	// audited are the API requests of the job's attempts when RBAC is audited
+	audited []generate.AuditedRequest
+}
+
+// runShards runs the test jobs in parallel and waits for all of them to finish. Each job's output is
+// streamed as it runs, prefixed with its matrix cell and shard when there are several jobs. If a shard cannot be run, the
+// others are stopped and the first such error is returned; failing tests are reported in the outcomes.
+func runShards(ctx context.Context, clients *Clients, cfg config.Config, jobs []*batchv1.Job, reportsDir string) ([]shardOutcome, error) {
+	if len(jobs) > 1 {
+		logger.LauncherLogger.Info("Running %d test jobs in parallel", len(jobs))
+	}
+
+	shardCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outcomes := make([]shardOutcome, len(jobs))
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for i, job := range jobs {
+		outcomes[i] = shardOutcome{job: job, reportsDir: reportsDir}
+		if (len(jobs) > 1 || cfg.Cell != nil) && reportsDir != "" {
+			outcomes[i].reportsDir = filepath.Join(reportsDir, job.Name)
 		}
+
+		wg.Add(1)
+		go func(outcome *shardOutcome) {
+			defer wg.Done()
+			result, err := runShard(shardCtx, clients, cfg, outcome)
+			if err != nil {
+				if len(jobs) > 1 {
+					err = fmt.Errorf("job %s: %w", outcome.job.Name, err)
+				}
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			outcome.result = result
+		}(&outcomes[i])
 	}
+	wg.Wait()
 
-	logger.LauncherLogger.Info("Test execution completed successfully")
-	return nil
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return outcomes, nil
+}
+
+// runShard streams the output of every attempt of the outcome's job, uploading the source and waiting
+// for the job's services first and collecting the reports and audited requests after, and waits for the
+// job to finish. An attempt that cannot get going is reported as a failed result of the job.
+func runShard(ctx context.Context, clients *Clients, cfg config.Config, outcome *shardOutcome) (*apply.TestResult, error) {
+	client, restConfig := clients.Kube, clients.RestConfig
+	job, reportsDir := outcome.job, outcome.reportsDir
+	startupTimeout := time.Duration(cfg.StartupTimeout) * time.Second
+
+	hooks := apply.AttemptHooks{
+		// Every attempt runs in a new pod that needs the source and has its own services
+		Before: func(followed map[string]bool) error {
+			if cfg.Source.Mode == config.SourceModeUpload {
+				localPath := cfg.Source.LocalPath
+				if localPath == "" {
+					localPath = "."
+				}
+				if err := apply.UploadSource(ctx, client, restConfig, job, localPath, cfg.Source.Exclude, startupTimeout); err != nil {
+					return fmt.Errorf("failed to upload source: %w", err)
+				}
+			}
+			return apply.WaitForServices(ctx, client, job, startupTimeout, followed)
+		},
+	}
+	hooks.After = func(pod *corev1.Pod) {
+		// The reports collector holds each pod until its reports are copied, so they are collected per attempt
+		if generate.ReportsCollectionEnabled(cfg) {
+			if err := apply.CollectReports(ctx, client, restConfig, pod, reportsDir); err != nil {
+				logger.LauncherLogger.Warn("Failed to collect reports: %v", err)
+			}
+		}
+		if cfg.RbacAudit.Enabled {
+			requests, err := apply.RBACAuditRequests(ctx, client, pod)
+			if err != nil {
+				logger.LauncherLogger.Warn("Failed to read the RBAC audit: %v", err)
+				return
+			}
+			outcome.audited = append(outcome.audited, requests...)
+		}
+	}
+
+	if err := apply.StreamTestOutputToHost(ctx, client, job, startupTimeout, hooks); err != nil {
+		return classifyStartFailure(ctx, client, job, err)
+	}
+
+	result, err := apply.WaitForTestCompletion(ctx, client, job)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for test completion: %w", err)
+	}
+	return result, nil
+}
+
+// classifyStartFailure turns a test pod that never got going, for example because its image cannot be
+// pulled, into a failed result of the job, so it is reported with its failure category. The error is
+// returned as is if the run was cancelled or the job cannot be inspected.
+func classifyStartFailure(ctx context.Context, client kubernetes.Interface, job *batchv1.Job, err error) (*apply.TestResult, error) {
+	if ctx.Err() != nil {
+		return nil, err
+	}
+	result, classifyErr := apply.ClassifyFailure(ctx, client, job, err)
+	if classifyErr != nil {
+		logger.LauncherLogger.Warn("Failed to classify the failure of job %s: %v", job.Name, classifyErr)
+		return nil, err
+	}
+	logger.LauncherLogger.Error("%v", result.Error)
+	return result, nil
+}
+
+// failureMessage describes the failed jobs of a run
+func failureMessage(outcomes []shardOutcome) string {
+	var failed []string
+	for _, outcome := range outcomes {
+		if !outcome.result.Success {
+			failed = append(failed, outcome.result.Error.Error())
+		}
+	}
+	if len(outcomes) == 1 {
+		return failed[0]
+	}
+	return fmt.Sprintf("%d of %d jobs failed: %s", len(failed), len(outcomes), strings.Join(failed, "; "))
 }
@@ -0,0 +1,993 @@
+package launcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"testrunner/pkg/config"
+	"testrunner/pkg/kube/apply"
+	"testrunner/pkg/kube/generate"
+	"testrunner/pkg/results"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta/testrestmapper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeClientFactory returns a ClientFactory handing out the given fake clientset
+func fakeClientFactory(client *fake.Clientset) ClientFactory {
+	return func(cfg config.Config) (*Clients, error) {
+		reviewAccess(client, nil)
+		return &Clients{
+			Kube:       client,
+			Dynamic:    dynamicfake.NewSimpleDynamicClient(scheme.Scheme),
+			Mapper:     testrestmapper.TestOnlyStaticRESTMapper(scheme.Scheme),
+			RestConfig: &rest.Config{},
+		}, nil
+	}
+}
+
+// reviewAccess answers the SelfSubjectAccessReviews of the preflight check, allowing everything but
+// what denied returns true for, and its SelfSubjectRulesReview with every rule
+func reviewAccess(client *fake.Clientset, denied func(*authorizationv1.ResourceAttributes) bool) {
+	reviewRules(client, []authorizationv1.ResourceRule{{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}}})
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview).DeepCopy()
+		review.Status.Allowed = denied == nil || !denied(review.Spec.ResourceAttributes)
+		return true, review, nil
+	})
+}
+
+// reviewRules answers the SelfSubjectRulesReview of the preflight check with the given rules
+func reviewRules(client *fake.Clientset, rules []authorizationv1.ResourceRule) {
+	client.PrependReactor("create", "selfsubjectrulesreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectRulesReview).DeepCopy()
+		review.Status.ResourceRules = rules
+		return true, review, nil
+	})
+}
+
+// simulateJobRun plays the part of the job controller and kubelet: once a job is created it creates
+// a pod for it, moves the pod through Pending, Running and Terminated, and marks the job finished.
+// Statuses are re-published until ctx is done so watchers established late still observe them.
+func simulateJobRun(ctx context.Context, t *testing.T, client *fake.Clientset, exitCode int32) {
+	t.Helper()
+	simulateJobRuns(ctx, t, client, func(*batchv1.Job) int32 { return exitCode })
+}
+
+// simulateJobRuns simulates every job created, each exiting with the code exitCode returns for it
+func simulateJobRuns(ctx context.Context, t *testing.T, client *fake.Clientset, exitCode func(*batchv1.Job) int32) {
+	t.Helper()
+
+	watcher, err := client.BatchV1().Jobs("").Watch(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+
+	go func() {
+		// Keep draining the watch, which also sees the status updates, so its channel cannot fill up
+		defer watcher.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-watcher.ResultChan():
+				if job, ok := event.Object.(*batchv1.Job); ok && event.Type == watch.Added {
+					go simulatePod(ctx, client, job, exitCode(job))
+				}
+			}
+		}
+	}()
+}
+
+// simulatePod runs the pod of a single simulated job
+func simulatePod(ctx context.Context, client *fake.Clientset, job *batchv1.Job, exitCode int32) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      job.Name + "-abcde",
+			Namespace: job.Namespace,
+			Labels:    map[string]string{"job-name": job.Name},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodPending},
+	}
+	for key, value := range job.Spec.Template.Labels {
+		pod.Labels[key] = value
+	}
+	pod, err := client.CoreV1().Pods(job.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return
+	}
+
+	states := []corev1.ContainerState{
+		{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+		{Running: &corev1.ContainerStateRunning{}},
+		{Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode}},
+	}
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	for tick := 0; ; tick++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		state := states[min(tick, len(states)-1)]
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+			{Name: generate.TestRunnerContainerName, State: state, Ready: state.Running != nil},
+		}
+		// Service sidecars are up for the whole run
+		pod.Status.InitContainerStatuses = nil
+		for _, container := range job.Spec.Template.Spec.InitContainers {
+			if container.RestartPolicy != nil {
+				pod.Status.InitContainerStatuses = append(pod.Status.InitContainerStatuses, corev1.ContainerStatus{
+					Name:  container.Name,
+					State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+					Ready: true,
+				})
+			}
+		}
+		pod.Annotations = map[string]string{"tick": time.Now().String()}
+		if _, err := client.CoreV1().Pods(pod.Namespace).UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+			return
+		}
+
+		if state.Terminated != nil {
+			current, err := client.BatchV1().Jobs(job.Namespace).Get(ctx, job.Name, metav1.GetOptions{})
+			if err != nil {
+				return
+			}
+			current.Status = batchv1.JobStatus{Succeeded: 1}
+			if exitCode != 0 {
+				current.Status = batchv1.JobStatus{Failed: 1, Conditions: []batchv1.JobCondition{
+					{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: batchv1.JobReasonBackoffLimitExceeded},
+				}}
+			}
+			current.Annotations = map[string]string{"tick": time.Now().String()}
+			if _, err := client.BatchV1().Jobs(job.Namespace).UpdateStatus(ctx, current, metav1.UpdateOptions{}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func launchConfig(ctx context.Context) config.Config {
+	return config.Config{
+		Namespace:       "ket-lifecycle-test",
+		ProjectRoot:     "app",
+		Image:           "test-image:latest",
+		TestCommand:     "npm test",
+		BackoffLimit:    1,
+		ActiveDeadlineS: 60,
+		WorkspacePath:   "/workspace",
+		Ctx:             ctx,
+	}
+}
+
+func TestRunLaunch_SuccessfulRunCleansUp(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	simulateJobRun(ctx, t, client, 0)
+
+	err := RunLaunchWithClient(launchConfig(ctx), fakeClientFactory(client))
+	require.NoError(t, err)
+
+	_, err = client.CoreV1().Namespaces().Get(ctx, "ket-lifecycle-test", metav1.GetOptions{})
+	assert.Error(t, err, "namespace should be deleted after the run")
+
+	jobs, err := client.BatchV1().Jobs("ket-lifecycle-test").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, jobs.Items, "job should be deleted after the run")
+
+	roles, err := client.RbacV1().ClusterRoles().List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, roles.Items, "per-run ClusterRole should be deleted")
+
+	bindings, err := client.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, bindings.Items, "per-run ClusterRoleBinding should be deleted")
+}
+
+func TestRunLaunch_FailedRunReturnsExitCode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	simulateJobRun(ctx, t, client, 3)
+
+	err := RunLaunchWithClient(launchConfig(ctx), fakeClientFactory(client))
+	require.Error(t, err)
+
+	var testErr *TestExecutionError
+	require.True(t, errors.As(err, &testErr), "expected a TestExecutionError, got %v", err)
+	assert.Equal(t, 3, testErr.ExitCode)
+	assert.Equal(t, results.FailureTestFailed, testErr.Category)
+}
+
+func TestRunLaunch_KeepNamespace(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	simulateJobRun(ctx, t, client, 0)
+
+	cfg := launchConfig(ctx)
+	cfg.KeepNamespace = true
+	require.NoError(t, RunLaunchWithClient(cfg, fakeClientFactory(client)))
+
+	ns, err := client.CoreV1().Namespaces().Get(ctx, "ket-lifecycle-test", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, generate.ManagedByValue, ns.Labels[generate.ManagedByLabel])
+}
+
+func TestRunLaunch_KeepsExistingNamespace(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// A namespace created by someone else, holding the claim a pvc source mode run reads from
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ket-lifecycle-test"}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "project-source", Namespace: "ket-lifecycle-test"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "registry-creds", Namespace: "ket-lifecycle-test"}},
+	)
+	simulateJobRun(ctx, t, client, 0)
+
+	cfg := launchConfig(ctx)
+	cfg.Secrets = []config.SecretConfig{{Name: "api-credentials", Literals: []string{"API_TOKEN=abc"}}}
+	require.NoError(t, RunLaunchWithClient(cfg, fakeClientFactory(client)))
+
+	background := context.Background()
+	_, err := client.CoreV1().Namespaces().Get(background, "ket-lifecycle-test", metav1.GetOptions{})
+	require.NoError(t, err, "a namespace the run did not create must not be deleted")
+	_, err = client.CoreV1().PersistentVolumeClaims("ket-lifecycle-test").Get(background, "project-source", metav1.GetOptions{})
+	require.NoError(t, err)
+	_, err = client.CoreV1().Secrets("ket-lifecycle-test").Get(background, "registry-creds", metav1.GetOptions{})
+	require.NoError(t, err, "secrets the run did not create are kept")
+
+	_, err = client.CoreV1().Secrets("ket-lifecycle-test").Get(background, "api-credentials", metav1.GetOptions{})
+	assert.Error(t, err, "secrets the run created should be deleted")
+	_, err = client.CoreV1().ServiceAccounts("ket-lifecycle-test").Get(background, generate.TestRunnerServiceAccountName, metav1.GetOptions{})
+	assert.Error(t, err, "the test runner's service account should be deleted")
+	jobs, err := client.BatchV1().Jobs("ket-lifecycle-test").List(background, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, jobs.Items, "the run's jobs should be deleted")
+}
+
+func TestRunLaunch_RerunInKeptNamespace(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The namespace an earlier run with keepNamespace left behind, with its RBAC and secrets
+	run := generate.RunMetadata{Namespace: "ket-lifecycle-test"}
+	earlier := generate.RBAC(run, generate.RBACRules{})
+	client := fake.NewSimpleClientset(
+		generate.Namespace(run),
+		earlier.ServiceAccount, earlier.Role, earlier.RoleBinding,
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "api-credentials", Namespace: "ket-lifecycle-test"},
+			Data:       map[string][]byte{"API_TOKEN": []byte("abc")},
+		},
+	)
+	simulateJobRun(ctx, t, client, 0)
+
+	cfg := launchConfig(ctx)
+	cfg.KeepNamespace = true
+	cfg.Secrets = []config.SecretConfig{{Name: "api-credentials", Literals: []string{"API_TOKEN=def"}}}
+	require.NoError(t, RunLaunchWithClient(cfg, fakeClientFactory(client)))
+
+	secret, err := client.CoreV1().Secrets("ket-lifecycle-test").Get(context.Background(), "api-credentials", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "def", string(secret.Data["API_TOKEN"]), "the secret is updated to the rerun's values")
+}
+
+func TestRunLaunch_ClientFactoryError(t *testing.T) {
+	failing := func(cfg config.Config) (*Clients, error) {
+		return nil, errors.New("no cluster")
+	}
+
+	err := RunLaunchWithClient(config.Config{}, failing)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no cluster")
+}
+
+func TestRunLaunch_NamespaceCreationFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+
+	err := RunLaunchWithClient(launchConfig(ctx), fakeClientFactory(client))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to create namespace")
+	var testErr *TestExecutionError
+	require.True(t, errors.As(err, &testErr), "expected a TestExecutionError, got %v", err)
+	assert.Equal(t, results.FailureInfraError, testErr.Category)
+	assert.Equal(t, results.ExitCodeInfraError, testErr.ExitCode, "only failing tests exit 1")
+
+	for _, action := range client.Actions() {
+		assert.NotEqual(t, "jobs", action.GetResource().Resource, "no job should be created when the namespace fails")
+	}
+}
+
+func TestRunLaunch_AppliesFixturesBeforeJob(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fixture := filepath.Join(t.TempDir(), "mongodb.yml")
+	require.NoError(t, os.WriteFile(fixture, []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: mongodb-config
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: mongodb
+`), 0o644))
+
+	// The fixture deployment reports itself available as soon as it is watched
+	client := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "mongodb", Namespace: "ket-lifecycle-test"},
+		Status:     appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+	})
+	simulateJobRun(ctx, t, client, 0)
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(scheme.Scheme)
+	var applied []string
+	dynamicClient.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		assert.Equal(t, types.ApplyPatchType, patch.GetPatchType())
+		assert.Equal(t, "ket-lifecycle-test", patch.GetNamespace())
+		applied = append(applied, patch.GetResource().Resource+"/"+patch.GetName())
+		return true, &unstructured.Unstructured{Object: map[string]interface{}{}}, nil
+	})
+
+	cfg := launchConfig(ctx)
+	cfg.Fixtures = []string{fixture}
+	err := RunLaunchWithClient(cfg, func(cfg config.Config) (*Clients, error) {
+		reviewAccess(client, nil)
+		return &Clients{
+			Kube:       client,
+			Dynamic:    dynamicClient,
+			Mapper:     testrestmapper.TestOnlyStaticRESTMapper(scheme.Scheme),
+			RestConfig: &rest.Config{},
+		}, nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"configmaps/mongodb-config", "deployments/mongodb"}, applied)
+}
+
+func TestRunLaunch_DeletesClusterScopedFixtures(t *testing.T) {
+	for _, keepNamespace := range []bool{false, true} {
+		t.Run(fmt.Sprintf("keepNamespace=%v", keepNamespace), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			fixture := filepath.Join(t.TempDir(), "storage.yml")
+			require.NoError(t, os.WriteFile(fixture, []byte(`apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: ket-fast
+`), 0o644))
+
+			client := fake.NewSimpleClientset()
+			simulateJobRun(ctx, t, client, 0)
+
+			dynamicClient := dynamicfake.NewSimpleDynamicClient(scheme.Scheme)
+			dynamicClient.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, &unstructured.Unstructured{Object: map[string]interface{}{}}, nil
+			})
+			var deleted []string
+			dynamicClient.PrependReactor("delete", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				deleted = append(deleted, action.GetResource().Resource+"/"+action.(k8stesting.DeleteAction).GetName())
+				return true, nil, nil
+			})
+
+			cfg := launchConfig(ctx)
+			cfg.Fixtures = []string{fixture}
+			cfg.KeepNamespace = keepNamespace
+			err := RunLaunchWithClient(cfg, func(cfg config.Config) (*Clients, error) {
+				reviewAccess(client, nil)
+				return &Clients{
+					Kube:       client,
+					Dynamic:    dynamicClient,
+					Mapper:     testrestmapper.TestOnlyStaticRESTMapper(scheme.Scheme),
+					RestConfig: &rest.Config{},
+				}, nil
+			})
+			require.NoError(t, err)
+
+			if keepNamespace {
+				assert.Empty(t, deleted, "cluster-scoped fixtures are kept along with the namespace")
+			} else {
+				assert.Equal(t, []string{"storageclasses/ket-fast"}, deleted)
+			}
+		})
+	}
+}
+
+func TestRunLaunch_CancellationCleansUp(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// No pod ever starts, so the launch blocks waiting for the test runner until cancelled
+	client := fake.NewSimpleClientset()
+	watcher, err := client.BatchV1().Jobs("").Watch(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	go func() {
+		<-watcher.ResultChan()
+		watcher.Stop()
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	err = RunLaunchWithClient(launchConfig(ctx), fakeClientFactory(client))
+	var testErr *TestExecutionError
+	require.True(t, errors.As(err, &testErr), "expected a TestExecutionError, got %v", err)
+	assert.Equal(t, results.FailureCancelled, testErr.Category)
+	assert.Equal(t, results.ExitCodeCancelled, testErr.ExitCode)
+
+	background := context.Background()
+	_, err = client.CoreV1().Namespaces().Get(background, "ket-lifecycle-test", metav1.GetOptions{})
+	assert.Error(t, err, "namespace should be deleted after cancellation")
+
+	jobs, err := client.BatchV1().Jobs("ket-lifecycle-test").List(background, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, jobs.Items, "job should be deleted after cancellation")
+
+	roles, err := client.RbacV1().ClusterRoles().List(background, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, roles.Items, "per-run ClusterRole should be deleted after cancellation")
+
+	var deletePolicy *metav1.DeletionPropagation
+	for _, action := range client.Actions() {
+		if del, ok := action.(k8stesting.DeleteAction); ok && action.GetResource().Resource == "jobs" {
+			deletePolicy = del.GetDeleteOptions().PropagationPolicy
+		}
+	}
+	require.NotNil(t, deletePolicy)
+	assert.Equal(t, metav1.DeletePropagationForeground, *deletePolicy)
+}
+
+func TestRunLaunch_KeepNamespaceKeepsFailedJob(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	simulateJobRun(ctx, t, client, 1)
+
+	cfg := launchConfig(ctx)
+	cfg.KeepNamespace = true
+	require.Error(t, RunLaunchWithClient(cfg, fakeClientFactory(client)))
+
+	jobs, err := client.BatchV1().Jobs("ket-lifecycle-test").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, jobs.Items, 1, "failed job should be kept for debugging")
+}
+
+func TestCleanupRun_ReportsResourcesNotRemoved(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ket-lifecycle-test"}},
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "ket-app", Namespace: "ket-lifecycle-test"}},
+	)
+	client.PrependReactor("delete", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+
+	resources := runResources{
+		namespace:        "ket-lifecycle-test",
+		namespaceCreated: true,
+		jobs:             []*batchv1.Job{{ObjectMeta: metav1.ObjectMeta{Name: "ket-app", Namespace: "ket-lifecycle-test"}}},
+	}
+	failures := cleanupRun(&Clients{Kube: client}, config.Config{CleanupTimeout: 5}, resources, false)
+	require.Len(t, failures, 1)
+	assert.Contains(t, failures[0].Error(), "namespace ket-lifecycle-test")
+	assert.Contains(t, failures[0].Error(), "forbidden")
+
+	_, err := client.BatchV1().Jobs("ket-lifecycle-test").Get(context.Background(), "ket-app", metav1.GetOptions{})
+	assert.Error(t, err, "job should still be removed when the namespace cannot be")
+}
+
+func TestCleanupRun_DeletesClusterScopedFixtures(t *testing.T) {
+	storageClasses := schema.GroupVersionResource{Group: "storage.k8s.io", Version: "v1", Resource: "storageclasses"}
+	fixture := &unstructured.Unstructured{}
+	fixture.SetAPIVersion("storage.k8s.io/v1")
+	fixture.SetKind("StorageClass")
+	fixture.SetName("ket-fast")
+	clients := &Clients{
+		Kube:    fake.NewSimpleClientset(),
+		Dynamic: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), fixture),
+	}
+	resources := runResources{
+		namespace:       "ket-lifecycle-test",
+		clusterFixtures: []apply.ClusterObject{{Resource: storageClasses, Kind: "StorageClass", Name: "ket-fast"}},
+	}
+
+	require.Empty(t, cleanupRun(clients, config.Config{CleanupTimeout: 5, KeepNamespace: true}, resources, true))
+	_, err := clients.Dynamic.Resource(storageClasses).Get(context.Background(), "ket-fast", metav1.GetOptions{})
+	require.NoError(t, err, "cluster-scoped fixtures are kept along with the namespace")
+
+	require.Empty(t, cleanupRun(clients, config.Config{CleanupTimeout: 5}, resources, false))
+	_, err = clients.Dynamic.Resource(storageClasses).Get(context.Background(), "ket-fast", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "cluster-scoped fixtures should be deleted, got %v", err)
+}
+
+func TestCleanupTimeout(t *testing.T) {
+	assert.Equal(t, defaultCleanupTimeout, cleanupTimeout(config.Config{}))
+	assert.Equal(t, 5*time.Second, cleanupTimeout(config.Config{CleanupTimeout: 5}))
+}
+
+func TestRunGC_DryRunKeepsResources(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	run := generate.RunMetadata{Namespace: "expired", CreatedAt: time.Now().Add(-2 * time.Hour), TTL: time.Hour}
+	_, err := client.CoreV1().Namespaces().Create(context.Background(), generate.Namespace(run), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	cfg := config.Config{GC: config.GCConfig{DryRun: true}}
+	require.NoError(t, RunGCWithClient(cfg, fakeClientFactory(client)))
+	_, err = client.CoreV1().Namespaces().Get(context.Background(), "expired", metav1.GetOptions{})
+	assert.NoError(t, err, "dry run must not delete anything")
+
+	cfg.GC.DryRun = false
+	require.NoError(t, RunGCWithClient(cfg, fakeClientFactory(client)))
+	_, err = client.CoreV1().Namespaces().Get(context.Background(), "expired", metav1.GetOptions{})
+	assert.Error(t, err, "expired namespace should be deleted")
+}
+
+func TestRunLaunch_LabelsRunWithTTL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	simulateJobRun(ctx, t, client, 0)
+
+	cfg := launchConfig(ctx)
+	cfg.TTL = 90 * time.Minute
+	cfg.KeepNamespace = true
+	require.NoError(t, RunLaunchWithClient(cfg, fakeClientFactory(client)))
+
+	namespaces, err := client.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, namespaces.Items, 1)
+	ns := namespaces.Items[0]
+	assert.NotEmpty(t, ns.Labels[generate.RunIDLabel])
+	assert.Equal(t, "1h30m0s", ns.Annotations[generate.TTLAnnotation])
+	assert.NotEmpty(t, ns.Annotations[generate.CreatedAtAnnotation])
+}
+
+func TestRunLaunch_ShardsAggregateExitCodes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	simulateJobRuns(ctx, t, client, func(job *batchv1.Job) int32 {
+		if job.Labels[generate.ShardIndexLabel] == "1" {
+			return 4
+		}
+		return 0
+	})
+
+	cfg := launchConfig(ctx)
+	cfg.Shards = 3
+	cfg.SummaryFile = filepath.Join(t.TempDir(), "summary.json")
+	err := RunLaunchWithClient(cfg, fakeClientFactory(client))
+	require.Error(t, err)
+
+	var testErr *TestExecutionError
+	require.True(t, errors.As(err, &testErr), "expected a TestExecutionError, got %v", err)
+	assert.Equal(t, 4, testErr.ExitCode, "the failed shard's exit code is the run's exit code")
+	assert.Contains(t, testErr.Message, "1 of 3 jobs failed")
+
+	data, err := os.ReadFile(cfg.SummaryFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"job": "ket-app-shard-2"`)
+
+	jobs, err := client.BatchV1().Jobs("ket-lifecycle-test").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, jobs.Items, "every shard's job should be deleted after the run")
+}
+
+func TestRunLaunch_MatrixSharedNamespace(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	simulateJobRuns(ctx, t, client, func(job *batchv1.Job) int32 {
+		if job.Spec.Template.Spec.Containers[0].Image == "node:20" {
+			return 2
+		}
+		return 0
+	})
+
+	cfg := launchConfig(ctx)
+	cfg.Matrix = config.MatrixConfig{Images: []string{"node:18", "node:20"}}
+	cfg.SummaryFile = filepath.Join(t.TempDir(), "summary.json")
+	err := RunLaunchWithClient(cfg, fakeClientFactory(client))
+
+	var testErr *TestExecutionError
+	require.True(t, errors.As(err, &testErr), "expected a TestExecutionError, got %v", err)
+	assert.Equal(t, 2, testErr.ExitCode)
+
+	data, err := os.ReadFile(cfg.SummaryFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"cell": "node:20"`)
+}
+
+func TestRunLaunch_MatrixSeparateNamespaces(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	simulateJobRun(ctx, t, client, 0)
+
+	var namespaces []string
+	client.PrependReactor("create", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		namespaces = append(namespaces, action.(k8stesting.CreateAction).GetObject().(*corev1.Namespace).Name)
+		return false, nil, nil
+	})
+
+	cfg := launchConfig(ctx)
+	cfg.Matrix = config.MatrixConfig{
+		Env:                []config.MatrixAxis{{Name: "DB", Values: []string{"mongo", "postgres"}}},
+		SeparateNamespaces: true,
+	}
+	require.NoError(t, RunLaunchWithClient(cfg, fakeClientFactory(client)))
+
+	assert.ElementsMatch(t, []string{"ket-lifecycle-test-m0", "ket-lifecycle-test-m1"}, namespaces)
+	remaining, err := client.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, remaining.Items, "every cell's namespace should be cleaned up")
+}
+
+func TestMatrixRows_GroupsJobsByCell(t *testing.T) {
+	cells := config.MatrixConfig{Images: []string{"node:18", "node:20"}}.Cells()
+	outcome := func(cell string, success bool, exitCode int) shardOutcome {
+		return shardOutcome{
+			job:     &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{generate.MatrixCellLabel: cell}}},
+			summary: &results.Summary{Success: success, ExitCode: exitCode, Total: 2, Passed: 1, Failed: 1},
+		}
+	}
+
+	rows := matrixRows(cells, []shardOutcome{
+		outcome("0", true, 0), outcome("1", true, 0), outcome("1", false, 5), outcome("1", false, 6),
+	})
+
+	require.Len(t, rows, 2)
+	assert.True(t, rows[0].success)
+	assert.False(t, rows[1].success)
+	assert.Equal(t, 5, rows[1].exitCode, "a cell's exit code is that of its first failed job")
+	assert.Equal(t, 6, rows[1].total)
+}
+
+func TestCellDiagnosticsDest(t *testing.T) {
+	cell := config.MatrixCell{Index: 2}
+	assert.Equal(t, "", cellDiagnosticsDest("", cell))
+	assert.Equal(t, filepath.Join("diag", "m2"), cellDiagnosticsDest("diag", cell))
+	assert.Equal(t, "out/diag-m2.tar.gz", cellDiagnosticsDest("out/diag.tar.gz", cell))
+	assert.Equal(t, "diag-m2.tgz", cellDiagnosticsDest("diag.tgz", cell))
+}
+
+func TestCellRBACAuditDest(t *testing.T) {
+	cell := config.MatrixCell{Index: 2}
+	assert.Equal(t, "", cellRBACAuditDest("", cell))
+	assert.Equal(t, "out/rbac-m2.yaml", cellRBACAuditDest("out/rbac.yaml", cell))
+	assert.Equal(t, "rbac-m2", cellRBACAuditDest("rbac", cell))
+}
+
+func TestRunLaunch_RBACAudit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	simulateJobRun(ctx, t, client, 0)
+
+	cfg := launchConfig(ctx)
+	cfg.RbacAudit.Enabled = true
+	require.NoError(t, RunLaunchWithClient(cfg, fakeClientFactory(client)))
+
+	var auditRead bool
+	for _, action := range client.Actions() {
+		if get, ok := action.(k8stesting.GenericAction); ok && action.GetSubresource() == "log" {
+			options, _ := get.GetValue().(*corev1.PodLogOptions)
+			auditRead = auditRead || (options != nil && options.Container == generate.RBACAuditContainerName)
+		}
+	}
+	assert.True(t, auditRead, "the audited requests are read from the proxy sidecar")
+}
+
+func TestReportRBACAudit_WritesSuggestedFile(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "rbac.yaml")
+	cfg := config.Config{RbacAudit: config.RBACAuditConfig{Enabled: true, Output: output}}
+	outcomes := []shardOutcome{
+		{audited: []generate.AuditedRequest{{Verb: "list", Resource: "pods", Namespace: "ns"}}},
+		{audited: []generate.AuditedRequest{{Verb: "get", Resource: "nodes", Name: "node-1"}}},
+	}
+
+	reportRBACAudit(cfg, "ns", generate.RBACRules{Rules: generate.GetTestRunnerRBACRules()}, outcomes)
+
+	rules, err := generate.LoadRBACRulesFromFile(output)
+	require.NoError(t, err, "the suggested file can be passed back with --rbac")
+	assert.Equal(t, []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list"}}}, rules.Rules)
+	assert.Equal(t, []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"get"}}}, rules.ClusterRules)
+}
+
+func TestRunLaunch_PreflightAbortsBeforeCreatingAnything(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	cfg := launchConfig(ctx)
+	cfg.Secrets = []config.SecretConfig{{Name: "api-credentials", Literals: []string{"API_TOKEN=abc"}}}
+	err := RunLaunchWithClient(cfg, func(cfg config.Config) (*Clients, error) {
+		clients, err := fakeClientFactory(client)(cfg)
+		reviewAccess(client, func(attributes *authorizationv1.ResourceAttributes) bool {
+			return attributes.Resource == "rolebindings" || (attributes.Resource == "pods" && attributes.Subresource == "log")
+		})
+		return clients, err
+	})
+	require.ErrorContains(t, err, "missing 2 permissions")
+
+	for _, action := range client.Actions() {
+		assert.Contains(t, []string{"selfsubjectaccessreviews", "selfsubjectrulesreviews"}, action.GetResource().Resource,
+			"nothing is created when permissions are missing")
+	}
+}
+
+func TestRunLaunch_SkipPreflight(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	simulateJobRun(ctx, t, client, 0)
+
+	cfg := launchConfig(ctx)
+	cfg.SkipPreflight = true
+	require.NoError(t, RunLaunchWithClient(cfg, fakeClientFactory(client)))
+	for _, action := range client.Actions() {
+		assert.NotContains(t, []string{"selfsubjectaccessreviews", "selfsubjectrulesreviews"}, action.GetResource().Resource)
+	}
+}
+
+func TestPreflightChecks(t *testing.T) {
+	cfg := config.Config{Source: config.SourceConfig{Mode: config.SourceModeUpload}}
+	objects := &generate.RunObjects{
+		Namespace: generate.Namespace(generate.RunMetadata{Namespace: "ns"}),
+		RunRBAC:   generate.RBAC(generate.RunMetadata{Namespace: "ns"}, generate.RBACRules{}),
+		Fixtures: []*unstructured.Unstructured{
+			{Object: map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": map[string]interface{}{"name": "a"}}},
+			{Object: map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": map[string]interface{}{"name": "b"}}},
+			{Object: map[string]interface{}{"apiVersion": "rbac.authorization.k8s.io/v1", "kind": "ClusterRole", "metadata": map[string]interface{}{"name": "c"}}},
+		},
+	}
+
+	var checks []string
+	for _, check := range preflightChecks(cfg, testrestmapper.TestOnlyStaticRESTMapper(scheme.Scheme), objects) {
+		checks = append(checks, fmt.Sprintf("%s %s %s", check.Verb, check.ResourceName(), check.Namespace))
+	}
+	assert.Equal(t, []string{
+		"create namespaces ",
+		"delete namespaces ",
+		"create serviceaccounts ns",
+		"create roles.rbac.authorization.k8s.io ns",
+		"create rolebindings.rbac.authorization.k8s.io ns",
+		"create deployments.apps ns",
+		"create clusterroles.rbac.authorization.k8s.io ",
+		"delete clusterroles.rbac.authorization.k8s.io ",
+		"create jobs.batch ns",
+		"watch jobs.batch ns",
+		"delete jobs.batch ns",
+		"watch pods ns",
+		"get pods/log ns",
+		"create pods/exec ns",
+	}, checks, "fixtures of the same kind are checked once")
+
+	cfg = config.Config{KeepNamespace: true}
+	objects.RunRBAC = generate.RBAC(generate.RunMetadata{Namespace: "ns"}, generate.RBACRules{ClusterRules: []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"get"}},
+	}})
+	objects.Fixtures = nil
+	secrets, err := generate.Secrets(config.Config{Secrets: []config.SecretConfig{{Name: "s", Literals: []string{"A=b"}}}}, generate.RunMetadata{Namespace: "ns"})
+	require.NoError(t, err)
+	objects.Secrets = secrets
+	checks = nil
+	for _, check := range preflightChecks(cfg, testrestmapper.TestOnlyStaticRESTMapper(scheme.Scheme), objects) {
+		checks = append(checks, check.Verb+" "+check.ResourceName())
+	}
+	assert.NotContains(t, checks, "delete namespaces", "a kept namespace is not deleted")
+	assert.Contains(t, checks, "create clusterrolebindings.rbac.authorization.k8s.io")
+	assert.Contains(t, checks, "create secrets")
+	assert.NotContains(t, checks, "create pods/exec")
+}
+
+func TestRunDoctor_RulesNotHeld(t *testing.T) {
+	rbacFile := filepath.Join(t.TempDir(), "rbac.yaml")
+	require.NoError(t, os.WriteFile(rbacFile, []byte(`clusterRules:
+  - apiGroups: ["storage.k8s.io"]
+    resources: ["storageclasses"]
+    verbs: ["get"]
+`), 0o644))
+	cfg := config.Config{Namespace: "ns", RbacFiles: []string{rbacFile}}
+	held := []authorizationv1.ResourceRule{
+		{Verbs: []string{"*"}, APIGroups: []string{"", "apps", "batch", "networking.k8s.io"}, Resources: []string{"*"}},
+	}
+	newClient := func(escalate bool) ClientFactory {
+		return func(cfg config.Config) (*Clients, error) {
+			client := fake.NewSimpleClientset()
+			clients, err := fakeClientFactory(client)(cfg)
+			reviewAccess(client, func(attributes *authorizationv1.ResourceAttributes) bool {
+				return !escalate && (attributes.Verb == "escalate" || attributes.Verb == "bind")
+			})
+			reviewRules(client, held)
+			return clients, err
+		}
+	}
+
+	err := RunDoctorWithClient(cfg, newClient(false))
+	assert.ErrorContains(t, err, "missing 1 of ", "the cluster-wide rule on storage classes is not held")
+
+	require.NoError(t, RunDoctorWithClient(cfg, newClient(true)), "escalating clusterroles allows granting it anyway")
+}
+
+func TestRunDoctor(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	require.NoError(t, RunDoctorWithClient(config.Config{Namespace: "ns"}, fakeClientFactory(client)))
+
+	err := RunDoctorWithClient(config.Config{Namespace: "ns"}, func(cfg config.Config) (*Clients, error) {
+		clients, err := fakeClientFactory(client)(cfg)
+		reviewAccess(client, func(attributes *authorizationv1.ResourceAttributes) bool {
+			return attributes.Resource == "namespaces"
+		})
+		return clients, err
+	})
+	assert.ErrorContains(t, err, "missing 2 of 10 permissions")
+}
+
+func TestRunLaunch_CreatesSecrets(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	simulateJobRun(ctx, t, client, 0)
+
+	cfg := launchConfig(ctx)
+	cfg.KeepNamespace = true
+	cfg.Secrets = []config.SecretConfig{{Name: "api-credentials", Literals: []string{"API_TOKEN=abc"}}}
+	require.NoError(t, RunLaunchWithClient(cfg, fakeClientFactory(client)))
+
+	secret, err := client.CoreV1().Secrets("ket-lifecycle-test").Get(context.Background(), "api-credentials", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abc"), secret.Data["API_TOKEN"])
+	assert.Equal(t, generate.ManagedByValue, secret.Labels[generate.ManagedByLabel])
+}
+
+func TestRunLaunch_AppliesPatches(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	// The job only passes if it was created with the patch applied
+	simulateJobRuns(ctx, t, client, func(job *batchv1.Job) int32 {
+		if job.Spec.Template.Spec.PriorityClassName == "ci-low" {
+			return 0
+		}
+		return 1
+	})
+
+	cfg := launchConfig(ctx)
+	cfg.KeepNamespace = true
+	cfg.Patches = []config.PatchConfig{
+		{Target: config.PatchTarget{Kind: "Job"}, Patch: "spec:\n  template:\n    spec:\n      priorityClassName: ci-low"},
+		{Target: config.PatchTarget{Kind: "Namespace"}, Patch: "metadata:\n  labels:\n    pod-security.kubernetes.io/enforce: restricted"},
+	}
+	require.NoError(t, RunLaunchWithClient(cfg, fakeClientFactory(client)))
+
+	ns, err := client.CoreV1().Namespaces().Get(context.Background(), "ket-lifecycle-test", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "restricted", ns.Labels["pod-security.kubernetes.io/enforce"])
+}
+
+func TestRunLaunch_InvalidPatchCreatesNothing(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	cfg := launchConfig(context.Background())
+	cfg.Patches = []config.PatchConfig{{Target: config.PatchTarget{Kind: "Deployment"}, Patch: "metadata: {}"}}
+	err := RunLaunchWithClient(cfg, fakeClientFactory(client))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "matches no generated objects")
+	var testErr *TestExecutionError
+	assert.False(t, errors.As(err, &testErr), "an invalid config is not an infrastructure failure")
+	assert.Empty(t, client.Actions(), "nothing should be created for an invalid patch")
+}
+
+func TestRunLaunch_WaitsForServices(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	simulateJobRun(ctx, t, client, 0)
+
+	cfg := launchConfig(ctx)
+	cfg.Services = []config.ServiceConfig{{
+		Name:           "postgres",
+		Image:          "postgres:16-alpine",
+		ReadinessProbe: &config.ProbeConfig{TCPPort: 5432},
+	}}
+	require.NoError(t, RunLaunchWithClient(cfg, fakeClientFactory(client)))
+}
+
+func TestRunLaunch_FailsFastOnImagePull(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	watcher, err := client.BatchV1().Jobs("").Watch(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	go func() {
+		defer watcher.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-watcher.ResultChan():
+				job, ok := event.Object.(*batchv1.Job)
+				if !ok || event.Type != watch.Added {
+					continue
+				}
+				// The pod never gets past pulling the test image
+				_, _ = client.CoreV1().Pods(job.Namespace).Create(ctx, &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      job.Name + "-abcde",
+						Namespace: job.Namespace,
+						Labels:    map[string]string{"job-name": job.Name},
+					},
+					Status: corev1.PodStatus{
+						Phase: corev1.PodPending,
+						ContainerStatuses: []corev1.ContainerStatus{{
+							Name: generate.TestRunnerContainerName,
+							State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+								Reason:  "ImagePullBackOff",
+								Message: `Back-off pulling image "test-image:latest"`,
+							}},
+						}},
+					},
+				}, metav1.CreateOptions{})
+			}
+		}
+	}()
+
+	err = RunLaunchWithClient(launchConfig(ctx), fakeClientFactory(client))
+	require.Error(t, err)
+
+	var testErr *TestExecutionError
+	require.True(t, errors.As(err, &testErr), "expected a TestExecutionError, got %v", err)
+	assert.Equal(t, results.FailureImagePull, testErr.Category)
+	assert.Equal(t, results.ExitCodeImagePull, testErr.ExitCode)
+	assert.Contains(t, testErr.Error(), `Back-off pulling image "test-image:latest"`)
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"testrunner/pkg/config"
+	"testrunner/pkg/kube/generate"
 	"testrunner/pkg/kube/manifest"
 	"testrunner/pkg/logger"
 )
@@ -14,15 +15,30 @@ func RunManifest(cfg config.Config) error {
 	// Set log level to SILENT in manifest mode for clean output
 	logger.SetGlobalLevel(logger.SILENT)
 
-	namespace := generateTestNamespace(cfg)
-
-	manifests, err := manifest.All(cfg, namespace)
-	if err != nil {
-		return fmt.Errorf("failed to generate test manifests: %w", err)
+	// Matrix cells run in separate namespaces get a complete set of manifests each
+	configs := []config.Config{cfg}
+	if cells := cfg.Matrix.Cells(); len(cells) > 0 && cfg.Matrix.SeparateNamespaces {
+		configs = configs[:0]
+		for _, cell := range cells {
+			cellCfg := cfg.WithCell(cell)
+			if cellCfg.Namespace != "" {
+				cellCfg.Namespace = fmt.Sprintf("%s-m%d", cellCfg.Namespace, cell.Index)
+			}
+			configs = append(configs, cellCfg)
+		}
 	}
 
-	for _, manifest := range manifests {
-		fmt.Fprintf(os.Stdout, "---\n%s\n", manifest)
+	for _, runCfg := range configs {
+		namespace := generateTestNamespace(runCfg)
+
+		manifests, err := manifest.All(runCfg, generate.NewRunMetadata(namespace, runCfg.TTL))
+		if err != nil {
+			return fmt.Errorf("failed to generate test manifests: %w", err)
+		}
+
+		for _, manifest := range manifests {
+			fmt.Fprintf(os.Stdout, "---\n%s\n", manifest)
+		}
 	}
 
 	return nil
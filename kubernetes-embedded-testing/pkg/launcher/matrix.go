@@ -0,0 +1,155 @@
+package launcher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"testrunner/pkg/config"
+	"testrunner/pkg/kube/generate"
+	"testrunner/pkg/logger"
+	"testrunner/pkg/results"
+)
+
+// launchCells runs every matrix cell in its own test namespace, in parallel. If a cell cannot be run,
+// the others are stopped and the first such error is returned.
+func launchCells(ctx context.Context, clients *Clients, cfg config.Config, cells []config.MatrixCell) ([]shardOutcome, error) {
+	logger.LauncherLogger.Info("Running %d matrix cells in separate namespaces", len(cells))
+
+	cellCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cellOutcomes := make([][]shardOutcome, len(cells))
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for i, cell := range cells {
+		cellCfg := cfg.WithCell(cell)
+		if cellCfg.Namespace != "" {
+			cellCfg.Namespace = fmt.Sprintf("%s-m%d", cellCfg.Namespace, cell.Index)
+		}
+		cellCfg.DiagnosticsDir = cellDiagnosticsDest(cfg.DiagnosticsDir, cell)
+		cellCfg.RbacAudit.Output = cellRBACAuditDest(cfg.RbacAudit.Output, cell)
+
+		wg.Add(1)
+		go func(i int, cellCfg config.Config) {
+			defer wg.Done()
+			outcomes, err := launchRun(cellCtx, clients, cellCfg)
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("matrix cell %s: %w", cellCfg.Cell.Name(), err)
+					cancel()
+				})
+				return
+			}
+			cellOutcomes[i] = outcomes
+		}(i, cellCfg)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	var outcomes []shardOutcome
+	for _, cellOutcome := range cellOutcomes {
+		outcomes = append(outcomes, cellOutcome...)
+	}
+	return outcomes, nil
+}
+
+// cellDiagnosticsDest returns where the diagnostics of a matrix cell run in its own namespace are written,
+// so the bundles of different cells do not overwrite each other
+func cellDiagnosticsDest(dest string, cell config.MatrixCell) string {
+	if dest == "" {
+		return ""
+	}
+	suffix := fmt.Sprintf("m%d", cell.Index)
+	for _, ext := range []string{".tar.gz", ".tgz"} {
+		if strings.HasSuffix(dest, ext) {
+			return strings.TrimSuffix(dest, ext) + "-" + suffix + ext
+		}
+	}
+	return filepath.Join(dest, suffix)
+}
+
+// cellRBACAuditDest returns where the suggested RBAC file of a matrix cell run in its own namespace is
+// written, so the files of different cells do not overwrite each other
+func cellRBACAuditDest(dest string, cell config.MatrixCell) string {
+	if dest == "" {
+		return ""
+	}
+	ext := filepath.Ext(dest)
+	return fmt.Sprintf("%s-m%d%s", strings.TrimSuffix(dest, ext), cell.Index, ext)
+}
+
+// matrixRow is the combined outcome of a matrix cell's jobs
+type matrixRow struct {
+	cell     config.MatrixCell
+	success  bool
+	exitCode int
+	category results.FailureCategory
+	passed   int
+	failed   int
+	total    int
+}
+
+// matrixRows groups the job outcomes by matrix cell. A cell fails if any of its jobs failed, with the
+// exit code of its first failed job.
+func matrixRows(cells []config.MatrixCell, outcomes []shardOutcome) []matrixRow {
+	rows := make([]matrixRow, len(cells))
+	for i, cell := range cells {
+		rows[i] = matrixRow{cell: cell, success: true}
+	}
+
+	for _, outcome := range outcomes {
+		index, err := strconv.Atoi(outcome.job.Labels[generate.MatrixCellLabel])
+		if err != nil || index < 0 || index >= len(rows) {
+			continue
+		}
+		row := &rows[index]
+		if !outcome.summary.Success && row.success {
+			row.success = false
+			row.exitCode = outcome.summary.ExitCode
+			row.category = outcome.summary.Category
+		}
+		row.passed += outcome.summary.Passed
+		row.failed += outcome.summary.Failed
+		row.total += outcome.summary.Total
+	}
+	return rows
+}
+
+// reportMatrix prints a table of the result of every matrix cell
+func reportMatrix(cells []config.MatrixCell, outcomes []shardOutcome) {
+	var buf bytes.Buffer
+	writer := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "CELL\tRESULT\tEXIT CODE\tTESTS")
+
+	failedCells := 0
+	for _, row := range matrixRows(cells, outcomes) {
+		result := "passed"
+		if !row.success {
+			result = fmt.Sprintf("failed (%s)", row.category)
+			failedCells++
+		}
+		tests := "-"
+		if row.total > 0 {
+			tests = fmt.Sprintf("%d passed, %d failed, %d total", row.passed, row.failed, row.total)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%s\n", row.cell.Name(), result, row.exitCode, tests)
+	}
+	writer.Flush()
+
+	logger.LauncherLogger.Info("Matrix results: %d of %d cells passed", len(cells)-failedCells, len(cells))
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		logger.LauncherLogger.Info("  %s", line)
+	}
+}
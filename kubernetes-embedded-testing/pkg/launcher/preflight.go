@@ -0,0 +1,250 @@
+package launcher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"testrunner/pkg/config"
+	"testrunner/pkg/kube/apply"
+	"testrunner/pkg/kube/generate"
+	"testrunner/pkg/logger"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RunDoctor checks that the invoking user is allowed to do everything a launch with the config does,
+// printing a table of every permission and failing if any is missing
+func RunDoctor(cfg config.Config) error {
+	return RunDoctorWithClient(cfg, NewClient)
+}
+
+// RunDoctorWithClient checks the permissions a launch needs using clients created by newClient
+func RunDoctorWithClient(cfg config.Config, newClient ClientFactory) error {
+	ctx := context.Background()
+	if cfg.Ctx != nil {
+		ctx = cfg.Ctx
+	}
+
+	logger.ConfigureFromConfig(cfg.Logging.Prefix, cfg.Logging.Timestamp)
+
+	if cfg.Debug {
+		logger.SetGlobalLevel(logger.DEBUG)
+	}
+
+	clients, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	objects, err := generate.Objects(cfg, generate.NewRunMetadata(generateTestNamespace(cfg), cfg.TTL))
+	if err != nil {
+		return err
+	}
+
+	results, err := checkLaunchPermissions(ctx, clients, cfg, objects)
+	if err != nil {
+		return err
+	}
+	reportPermissions(results)
+
+	if missing := missingPermissions(results); len(missing) > 0 {
+		return fmt.Errorf("missing %d of %d permissions needed to launch the tests", len(missing), len(results))
+	}
+	logger.LauncherLogger.Info("All %d permissions needed to launch the tests are granted", len(results))
+	return nil
+}
+
+// preflight checks that the invoking user may create and clean up the run's objects, and fails with a
+// table of the missing permissions before anything is created
+func preflight(ctx context.Context, clients *Clients, cfg config.Config, objects *generate.RunObjects) error {
+	results, err := checkLaunchPermissions(ctx, clients, cfg, objects)
+	if err != nil {
+		return fmt.Errorf("preflight check failed: %w", err)
+	}
+
+	missing := missingPermissions(results)
+	if len(missing) == 0 {
+		logger.LauncherLogger.Debug("Preflight check passed: %d permissions granted", len(results))
+		return nil
+	}
+	logger.LauncherLogger.Error("Missing %d permissions needed to run the tests:", len(missing))
+	reportPermissions(missing)
+	return fmt.Errorf("preflight check failed: missing %d permissions, nothing was created", len(missing))
+}
+
+// checkLaunchPermissions checks the actions a launch of the run's objects takes, and that the invoking
+// user may grant the rules of the run's Roles
+func checkLaunchPermissions(ctx context.Context, clients *Clients, cfg config.Config, objects *generate.RunObjects) ([]apply.PermissionResult, error) {
+	results, err := apply.CheckPermissions(ctx, clients.Kube, preflightChecks(cfg, clients.Mapper, objects))
+	if err != nil {
+		return nil, err
+	}
+	grants, err := checkRuleGrants(ctx, clients.Kube, objects)
+	if err != nil {
+		return nil, err
+	}
+	return append(results, grants...), nil
+}
+
+// checkRuleGrants checks that the invoking user holds every rule the run's Role and ClusterRole grant,
+// since the API server refuses to create a role granting more than its creator holds. When rules are
+// not held, the user may still escalate and bind the role kind instead; otherwise each rule that is
+// not held is reported as missing.
+func checkRuleGrants(ctx context.Context, client kubernetes.Interface, objects *generate.RunObjects) ([]apply.PermissionResult, error) {
+	namespace := objects.Namespace.Name
+	held, incomplete, err := apply.HeldRules(ctx, client, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	type grant struct {
+		rules     []rbacv1.PolicyRule
+		resource  string
+		namespace string
+		purpose   string
+	}
+	grants := []grant{{objects.Role.Rules, "roles", namespace, "grant the test runner its rules"}}
+	if objects.ClusterRole != nil {
+		grants = append(grants, grant{objects.ClusterRole.Rules, "clusterroles", "", "grant the test runner its cluster-wide rules"})
+	}
+
+	var results []apply.PermissionResult
+	for _, grant := range grants {
+		uncovered := generate.UncoveredRules(held, grant.rules)
+		if len(uncovered) == 0 {
+			continue
+		}
+		escalation, err := apply.CheckPermissions(ctx, client, []apply.PermissionCheck{
+			{Verb: "escalate", Group: rbacv1.GroupName, Resource: grant.resource, Namespace: grant.namespace, Purpose: grant.purpose + " you do not hold"},
+			{Verb: "bind", Group: rbacv1.GroupName, Resource: grant.resource, Namespace: grant.namespace, Purpose: grant.purpose + " you do not hold"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(missingPermissions(escalation)) == 0 {
+			results = append(results, escalation...)
+			continue
+		}
+		if incomplete {
+			logger.LauncherLogger.Warn("Could not tell whether you hold all %d rules needed to %s", len(uncovered), grant.purpose)
+			continue
+		}
+		for _, rule := range uncovered {
+			results = append(results, apply.PermissionResult{PermissionCheck: ruleCheck(rule, grant.namespace, grant.purpose+", unless you may escalate and bind "+grant.resource)})
+		}
+	}
+	return results, nil
+}
+
+// ruleCheck describes a rule with a single verb and resource or non-resource URL as a permission check
+func ruleCheck(rule rbacv1.PolicyRule, namespace, purpose string) apply.PermissionCheck {
+	check := apply.PermissionCheck{Verb: rule.Verbs[0], Namespace: namespace, Purpose: purpose}
+	if len(rule.NonResourceURLs) > 0 {
+		check.Resource = rule.NonResourceURLs[0]
+		return check
+	}
+	check.Group = rule.APIGroups[0]
+	check.Resource, check.Subresource, _ = strings.Cut(rule.Resources[0], "/")
+	return check
+}
+
+// preflightChecks lists the actions a launch of the run's objects takes: creating the namespace, RBAC,
+// secrets, fixtures and jobs, following the tests and cleaning up after them
+func preflightChecks(cfg config.Config, mapper meta.RESTMapper, objects *generate.RunObjects) []apply.PermissionCheck {
+	namespace := objects.Namespace.Name
+	var checks []apply.PermissionCheck
+	seen := map[apply.PermissionCheck]bool{}
+	add := func(verb, group, resource, subresource, checkNamespace, purpose string) {
+		check := apply.PermissionCheck{Verb: verb, Group: group, Resource: resource, Subresource: subresource, Namespace: checkNamespace}
+		if seen[check] {
+			return
+		}
+		seen[check] = true
+		check.Purpose = purpose
+		checks = append(checks, check)
+	}
+
+	add("create", "", "namespaces", "", "", "create the test namespace")
+	if !cfg.KeepNamespace {
+		add("delete", "", "namespaces", "", "", "clean up the test namespace")
+	}
+	add("create", "", "serviceaccounts", "", namespace, "create the test runner's ServiceAccount")
+	add("create", "rbac.authorization.k8s.io", "roles", "", namespace, "grant the test runner its rules")
+	add("create", "rbac.authorization.k8s.io", "rolebindings", "", namespace, "grant the test runner its rules")
+	if objects.ClusterRole != nil {
+		add("create", "rbac.authorization.k8s.io", "clusterroles", "", "", "grant the test runner its cluster-wide rules")
+		add("create", "rbac.authorization.k8s.io", "clusterrolebindings", "", "", "grant the test runner its cluster-wide rules")
+		add("delete", "rbac.authorization.k8s.io", "clusterroles", "", "", "clean up the cluster-wide rules")
+		add("delete", "rbac.authorization.k8s.io", "clusterrolebindings", "", "", "clean up the cluster-wide rules")
+	}
+	if len(objects.Secrets) > 0 {
+		add("create", "", "secrets", "", namespace, "create the configured secrets")
+	}
+
+	for _, fixture := range objects.Fixtures {
+		gvk := fixture.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			// Applying the fixture reports the unknown kind
+			continue
+		}
+		purpose := fmt.Sprintf("apply the %s fixtures", gvk.Kind)
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			add("create", mapping.Resource.Group, mapping.Resource.Resource, "", namespace, purpose)
+			continue
+		}
+		add("create", mapping.Resource.Group, mapping.Resource.Resource, "", "", purpose)
+		if !cfg.KeepNamespace {
+			add("delete", mapping.Resource.Group, mapping.Resource.Resource, "", "", fmt.Sprintf("clean up the %s fixtures", gvk.Kind))
+		}
+	}
+
+	add("create", "batch", "jobs", "", namespace, "create the test jobs")
+	add("watch", "batch", "jobs", "", namespace, "wait for the test jobs to finish")
+	add("delete", "batch", "jobs", "", namespace, "clean up the test jobs")
+	add("watch", "", "pods", "", namespace, "follow the test pods")
+	add("get", "", "pods", "log", namespace, "stream the test output")
+	if cfg.Source.Mode == config.SourceModeUpload || generate.ReportsCollectionEnabled(cfg) {
+		add("create", "", "pods", "exec", namespace, "upload the source and collect the reports")
+	}
+	return checks
+}
+
+// missingPermissions returns the results of the checks that were not allowed
+func missingPermissions(results []apply.PermissionResult) []apply.PermissionResult {
+	var missing []apply.PermissionResult
+	for _, result := range results {
+		if !result.Allowed {
+			missing = append(missing, result)
+		}
+	}
+	return missing
+}
+
+// reportPermissions prints a table of the permission checks and whether they were allowed
+func reportPermissions(results []apply.PermissionResult) {
+	var buf bytes.Buffer
+	writer := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "RESULT\tVERB\tRESOURCE\tNAMESPACE\tNEEDED TO")
+	for _, result := range results {
+		status := "allowed"
+		if !result.Allowed {
+			status = "missing"
+		}
+		namespace := result.Namespace
+		if namespace == "" {
+			namespace = "(cluster)"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", status, result.Verb, result.ResourceName(), namespace, result.Purpose)
+	}
+	writer.Flush()
+
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		logger.LauncherLogger.Info("  %s", line)
+	}
+}
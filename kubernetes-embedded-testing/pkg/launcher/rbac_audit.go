@@ -0,0 +1,67 @@
+package launcher
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"testrunner/pkg/config"
+	"testrunner/pkg/kube/generate"
+	"testrunner/pkg/logger"
+
+	"sigs.k8s.io/yaml"
+)
+
+// reportRBACAudit prints the RBAC file the audited requests of a run's jobs suggest, along with the
+// granted rules none of them needed, and writes the suggested file to the configured output
+func reportRBACAudit(cfg config.Config, namespace string, granted generate.RBACRules, outcomes []shardOutcome) {
+	var requests []generate.AuditedRequest
+	for _, outcome := range outcomes {
+		requests = append(requests, outcome.audited...)
+	}
+	if len(requests) == 0 {
+		logger.LauncherLogger.Warn("RBAC audit recorded no API requests; only clients that load $KUBECONFIG go through the auditing proxy")
+		return
+	}
+
+	report := generate.AuditRBAC(namespace, granted, requests)
+	logger.LauncherLogger.Info("RBAC audit: %d API requests recorded", report.Requests)
+	for _, request := range report.Denied {
+		logger.LauncherLogger.Warn("  DENIED: %s", request)
+	}
+
+	suggested, err := yaml.Marshal(generate.RBACRulesFile{Rules: report.Suggested.Rules, ClusterRules: report.Suggested.ClusterRules})
+	if err != nil {
+		logger.LauncherLogger.Warn("Failed to write the suggested RBAC rules: %v", err)
+		return
+	}
+	logger.LauncherLogger.Info("Suggested RBAC file (use with --rbac-replace-defaults):")
+	logYAML(suggested)
+
+	if len(report.Unused.Rules) == 0 && len(report.Unused.ClusterRules) == 0 {
+		logger.LauncherLogger.Info("Every granted rule was needed")
+	} else {
+		unused, err := yaml.Marshal(generate.RBACRulesFile{Rules: report.Unused.Rules, ClusterRules: report.Unused.ClusterRules})
+		if err != nil {
+			logger.LauncherLogger.Warn("Failed to write the unused RBAC rules: %v", err)
+			return
+		}
+		logger.LauncherLogger.Info("Granted rules no request needed:")
+		logYAML(unused)
+	}
+
+	if cfg.RbacAudit.Output != "" {
+		if err := os.WriteFile(cfg.RbacAudit.Output, suggested, 0o644); err != nil {
+			logger.LauncherLogger.Warn("%v", fmt.Errorf("failed to write suggested RBAC file: %w", err))
+			return
+		}
+		logger.LauncherLogger.Info("Wrote suggested RBAC file to %s", cfg.RbacAudit.Output)
+	}
+}
+
+// logYAML prints a YAML document indented under the preceding log line
+func logYAML(document []byte) {
+	for _, line := range strings.Split(strings.TrimRight(string(document), "\n"), "\n") {
+		logger.LauncherLogger.Info("  %s", line)
+	}
+}
@@ -0,0 +1,65 @@
+package launcher
+
+import (
+	"path/filepath"
+
+	"testrunner/pkg/config"
+	"testrunner/pkg/kube/apply"
+	"testrunner/pkg/kube/generate"
+	"testrunner/pkg/logger"
+	"testrunner/pkg/results"
+
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// buildSummary combines the job result with the parsed results file, if one was configured
+func buildSummary(cfg config.Config, job *batchv1.Job, result *apply.TestResult, reportsDir string) *results.Summary {
+	summary := &results.Summary{
+		Namespace: job.Namespace,
+		Job:       job.Name,
+		Cell:      job.Annotations[generate.MatrixCellAnnotation],
+		Success:   result.Success,
+		ExitCode:  result.ExitCode,
+		Category:  result.Category,
+		Reason:    result.Reason,
+		Pod:       result.Pod,
+		Attempt:   result.Attempt,
+	}
+
+	if cfg.Results.Format == "" || reportsDir == "" {
+		return summary
+	}
+
+	file := cfg.Results.File
+	if file == "" {
+		file = results.DefaultFile(cfg.Results.Format)
+	}
+
+	tests, err := results.ParseFile(cfg.Results.Format, filepath.Join(reportsDir, file))
+	if err != nil {
+		logger.LauncherLogger.Warn("Failed to parse test results: %v", err)
+		return summary
+	}
+	summary.AddTests(tests)
+
+	return summary
+}
+
+// reportSummary prints the summary and writes it to the configured summary file
+func reportSummary(cfg config.Config, summary *results.Summary) {
+	if cfg.Results.Format != "" {
+		logger.LauncherLogger.Info("Test summary: %d total, %d passed, %d failed, %d skipped (%.2fs)",
+			summary.Total, summary.Passed, summary.Failed, summary.Skipped, summary.DurationSeconds)
+		for _, name := range summary.FailedTests {
+			logger.LauncherLogger.Info("  FAILED: %s", name)
+		}
+	}
+
+	if cfg.SummaryFile != "" {
+		if err := summary.WriteJSON(cfg.SummaryFile); err != nil {
+			logger.LauncherLogger.Warn("%v", err)
+			return
+		}
+		logger.LauncherLogger.Info("Wrote run summary to %s", cfg.SummaryFile)
+	}
+}
@@ -0,0 +1,57 @@
+package launcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"testrunner/pkg/config"
+	"testrunner/pkg/kube/apply"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildSummary_ParsesResultsFromReportsDir(t *testing.T) {
+	reportsDir := t.TempDir()
+	report := `<testsuite name="api"><testcase name="ok" time="1"/><testcase name="broken"><failure message="boom"/></testcase></testsuite>`
+	require.NoError(t, os.WriteFile(filepath.Join(reportsDir, "junit.xml"), []byte(report), 0o644))
+
+	cfg := config.Config{Results: config.ResultsConfig{Format: "junit"}}
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "ket-app", Namespace: "test-namespace"}}
+	result := &apply.TestResult{ExitCode: 1, Error: errors.New("failed")}
+
+	summary := buildSummary(cfg, job, result, reportsDir)
+
+	assert.Equal(t, "test-namespace", summary.Namespace)
+	assert.Equal(t, "ket-app", summary.Job)
+	assert.Equal(t, 1, summary.ExitCode)
+	assert.False(t, summary.Success)
+	assert.Equal(t, 2, summary.Total)
+	assert.Equal(t, 1, summary.Failed)
+	assert.Equal(t, []string{"api broken"}, summary.FailedTests)
+}
+
+func TestBuildSummary_WithoutResultsFormat(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "ket-app", Namespace: "test-namespace"}}
+	result := &apply.TestResult{Success: true}
+
+	summary := buildSummary(config.Config{}, job, result, t.TempDir())
+
+	assert.True(t, summary.Success)
+	assert.Zero(t, summary.Total)
+	assert.Empty(t, summary.Tests)
+}
+
+func TestBuildSummary_MissingResultsFile(t *testing.T) {
+	cfg := config.Config{Results: config.ResultsConfig{Format: "tap", File: "missing.tap"}}
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "ket-app", Namespace: "test-namespace"}}
+
+	summary := buildSummary(cfg, job, &apply.TestResult{Success: true}, t.TempDir())
+
+	assert.True(t, summary.Success)
+	assert.Zero(t, summary.Total)
+}
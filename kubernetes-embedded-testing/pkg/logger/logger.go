@@ -115,16 +115,32 @@ func (l *Logger) Error(format string, args ...interface{}) {
 
 // StreamLogs streams logs from an io.Reader with the logger's prefix format
 func (l *Logger) StreamLogs(reader io.Reader) {
+	l.StreamLogsWithPrefix(reader, "")
+}
+
+// StreamLogsWithPrefix streams logs from an io.Reader with the logger's prefix format, putting
+// linePrefix in front of every line so interleaved streams can be told apart
+func (l *Logger) StreamLogsWithPrefix(reader io.Reader, linePrefix string) {
 	if l.level == SILENT {
 		return
 	}
 
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
-		line := scanner.Text()
-		formattedLine := l.formatMessage(INFO, "%s", line)
-		fmt.Println(formattedLine)
+		l.WriteLine(linePrefix, scanner.Text())
+	}
+}
+
+// WriteLine prints a single line of streamed output with the logger's prefix format, putting
+// linePrefix in front of it like StreamLogsWithPrefix
+func (l *Logger) WriteLine(linePrefix, line string) {
+	if l.level == SILENT {
+		return
+	}
+	if linePrefix != "" {
+		line = linePrefix + " " + line
 	}
+	fmt.Println(l.formatMessage(INFO, "%s", line))
 }
 
 func SetGlobalLevel(level LogLevel) {
@@ -227,3 +227,21 @@ func TestLogger_StreamLogs(t *testing.T) {
 	assert.NotContains(t, outputStr2, "[TESTRUNNER]")
 	assert.Contains(t, outputStr2, "no prefix line")
 }
+
+func TestLogger_StreamLogsWithPrefix(t *testing.T) {
+	logger := New(TESTRUNNER)
+	logger.SetPrefix(false)
+	logger.SetTimestamp(false)
+
+	originalStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	defer func() { os.Stdout = originalStdout }()
+
+	logger.StreamLogsWithPrefix(strings.NewReader("first\nsecond"), "[shard-1]")
+	w.Close()
+
+	var output bytes.Buffer
+	output.ReadFrom(r)
+	assert.Equal(t, "[shard-1] first\n[shard-1] second\n", output.String())
+}
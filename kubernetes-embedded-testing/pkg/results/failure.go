@@ -0,0 +1,70 @@
+package results
+
+// FailureCategory classifies why a test job failed, so CI can retry infrastructure failures but not
+// failing tests
+type FailureCategory string
+
+// Failure categories
+const (
+	// FailureTestFailed means the test command ran and exited non-zero
+	FailureTestFailed FailureCategory = "test-failed"
+	// FailureInfraError covers failures of the cluster rather than the tests, such as eviction
+	FailureInfraError FailureCategory = "infra-error"
+	// FailureTimeout means the job exceeded its active deadline
+	FailureTimeout FailureCategory = "timeout"
+	// FailureOOM means the test container was killed for exceeding its memory limit
+	FailureOOM FailureCategory = "oom"
+	// FailureImagePull means an image of the test pod could not be pulled
+	FailureImagePull FailureCategory = "image-pull"
+	// FailureScheduling means the test pod could not be scheduled onto a node
+	FailureScheduling FailureCategory = "scheduling"
+	// FailureCancelled means the run was interrupted
+	FailureCancelled FailureCategory = "cancelled"
+)
+
+// Process exit codes of failures other than failing tests, which exit with the test command's code
+const (
+	// ExitCodeTestFailed is the exit code of failing tests whose own code is unknown or is one of the
+	// codes reserved for the other categories
+	ExitCodeTestFailed = 1
+	ExitCodeInfraError = 70
+	ExitCodeImagePull  = 71
+	ExitCodeScheduling = 72
+	ExitCodeOOM        = 73
+	ExitCodeTimeout    = 124
+	ExitCodeCancelled  = 130
+)
+
+// ExitCode returns the process exit code for a failure of this category. Failing tests exit with
+// the test command's own exit code, or ExitCodeTestFailed if it is unknown or would be mistaken for
+// another category, as for a test command that exits 124 under timeout(1).
+func (c FailureCategory) ExitCode(testExitCode int) int {
+	switch c {
+	case FailureInfraError:
+		return ExitCodeInfraError
+	case FailureImagePull:
+		return ExitCodeImagePull
+	case FailureScheduling:
+		return ExitCodeScheduling
+	case FailureOOM:
+		return ExitCodeOOM
+	case FailureTimeout:
+		return ExitCodeTimeout
+	case FailureCancelled:
+		return ExitCodeCancelled
+	}
+	if testExitCode == 0 || reservedExitCodes[testExitCode] {
+		return ExitCodeTestFailed
+	}
+	return testExitCode
+}
+
+// reservedExitCodes are the exit codes of the categories other than failing tests
+var reservedExitCodes = map[int]bool{
+	ExitCodeInfraError: true,
+	ExitCodeImagePull:  true,
+	ExitCodeScheduling: true,
+	ExitCodeOOM:        true,
+	ExitCodeTimeout:    true,
+	ExitCodeCancelled:  true,
+}
@@ -0,0 +1,113 @@
+package results
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type junitTestSuites struct {
+	Suites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name   string           `xml:"name,attr"`
+	Cases  []junitTestCase  `xml:"testcase"`
+	Suites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	Skipped   *junitMessage `xml:"skipped"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// ParseJUnit parses a JUnit XML report with either a <testsuites> or a <testsuite> root element
+func ParseJUnit(reader io.Reader) ([]TestCase, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JUnit report: %w", err)
+	}
+
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse JUnit report: %w", err)
+	}
+
+	var suites []junitTestSuite
+	switch root.XMLName.Local {
+	case "testsuites":
+		var parsed junitTestSuites
+		if err := xml.Unmarshal(data, &parsed); err != nil {
+			return nil, fmt.Errorf("failed to parse JUnit report: %w", err)
+		}
+		suites = parsed.Suites
+	case "testsuite":
+		var parsed junitTestSuite
+		if err := xml.Unmarshal(data, &parsed); err != nil {
+			return nil, fmt.Errorf("failed to parse JUnit report: %w", err)
+		}
+		suites = []junitTestSuite{parsed}
+	default:
+		return nil, fmt.Errorf("unexpected JUnit root element <%s>", root.XMLName.Local)
+	}
+
+	var tests []TestCase
+	for _, suite := range suites {
+		tests = appendJUnitSuite(tests, suite)
+	}
+	return tests, nil
+}
+
+// appendJUnitSuite appends the test cases of suite, including nested suites, to tests
+func appendJUnitSuite(tests []TestCase, suite junitTestSuite) []TestCase {
+	for _, jc := range suite.Cases {
+		tc := TestCase{
+			Name:            jc.Name,
+			Suite:           jc.ClassName,
+			Status:          StatusPassed,
+			DurationSeconds: parseSeconds(jc.Time),
+		}
+		if tc.Suite == "" {
+			tc.Suite = suite.Name
+		}
+
+		switch {
+		case jc.Failure != nil:
+			tc.Status = StatusFailed
+			tc.Message = jc.Failure.text()
+		case jc.Error != nil:
+			tc.Status = StatusFailed
+			tc.Message = jc.Error.text()
+		case jc.Skipped != nil:
+			tc.Status = StatusSkipped
+			tc.Message = jc.Skipped.text()
+		}
+
+		tests = append(tests, tc)
+	}
+
+	for _, nested := range suite.Suites {
+		tests = appendJUnitSuite(tests, nested)
+	}
+	return tests
+}
+
+// text returns the message attribute, falling back to the element body
+func (m *junitMessage) text() string {
+	if m.Message != "" {
+		return m.Message
+	}
+	return strings.TrimSpace(m.Body)
+}
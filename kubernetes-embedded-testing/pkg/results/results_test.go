@@ -0,0 +1,208 @@
+package results
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const junitReport = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites name="Mocha Tests" tests="4" failures="1">
+  <testsuite name="http server" tests="3">
+    <testcase name="responds to GET /" classname="http server" time="0.012"/>
+    <testcase name="rejects POST /" classname="http server" time="0.5">
+      <failure message="expected 405 but got 200">AssertionError: expected 405 but got 200</failure>
+    </testcase>
+    <testcase name="streams large bodies" classname="http server" time="0">
+      <skipped/>
+    </testcase>
+  </testsuite>
+  <testsuite name="mongo">
+    <testcase name="connects" time="1.25">
+      <error>connection refused</error>
+    </testcase>
+  </testsuite>
+</testsuites>`
+
+func TestParseJUnit_TestSuitesRoot(t *testing.T) {
+	tests, err := ParseJUnit(strings.NewReader(junitReport))
+	require.NoError(t, err)
+	require.Len(t, tests, 4)
+
+	assert.Equal(t, TestCase{Name: "responds to GET /", Suite: "http server", Status: StatusPassed, DurationSeconds: 0.012}, tests[0])
+	assert.Equal(t, StatusFailed, tests[1].Status)
+	assert.Equal(t, "expected 405 but got 200", tests[1].Message)
+	assert.Equal(t, StatusSkipped, tests[2].Status)
+	assert.Equal(t, "mongo", tests[3].Suite, "suite name should be used when classname is missing")
+	assert.Equal(t, StatusFailed, tests[3].Status)
+	assert.Equal(t, "connection refused", tests[3].Message)
+}
+
+func TestParseJUnit_TestSuiteRoot(t *testing.T) {
+	report := `<testsuite name="unit"><testcase name="adds" time="0.1"/></testsuite>`
+
+	tests, err := ParseJUnit(strings.NewReader(report))
+	require.NoError(t, err)
+	require.Len(t, tests, 1)
+	assert.Equal(t, "unit adds", tests[0].FullName())
+}
+
+func TestParseJUnit_InvalidRoot(t *testing.T) {
+	_, err := ParseJUnit(strings.NewReader(`<report/>`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected JUnit root element")
+}
+
+func TestParseTAP(t *testing.T) {
+	report := `TAP version 13
+1..5
+ok 1 - parses config
+  ---
+  duration_ms: 250
+  ...
+not ok 2 - applies fixtures
+    # Subtest: nested
+    not ok 1 - ignored subtest
+ok 3 - uploads source # SKIP no cluster
+ok 4 # TODO later
+ok 5 - cleans up
+`
+
+	tests, err := ParseTAP(strings.NewReader(report))
+	require.NoError(t, err)
+	require.Len(t, tests, 5)
+
+	assert.Equal(t, "parses config", tests[0].Name)
+	assert.Equal(t, 0.25, tests[0].DurationSeconds)
+	assert.Equal(t, StatusFailed, tests[1].Status)
+	assert.Equal(t, StatusSkipped, tests[2].Status)
+	assert.Equal(t, "SKIP no cluster", tests[2].Message)
+	assert.Equal(t, "test 4", tests[3].Name)
+	assert.Equal(t, StatusSkipped, tests[3].Status)
+	assert.Equal(t, StatusPassed, tests[4].Status)
+}
+
+func TestParseTAP_HashInDescription(t *testing.T) {
+	report := `1..4
+ok 1 - handles issue #42
+not ok 2 - C# \# SKIP is escaped
+ok 3 - parses #hashtags # skip flaky on CI
+ok 4 - path\\ # todo
+`
+
+	tests, err := ParseTAP(strings.NewReader(report))
+	require.NoError(t, err)
+	require.Len(t, tests, 4)
+
+	assert.Equal(t, "handles issue #42", tests[0].Name)
+	assert.Equal(t, StatusPassed, tests[0].Status)
+	assert.Equal(t, "C# # SKIP is escaped", tests[1].Name)
+	assert.Equal(t, StatusFailed, tests[1].Status)
+	assert.Equal(t, "parses #hashtags", tests[2].Name)
+	assert.Equal(t, StatusSkipped, tests[2].Status)
+	assert.Equal(t, "skip flaky on CI", tests[2].Message)
+	assert.Equal(t, `path\`, tests[3].Name)
+	assert.Equal(t, StatusSkipped, tests[3].Status)
+}
+
+func TestSummary_AddTestsAndWriteJSON(t *testing.T) {
+	tests, err := ParseJUnit(strings.NewReader(junitReport))
+	require.NoError(t, err)
+
+	summary := &Summary{Namespace: "test-namespace", Job: "ket-app", ExitCode: 1}
+	summary.AddTests(tests)
+
+	assert.Equal(t, 4, summary.Total)
+	assert.Equal(t, 1, summary.Passed)
+	assert.Equal(t, 2, summary.Failed)
+	assert.Equal(t, 1, summary.Skipped)
+	assert.InDelta(t, 1.762, summary.DurationSeconds, 0.0001)
+	assert.Equal(t, []string{"http server rejects POST /", "mongo connects"}, summary.FailedTests)
+
+	path := filepath.Join(t.TempDir(), "out", "summary.json")
+	require.NoError(t, summary.WriteJSON(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var decoded Summary
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, summary.Total, decoded.Total)
+	assert.Equal(t, summary.FailedTests, decoded.FailedTests)
+	assert.Len(t, decoded.Tests, 4)
+}
+
+func TestSummary_WriteJSONWithoutTests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.json")
+	summary := &Summary{Namespace: "test-namespace", Success: true}
+	require.NoError(t, summary.WriteJSON(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"failedTests": []`)
+	assert.Contains(t, string(data), `"tests": []`)
+}
+
+func TestMergeShards(t *testing.T) {
+	passed := &Summary{Namespace: "test-namespace", Job: "ket-app-shard-0", Success: true}
+	passed.AddTests([]TestCase{{Name: "a", Status: StatusPassed}, {Name: "b", Status: StatusPassed}})
+	failed := &Summary{Namespace: "test-namespace", Job: "ket-app-shard-1", ExitCode: 3}
+	failed.AddTests([]TestCase{{Name: "c", Status: StatusFailed}})
+	alsoFailed := &Summary{Namespace: "test-namespace", Job: "ket-app-shard-2", ExitCode: 7}
+
+	merged := MergeShards([]*Summary{passed, failed, alsoFailed})
+
+	assert.Equal(t, "test-namespace", merged.Namespace)
+	assert.Equal(t, "ket-app-shard-0,ket-app-shard-1,ket-app-shard-2", merged.Job)
+	assert.False(t, merged.Success)
+	assert.Equal(t, 3, merged.ExitCode, "the first failed shard's exit code is reported")
+	assert.Equal(t, 3, merged.Total)
+	assert.Equal(t, []string{"c"}, merged.FailedTests)
+	require.Len(t, merged.Shards, 3)
+	assert.Equal(t, ShardSummary{Index: 1, Namespace: "test-namespace", Job: "ket-app-shard-1", ExitCode: 3, Total: 1, Failed: 1}, merged.Shards[1])
+
+	assert.Same(t, passed, MergeShards([]*Summary{passed}), "an unsharded summary is returned unchanged")
+}
+
+func TestMergeShards_TestFailuresTakePrecedence(t *testing.T) {
+	infra := &Summary{Job: "ket-app-shard-0", ExitCode: ExitCodeImagePull, Category: FailureImagePull, Reason: "ImagePullBackOff"}
+	tests := &Summary{Job: "ket-app-shard-1", ExitCode: 2, Category: FailureTestFailed, Reason: "exit code 2", Pod: "ket-app-shard-1-abcde", Attempt: 2}
+
+	merged := MergeShards([]*Summary{infra, tests})
+	assert.Equal(t, 2, merged.ExitCode, "a run with failing tests must not look like an infrastructure failure")
+	assert.Equal(t, FailureTestFailed, merged.Category)
+	assert.Equal(t, "exit code 2", merged.Reason)
+	assert.Equal(t, "ket-app-shard-1-abcde", merged.Shards[1].Pod)
+	assert.Equal(t, 2, merged.Shards[1].Attempt)
+
+	merged = MergeShards([]*Summary{infra, {Job: "ket-app-shard-1", ExitCode: ExitCodeOOM, Category: FailureOOM}})
+	assert.Equal(t, ExitCodeImagePull, merged.ExitCode, "otherwise the first failed shard is reported")
+	assert.Equal(t, FailureImagePull, merged.Category)
+}
+
+func TestFailureCategory_ExitCode(t *testing.T) {
+	assert.Equal(t, 3, FailureTestFailed.ExitCode(3))
+	assert.Equal(t, 1, FailureTestFailed.ExitCode(0), "failed tests never exit 0")
+	for _, reserved := range []int{ExitCodeInfraError, ExitCodeImagePull, ExitCodeScheduling, ExitCodeOOM, ExitCodeTimeout, ExitCodeCancelled} {
+		assert.Equal(t, ExitCodeTestFailed, FailureTestFailed.ExitCode(reserved), "failed tests must not exit with the code of another category")
+	}
+	assert.Equal(t, ExitCodeInfraError, FailureInfraError.ExitCode(137))
+	assert.Equal(t, ExitCodeTimeout, FailureTimeout.ExitCode(143))
+	assert.Equal(t, ExitCodeOOM, FailureOOM.ExitCode(137))
+	assert.Equal(t, ExitCodeImagePull, FailureImagePull.ExitCode(0))
+	assert.Equal(t, ExitCodeScheduling, FailureScheduling.ExitCode(0))
+	assert.Equal(t, ExitCodeCancelled, FailureCancelled.ExitCode(0))
+}
+
+func TestParseFile_UnsupportedFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.txt")
+	require.NoError(t, os.WriteFile(path, []byte(""), 0o644))
+
+	_, err := ParseFile("csv", path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported results format")
+}
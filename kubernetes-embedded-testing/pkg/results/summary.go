@@ -0,0 +1,196 @@
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Supported test result formats
+const (
+	FormatJUnit = "junit"
+	FormatTAP   = "tap"
+)
+
+// Test case statuses
+const (
+	StatusPassed  = "passed"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// TestCase is a single test parsed from a results file
+type TestCase struct {
+	Name            string  `json:"name"`
+	Suite           string  `json:"suite,omitempty"`
+	Status          string  `json:"status"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Message         string  `json:"message,omitempty"`
+}
+
+// FullName returns the test name qualified with its suite
+func (tc TestCase) FullName() string {
+	if tc.Suite == "" {
+		return tc.Name
+	}
+	return tc.Suite + " " + tc.Name
+}
+
+// Summary is the structured outcome of a test run
+type Summary struct {
+	Namespace       string          `json:"namespace"`
+	Job             string          `json:"job"`
+	Cell            string          `json:"cell,omitempty"`
+	Success         bool            `json:"success"`
+	ExitCode        int             `json:"exitCode"`
+	Category        FailureCategory `json:"category,omitempty"`
+	Reason          string          `json:"reason,omitempty"`
+	Pod             string          `json:"pod,omitempty"`
+	Attempt         int             `json:"attempt,omitempty"`
+	Total           int             `json:"total"`
+	Passed          int             `json:"passed"`
+	Failed          int             `json:"failed"`
+	Skipped         int             `json:"skipped"`
+	DurationSeconds float64         `json:"durationSeconds"`
+	FailedTests     []string        `json:"failedTests"`
+	Tests           []TestCase      `json:"tests"`
+	// Shards holds the outcome of each job when the suite was sharded or run as a matrix
+	Shards []ShardSummary `json:"shards,omitempty"`
+}
+
+// ShardSummary is the outcome of a single job of a sharded or matrix run
+type ShardSummary struct {
+	Index     int             `json:"index"`
+	Namespace string          `json:"namespace"`
+	Job       string          `json:"job"`
+	Cell      string          `json:"cell,omitempty"`
+	Success   bool            `json:"success"`
+	ExitCode  int             `json:"exitCode"`
+	Category  FailureCategory `json:"category,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+	Pod       string          `json:"pod,omitempty"`
+	Attempt   int             `json:"attempt,omitempty"`
+	Total     int             `json:"total"`
+	Passed    int             `json:"passed"`
+	Failed    int             `json:"failed"`
+	Skipped   int             `json:"skipped"`
+}
+
+// MergeShards combines the summaries of each job, in order, into the summary of the whole run.
+// The run fails if any job failed, with the exit code and category of the first job whose tests
+// failed, or else of the first failed job, so a run with failing tests is never retried as an
+// infrastructure failure. A single summary is returned as is.
+func MergeShards(shards []*Summary) *Summary {
+	if len(shards) == 1 {
+		return shards[0]
+	}
+
+	merged := &Summary{Success: true}
+	var jobs []string
+	for i, shard := range shards {
+		if merged.Namespace == "" {
+			merged.Namespace = shard.Namespace
+		}
+		jobs = append(jobs, shard.Job)
+		if !shard.Success {
+			testsFailed := shard.Category == FailureTestFailed
+			if merged.Success || (testsFailed && merged.Category != FailureTestFailed) {
+				merged.ExitCode = shard.ExitCode
+				merged.Category = shard.Category
+				merged.Reason = shard.Reason
+			}
+			merged.Success = false
+		}
+		merged.AddTests(shard.Tests)
+		merged.Shards = append(merged.Shards, ShardSummary{
+			Index:     i,
+			Namespace: shard.Namespace,
+			Job:       shard.Job,
+			Cell:      shard.Cell,
+			Success:   shard.Success,
+			ExitCode:  shard.ExitCode,
+			Category:  shard.Category,
+			Reason:    shard.Reason,
+			Pod:       shard.Pod,
+			Attempt:   shard.Attempt,
+			Total:     shard.Total,
+			Passed:    shard.Passed,
+			Failed:    shard.Failed,
+			Skipped:   shard.Skipped,
+		})
+	}
+	merged.Job = strings.Join(jobs, ",")
+	return merged
+}
+
+// AddTests records the given test cases in the summary and updates its totals
+func (s *Summary) AddTests(tests []TestCase) {
+	for _, tc := range tests {
+		s.Tests = append(s.Tests, tc)
+		s.Total++
+		s.DurationSeconds += tc.DurationSeconds
+		switch tc.Status {
+		case StatusPassed:
+			s.Passed++
+		case StatusFailed:
+			s.Failed++
+			s.FailedTests = append(s.FailedTests, tc.FullName())
+		case StatusSkipped:
+			s.Skipped++
+		}
+	}
+}
+
+// DefaultFile returns the results file name, relative to /reports, used when none is configured
+func DefaultFile(format string) string {
+	if format == FormatTAP {
+		return "results.tap"
+	}
+	return "junit.xml"
+}
+
+// ParseFile parses a results file in the given format
+func ParseFile(format, path string) ([]TestCase, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open results file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	switch format {
+	case FormatJUnit:
+		return ParseJUnit(file)
+	case FormatTAP:
+		return ParseTAP(file)
+	default:
+		return nil, fmt.Errorf("unsupported results format %q (expected %s or %s)", format, FormatJUnit, FormatTAP)
+	}
+}
+
+// WriteJSON writes the summary as indented JSON to path
+func (s *Summary) WriteJSON(path string) error {
+	if s.FailedTests == nil {
+		s.FailedTests = []string{}
+	}
+	if s.Tests == nil {
+		s.Tests = []TestCase{}
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode summary: %w", err)
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create directory for summary file %s: %w", path, err)
+		}
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write summary file %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package results
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	tapResultLine = regexp.MustCompile(`^(not )?ok\b\s*(\d+)?\s*(?:-\s*)?(.*)$`)
+	tapDuration   = regexp.MustCompile(`^\s*duration_ms:\s*([0-9.]+)`)
+	tapDirective  = regexp.MustCompile(`(?i)^\s*(?:SKIP|TODO)`)
+)
+
+// ParseTAP parses a Test Anything Protocol stream. Subtest lines are ignored, SKIP and TODO
+// directives are reported as skipped, and duration_ms from YAML diagnostics is recorded.
+func ParseTAP(reader io.Reader) ([]TestCase, error) {
+	var tests []TestCase
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if match := tapDuration.FindStringSubmatch(line); match != nil && len(tests) > 0 {
+			tests[len(tests)-1].DurationSeconds = parseSeconds(match[1]) / 1000
+			continue
+		}
+
+		// Indented lines belong to subtests or diagnostics
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+
+		match := tapResultLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		description, directive := splitDirective(match[3])
+		tc := TestCase{
+			Name:   description,
+			Status: StatusPassed,
+		}
+		if tc.Name == "" {
+			tc.Name = "test " + match[2]
+		}
+
+		switch {
+		case directive != "":
+			tc.Status = StatusSkipped
+			tc.Message = directive
+		case match[1] != "":
+			tc.Status = StatusFailed
+		}
+
+		tests = append(tests, tc)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read TAP report: %w", err)
+	}
+	return tests, nil
+}
+
+// splitDirective separates a TAP description from a trailing "# SKIP"/"# TODO" directive. Any other
+// "#" belongs to the description, as do those escaped as "\#".
+func splitDirective(text string) (string, string) {
+	var description strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && i+1 < len(text) && (text[i+1] == '#' || text[i+1] == '\\'):
+			i++
+			description.WriteByte(text[i])
+		case text[i] == '#' && tapDirective.MatchString(text[i+1:]):
+			return strings.TrimSpace(description.String()), strings.TrimSpace(text[i+1:])
+		default:
+			description.WriteByte(text[i])
+		}
+	}
+	return strings.TrimSpace(description.String()), ""
+}
+
+// parseSeconds parses a decimal duration, returning zero when it is missing or malformed
+func parseSeconds(value string) float64 {
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0
+	}
+	return seconds
+}